@@ -1,119 +1,358 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/ant0ine/go-json-rest/rest"
 	"github.com/janvogt/gotambora/coding/types"
+	"mime"
 	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Api representa a rest service which can contain multiple resources.
 type Api struct {
-	routes []*rest.Route
+	Timeout     time.Duration // Timeout limits the time to handle a request, if it is positive. Streamed queries and untimed routes are not limited.
+	routes      []*rest.Route
+	untimed     map[*rest.Route]bool                       // untimed are the routes which may take longer than Timeout.
+	controllers map[string]types.ContextResourceController // controllers are the controllers of the resources by their endpoint.
 }
 
 // Handler returns the handler.
 func (s *Api) Handler() (handler *rest.ResourceHandler, err error) {
-	handler = &rest.ResourceHandler{}
-	err = handler.SetRoutes(s.routes...)
+	handler = &rest.ResourceHandler{EnableRelaxedContentType: true}
+	err = handler.SetRoutes(s.servedRoutes()...)
 	if err != nil {
 		handler = nil
 	}
 	return
 }
 
-// AddResource adds another resource on the given endpoint using the given Controller
-func (s *Api) AddResource(endpoint string, ctrl types.ResourceController) {
+// servedRoutes returns the routes of the api as served by its handler. They are limited by its timeout unless they are untimed. The content type of the handler is relaxed for the patch formats, so bodies of all other requests are checked to be JSON here.
+func (s *Api) servedRoutes() []*rest.Route {
+	routes := make([]*rest.Route, 0, len(s.routes))
+	for _, r := range s.routes {
+		h := r.Func
+		if r.HttpMethod != "PATCH" {
+			h = requireJson(h)
+		}
+		if !s.untimed[r] {
+			h = s.withTimeout(h)
+		}
+		routes = append(routes, &rest.Route{r.HttpMethod, r.PathExp, h})
+	}
+	return routes
+}
+
+// withTimeout limits the context of the requests handled by h to the timeout of the api. The context is done as well if the client disconnects.
+func (s *Api) withTimeout(h rest.HandlerFunc) rest.HandlerFunc {
+	if s.Timeout <= 0 {
+		return h
+	}
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+		defer cancel()
+		r.Request = r.Request.WithContext(ctx)
+		h(w, r)
+	}
+}
+
+// requireJson rejects requests to h with a body which is not JSON encoded in UTF-8 with status 415 Unsupported Media Type.
+func requireJson(h rest.HandlerFunc) rest.HandlerFunc {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		contentType := r.Header.Get("Content-Type")
+		media, params, _ := mime.ParseMediaType(contentType)
+		charset, ok := params["charset"]
+		if !ok {
+			charset = "utf-8"
+		}
+		if r.ContentLength != 0 && (media != "application/json" || !strings.EqualFold(charset, "utf-8")) {
+			handleError(types.NewHttpError(http.StatusUnsupportedMediaType, fmt.Errorf("Unsupported content type %q, expected application/json.", contentType)), w)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// AddResource adds another resource on the given endpoint using the given Controller. Resources of other endpoints can be included in its responses, if their links name the endpoint as collection.
+func (s *Api) AddResource(endpoint string, ctrl types.ContextResourceController) {
+	if s.controllers == nil {
+		s.controllers = make(map[string]types.ContextResourceController)
+	}
+	s.controllers[endpoint] = ctrl
+	s.AddUntimedRoute(&rest.Route{"GET", "/" + endpoint, query(ctrl, s.compound(endpoint))})
 	s.routes = append(
 		s.routes,
-		&rest.Route{"GET", "/" + endpoint, query(ctrl)},
-		&rest.Route{"GET", "/" + endpoint + "/:id", get(ctrl)},
+		&rest.Route{"GET", "/" + endpoint + "/:id", get(ctrl, s.compound(endpoint))},
 		&rest.Route{"POST", "/" + endpoint, post(ctrl)},
 		&rest.Route{"PUT", "/" + endpoint + "/:id", put(ctrl)},
+		&rest.Route{"PATCH", "/" + endpoint + "/:id", patch(ctrl)},
 		&rest.Route{"DELETE", "/" + endpoint + "/:id", delete(ctrl)},
 	)
+	if rel, ok := ctrl.(types.RelationController); ok {
+		s.routes = append(
+			s.routes,
+			&rest.Route{"POST", "/" + endpoint + "/:id/links/:link", relate(rel.AddRelated)},
+			&rest.Route{"DELETE", "/" + endpoint + "/:id/links/:link", relate(rel.RemoveRelated)},
+		)
+	}
 }
 
 func (a *Api) AddRoute(r *rest.Route) {
 	a.routes = append(a.routes, r)
 }
 
-func query(ctrl types.ResourceController) rest.HandlerFunc {
+// AddUntimedRoute adds a route whose requests may take longer than the timeout of the api, e.g. because they stream large responses. They are still cancelled if the client disconnects.
+func (a *Api) AddUntimedRoute(r *rest.Route) {
+	if a.untimed == nil {
+		a.untimed = make(map[*rest.Route]bool)
+	}
+	a.untimed[r] = true
+	a.AddRoute(r)
+}
+
+// query streams the resources satisfying the query as JSON array or as line delimited JSON if the client accepts it. The page requested by "limit" and "offset" is served with the total count of resources in the header X-Total-Count and links to the neighbouring pages. If linked resources are included, the page is served as a compound document instead.
+func query(ctrl types.ContextResourceController, include includer) rest.HandlerFunc {
 	return func(w rest.ResponseWriter, r *rest.Request) {
-		reader := ctrl.Query(r.URL.Query())
-		result := make([]types.Resource, 0)
-		var err error
-		var ok bool
-		for {
-			resource := ctrl.New()
-			ok, err = reader.Read(resource)
-			if !ok {
-				break
+		page, err := types.NewPage(r.URL.Query())
+		if occured := handleError(err, w); occured {
+			return
+		}
+		reader := ctrl.Query(r.Context(), r.URL.Query())
+		defer reader.Close()
+		if counter, ok := reader.(types.CountingReader); ok {
+			total, err := counter.Total()
+			if occured := handleError(err, w); occured {
+				return
+			}
+			w.Header().Set("X-Total-Count", strconv.Itoa(total))
+			if links := pageLinks(r.URL, page, total); links != "" {
+				w.Header().Set("Link", links)
 			}
-			result = append(result, resource)
 		}
-		if occured := handleError(err, w); occured {
+		langs := negotiateLanguages(w, r)
+		if len(decodeList(r.URL.Query(), "include")) != 0 {
+			res := make([]types.Resource, 0)
+			for {
+				next := ctrl.New()
+				var ok bool
+				ok, err = reader.Read(next)
+				if !ok {
+					break
+				}
+				localize(langs, next)
+				res = append(res, next)
+			}
+			if occured := handleError(err, w); occured {
+				return
+			}
+			doc, err := include(r, langs, res, res...)
+			if occured := handleError(err, w); occured {
+				return
+			}
+			w.WriteJson(doc)
 			return
 		}
-		w.WriteJson(result)
+		w.Header().Add("Vary", "Accept")
+		writeResources(w, acceptsNdjson(r.Header.Get("Accept")), func() (res types.Resource, ok bool, err error) {
+			res = ctrl.New()
+			if ok, err = reader.Read(res); ok {
+				localize(langs, res)
+			}
+			return
+		})
 	}
 }
 
-func get(ctrl types.ResourceController) rest.HandlerFunc {
+// get serves a resource with the entity tag of its revision. If the tag matches the header If-None-Match, the resource is not served again. A merged resource redirects to its target.
+func get(ctrl types.ContextResourceController, include includer) rest.HandlerFunc {
 	return func(w rest.ResponseWriter, r *rest.Request) {
 		id, err := decodeId(r)
 		if occured := handleError(err, w); occured {
 			return
 		}
-		res, err := ctrl.Read(id)
+		res, err := ctrl.Read(r.Context(), id)
+		if redirect, ok := err.(types.RedirectError); ok {
+			w.Header().Set("Location", path.Join(path.Dir(r.URL.Path), redirect.Target.AsString()))
+		}
 		if occured := handleError(err, w); occured {
 			return
 		}
-		w.WriteJson(res)
+		langs := negotiateLanguages(w, r)
+		if len(decodeList(r.URL.Query(), "include")) == 0 {
+			setETag(w, res)
+			if notModified(r, etag(res)) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		localize(langs, res)
+		doc, err := include(r, langs, res, res)
+		if occured := handleError(err, w); occured {
+			return
+		}
+		w.WriteJson(doc)
 	}
 }
 
-func post(ctrl types.ResourceController) rest.HandlerFunc {
+// post creates a resource. Resources failing validation are rejected listing all their invalid fields.
+func post(ctrl types.ContextResourceController) rest.HandlerFunc {
 	return func(w rest.ResponseWriter, r *rest.Request) {
 		res, err := decodeJson(r, ctrl)
 		if occured := handleError(err, w); occured {
 			return
 		}
 		res.SetId(types.Id(0))
-		err = ctrl.Create(res)
+		err = types.Validate(res)
 		if occured := handleError(err, w); occured {
 			return
 		}
+		err = ctrl.Create(r.Context(), res)
+		if occured := handleError(err, w); occured {
+			return
+		}
+		setETag(w, res)
+		localize(negotiateLanguages(w, r), res)
 		w.WriteJson(res)
 	}
 }
 
-func put(ctrl types.ResourceController) rest.HandlerFunc {
+// put replaces a resource after validating it like post. It is only replaced at the revision given by the header If-Match, if any.
+func put(ctrl types.ContextResourceController) rest.HandlerFunc {
 	return func(w rest.ResponseWriter, r *rest.Request) {
 		id, err := decodeId(r)
 		if occured := handleError(err, w); occured {
 			return
 		}
+		ctx, err := ifMatch(r)
+		if occured := handleError(err, w); occured {
+			return
+		}
 		res, err := decodeJson(r, ctrl)
 		if occured := handleError(err, w); occured {
 			return
 		}
 		res.SetId(id)
-		err = ctrl.Update(res)
+		err = types.Validate(res)
+		if occured := handleError(err, w); occured {
+			return
+		}
+		err = ctrl.Update(ctx, res)
 		if occured := handleError(err, w); occured {
 			return
 		}
+		setETag(w, res)
+		localize(negotiateLanguages(w, r), res)
 		w.WriteJson(res)
 	}
 }
 
-func delete(ctrl types.ResourceController) rest.HandlerFunc {
+// patch changes only the parts of a resource given by a JSON Merge Patch or a JSON Patch in the body. The patch is applied to the resource as served by get, the patched resource is updated like by put. The update fails if the resource has been changed since it has been read.
+func patch(ctrl types.ContextResourceController) rest.HandlerFunc {
 	return func(w rest.ResponseWriter, r *rest.Request) {
 		id, err := decodeId(r)
 		if occured := handleError(err, w); occured {
 			return
 		}
-		err = ctrl.Delete(id)
+		ctx, err := ifMatch(r)
+		if occured := handleError(err, w); occured {
+			return
+		}
+		res, err := ctrl.Read(ctx, id)
+		if occured := handleError(err, w); occured {
+			return
+		}
+		ctx, err = basedOn(ctx, res)
+		if occured := handleError(err, w); occured {
+			return
+		}
+		doc, err := json.Marshal(res)
+		if occured := handleError(err, w); occured {
+			return
+		}
+		patched, err := applyPatch(r.Header.Get("Content-Type"), doc, r.Body)
+		if occured := handleError(err, w); occured {
+			return
+		}
+		res = ctrl.New()
+		err = types.NewHttpError(http.StatusUnprocessableEntity, json.Unmarshal(patched, res))
+		if occured := handleError(err, w); occured {
+			return
+		}
+		res.SetId(id)
+		err = types.Validate(res)
+		if occured := handleError(err, w); occured {
+			return
+		}
+		err = ctrl.Update(ctx, res)
+		if occured := handleError(err, w); occured {
+			return
+		}
+		setETag(w, res)
+		localize(negotiateLanguages(w, r), res)
+		w.WriteJson(res)
+	}
+}
+
+// relate adds the ids in the body to a link of a resource or removes them from it using change and serves the changed resource. The resource is only changed at the revision given by the header If-Match, if any.
+func relate(change func(ctx context.Context, id types.Id, link string, related types.RelationToMany) (types.Resource, error)) rest.HandlerFunc {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		id, err := decodeId(r)
+		if occured := handleError(err, w); occured {
+			return
+		}
+		ctx, err := ifMatch(r)
+		if occured := handleError(err, w); occured {
+			return
+		}
+		related := make(types.RelationToMany, 0)
+		err = types.NewHttpError(http.StatusBadRequest, r.DecodeJsonPayload(&related))
+		if occured := handleError(err, w); occured {
+			return
+		}
+		res, err := change(ctx, id, r.PathParams["link"], related)
+		if occured := handleError(err, w); occured {
+			return
+		}
+		setETag(w, res)
+		localize(negotiateLanguages(w, r), res)
+		w.WriteJson(res)
+	}
+}
+
+// delete deletes a resource. With the parameter "dryRun" it serves the impact of the deletion instead. Rows of other resources are only deleted with the parameter "cascade". The resource is only deleted at the revision given by the header If-Match, if any.
+func delete(ctrl types.ContextResourceController) rest.HandlerFunc {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		id, err := decodeId(r)
+		if occured := handleError(err, w); occured {
+			return
+		}
+		q := r.URL.Query()
+		dryRun, err := decodeBool(q, "dryRun")
+		if occured := handleError(err, w); occured {
+			return
+		}
+		cascade, err := decodeBool(q, "cascade")
+		if occured := handleError(err, w); occured {
+			return
+		}
+		if dryRun {
+			impact, err := ctrl.Impact(r.Context(), id)
+			if occured := handleError(err, w); occured {
+				return
+			}
+			w.WriteJson(impact)
+			return
+		}
+		ctx, err := ifMatch(r)
+		if occured := handleError(err, w); occured {
+			return
+		}
+		err = ctrl.Delete(ctx, id, cascade)
 		if occured := handleError(err, w); occured {
 			return
 		}
@@ -121,26 +360,26 @@ func delete(ctrl types.ResourceController) rest.HandlerFunc {
 	}
 }
 
-func decodeJson(r *rest.Request, ctrl types.ResourceController) (res types.Resource, err error) {
+func decodeJson(r *rest.Request, ctrl types.ContextResourceController) (res types.Resource, err error) {
 	res = ctrl.New()
-	err = types.NewHttpError(http.StatusBadRequest, r.DecodeJsonPayload(res))
+	if e := r.DecodeJsonPayload(res); e != nil {
+		err = types.NewCodedError(http.StatusBadRequest, "invalid_body", fmt.Errorf("The body is no valid JSON representation of the resource: %s", e))
+	}
 	return
 }
 
 func decodeId(r *rest.Request) (id types.Id, err error) {
 	id, e := types.IdFromString(r.PathParams["id"])
-	err = types.NewHttpError(http.StatusInternalServerError, e)
+	if e != nil {
+		err = types.NewCodedError(http.StatusBadRequest, "invalid_id", fmt.Errorf("Invalid id %q, expected an unsigned integer.", r.PathParams["id"]))
+	}
 	return
 }
 
+// handleError writes err as problem details using Error if it is not nil.
 func handleError(err error, w rest.ResponseWriter) (occured bool) {
 	if err != nil {
-		switch err := err.(type) {
-		case types.HttpError:
-			rest.Error(w, err.Error(), err.Status())
-		default:
-			rest.Error(w, err.Error()+fmt.Sprintf("%#v", err), http.StatusInternalServerError)
-		}
+		Error(w, err)
 		occured = true
 	}
 	return
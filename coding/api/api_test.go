@@ -1 +1,304 @@
 package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/janvogt/gotambora/coding/types"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLanguages(t *testing.T) {
+	tests := []struct {
+		requested []string
+		accepted  string
+		langs     []string
+	}{
+		{nil, "", nil},
+		{nil, "de-CH, fr;q=0.8, en;q=0.9, *;q=0.5", []string{"de-ch", "en", "fr", "de"}},
+		{[]string{"la"}, "de;q=0.7,en", []string{"la", "en", "de"}},
+		{[]string{"en_GB"}, "en;q=0, de", []string{"en_gb", "de", "en"}},
+	}
+	for i, test := range tests {
+		langs := languages(test.requested, test.accepted)
+		if !reflect.DeepEqual(langs, test.langs) {
+			t.Errorf("Testcase %d: Unexpected result:\n%v\nexpected:\n%v\n", i, langs, test.langs)
+		}
+	}
+}
+
+func TestPageLinks(t *testing.T) {
+	tests := []struct {
+		url   string
+		p     types.Page
+		total int
+		links string
+	}{
+		{"/nodes", types.Page{-1, 0, nil}, 5, ""},
+		{"/nodes?limit=2", types.Page{2, 0, nil}, 5, `</nodes?limit=2&offset=0>; rel="first", </nodes?limit=2&offset=2>; rel="next", </nodes?limit=2&offset=4>; rel="last"`},
+		{"/nodes?parent=1&limit=2&offset=3", types.Page{2, 3, nil}, 5, `</nodes?limit=2&offset=0&parent=1>; rel="first", </nodes?limit=2&offset=1&parent=1>; rel="prev", </nodes?limit=2&offset=4&parent=1>; rel="last"`},
+		{"/scales?limit=10&offset=10", types.Page{10, 10, nil}, 0, `</scales?limit=10&offset=0>; rel="first", </scales?limit=10&offset=0>; rel="prev", </scales?limit=10&offset=0>; rel="last"`},
+	}
+	for i, test := range tests {
+		u, err := url.Parse(test.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if links := pageLinks(u, test.p, test.total); links != test.links {
+			t.Errorf("Testcase %d: Unexpected links:\n%s\nexpected:\n%s\n", i, links, test.links)
+		}
+	}
+}
+
+func TestAcceptsNdjson(t *testing.T) {
+	tests := []struct {
+		accept string
+		ndjson bool
+	}{
+		{"", false},
+		{"*/*", false},
+		{"application/x-ndjson", true},
+		{"application/json, application/x-ndjson", false},
+		{"application/json;q=0.5, application/x-ndjson", true},
+		{"application/x-ndjson;q=0", false},
+	}
+	for i, test := range tests {
+		if ndjson := acceptsNdjson(test.accept); ndjson != test.ndjson {
+			t.Errorf("Testcase %d: Expected %t for %q", i, test.ndjson, test.accept)
+		}
+	}
+}
+
+type testResponseWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w testResponseWriter) WriteJson(v interface{}) error {
+	b, err := w.EncodeJson(v)
+	if err == nil {
+		_, err = w.Write(b)
+	}
+	return err
+}
+
+func (w testResponseWriter) EncodeJson(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func TestWriteResources(t *testing.T) {
+	metrics := []types.Resource{&types.Metric{1, "a", types.RelationToMany{}, nil, 0, ""}, &types.Metric{2, "b", types.RelationToMany{3}, nil, 0, ""}}
+	tests := []struct {
+		n           int
+		ndjson      bool
+		contentType string
+		body        string
+	}{
+		{2, false, "application/json", `[{"id":1,"label":"a","links":{"scales":[]}},{"id":2,"label":"b","links":{"scales":[3]}}]`},
+		{0, false, "application/json", `[]`},
+		{2, true, mediaNdjson, "{\"id\":1,\"label\":\"a\",\"links\":{\"scales\":[]}}\n{\"id\":2,\"label\":\"b\",\"links\":{\"scales\":[3]}}\n"},
+		{0, true, mediaNdjson, ``},
+	}
+	for i, test := range tests {
+		w, read := testResponseWriter{httptest.NewRecorder()}, 0
+		writeResources(w, test.ndjson, func() (res types.Resource, ok bool, err error) {
+			if read == test.n {
+				return nil, false, nil
+			}
+			read++
+			return metrics[read-1], true, nil
+		})
+		if contentType := w.Header().Get("Content-Type"); contentType != test.contentType {
+			t.Errorf("Testcase %d: Unexpected content type %s, expected %s", i, contentType, test.contentType)
+		}
+		if body := w.Body.String(); body != test.body {
+			t.Errorf("Testcase %d: Unexpected body:\n%s\nexpected:\n%s\n", i, body, test.body)
+		}
+	}
+}
+
+// hijackableResponseWriter can be hijacked for a connection which records whether it has been closed.
+type hijackableResponseWriter struct {
+	testResponseWriter
+	conn *closeRecordingConn
+}
+
+func (w hijackableResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.conn, nil, nil
+}
+
+type closeRecordingConn struct {
+	net.Conn
+	closed bool
+}
+
+func (c *closeRecordingConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestWriteResourcesAbort(t *testing.T) {
+	metric := &types.Metric{Id: 1, Label: "a", Scales: types.RelationToMany{}}
+	failing := func() func() (types.Resource, bool, error) {
+		read := false
+		return func() (res types.Resource, ok bool, err error) {
+			if read {
+				return nil, false, errors.New("lost connection")
+			}
+			read = true
+			return metric, true, nil
+		}
+	}
+	w := hijackableResponseWriter{testResponseWriter{httptest.NewRecorder()}, new(closeRecordingConn)}
+	writeResources(w, false, failing())
+	if body := w.Body.String(); !w.conn.closed || body != `[{"id":1,"label":"a","links":{"scales":[]}}` {
+		t.Errorf("Expected the connection to be closed after a truncated body, but got closed = %t and body %s", w.conn.closed, body)
+	}
+	defer func() {
+		if r := recover(); r != http.ErrAbortHandler {
+			t.Errorf("Expected the handler to be aborted if the connection can't be hijacked, but got %v", r)
+		}
+	}()
+	writeResources(testResponseWriter{httptest.NewRecorder()}, true, failing())
+}
+
+func TestWithTimeout(t *testing.T) {
+	var deadline bool
+	h := func(w rest.ResponseWriter, r *rest.Request) {
+		_, deadline = r.Context().Deadline()
+	}
+	r := &rest.Request{Request: httptest.NewRequest("GET", "/nodes", nil)}
+	(&Api{}).withTimeout(h)(testResponseWriter{httptest.NewRecorder()}, r)
+	if deadline {
+		t.Errorf("Expected no deadline without timeout")
+	}
+	(&Api{Timeout: time.Second}).withTimeout(h)(testResponseWriter{httptest.NewRecorder()}, r)
+	if !deadline {
+		t.Errorf("Expected deadline with timeout")
+	}
+	a := &Api{Timeout: time.Second}
+	a.AddRoute(&rest.Route{"GET", "/timed", h})
+	a.AddUntimedRoute(&rest.Route{"GET", "/untimed", h})
+	for _, route := range a.servedRoutes() {
+		route.Func(testResponseWriter{httptest.NewRecorder()}, &rest.Request{Request: httptest.NewRequest("GET", route.PathExp, nil)})
+		if deadline != (route.PathExp == "/timed") {
+			t.Errorf("Unexpected deadline %t for route %s", deadline, route.PathExp)
+		}
+	}
+	a.AddResource(types.MetricCollection, &testController{})
+	for _, route := range a.routes {
+		if route.HttpMethod == "GET" && a.untimed[route] != (route.PathExp != "/timed" && route.PathExp != "/"+types.MetricCollection+"/:id") {
+			t.Errorf("Unexpected timeout for route %s", route.PathExp)
+		}
+	}
+}
+
+func TestRequireJson(t *testing.T) {
+	tests := []struct {
+		contentType string
+		body        string
+		status      int
+	}{
+		{"application/json", `{}`, 200},
+		{"application/json; charset=UTF-8", `{}`, 200},
+		{"", ``, 200},
+		{mediaMergePatch, `{}`, 415},
+		{"application/json; charset=latin1", `{}`, 415},
+		{"text/plain", `label=Gale`, 415},
+	}
+	h := requireJson(func(w rest.ResponseWriter, r *rest.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	for i, test := range tests {
+		r := &rest.Request{Request: httptest.NewRequest("PUT", "/scales/1", strings.NewReader(test.body))}
+		r.Header.Set("Content-Type", test.contentType)
+		w := testResponseWriter{httptest.NewRecorder()}
+		h(w, r)
+		if w.Code != test.status {
+			t.Errorf("Testcase %d: Expected status %d for %s, but got %d", i, test.status, test.contentType, w.Code)
+		}
+	}
+}
+
+func TestIfMatch(t *testing.T) {
+	tests := []struct {
+		match    string
+		revision int64
+		required bool
+		status   int
+	}{
+		{"", 0, false, 0},
+		{"*", 0, false, 0},
+		{`"3"`, 3, true, 0},
+		{` "12" `, 12, true, 0},
+		{`W/"3"`, 0, false, 412},
+		{`"abc"`, 0, false, 412},
+		{`"3", "4"`, 0, false, 400},
+	}
+	for i, test := range tests {
+		r := &rest.Request{Request: httptest.NewRequest("PUT", "/scales/1", nil)}
+		r.Header.Set("If-Match", test.match)
+		ctx, err := ifMatch(r)
+		if test.status != 0 {
+			if herr, ok := err.(types.HttpError); !ok || herr.Status() != test.status {
+				t.Errorf("Testcase %d: Expected status %d, but got %s", i, test.status, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Testcase %d: Unexpected Error: %s", i, err)
+		} else if revision, ok := types.RequiredRevision(ctx); ok != test.required || revision != test.revision {
+			t.Errorf("Testcase %d: Unexpected required revision %d (%t), expected %d (%t)", i, revision, ok, test.revision, test.required)
+		}
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	tests := []struct {
+		noneMatch string
+		tag       string
+		match     bool
+	}{
+		{"", `"3"`, false},
+		{`"3"`, `"3"`, true},
+		{`"2", W/"3"`, `"3"`, true},
+		{`"2"`, `"3"`, false},
+		{"*", `"3"`, true},
+		{"*", "", false},
+	}
+	for i, test := range tests {
+		r := &rest.Request{Request: httptest.NewRequest("GET", "/scales/1", nil)}
+		r.Header.Set("If-None-Match", test.noneMatch)
+		if match := notModified(r, test.tag); match != test.match {
+			t.Errorf("Testcase %d: Expected %s to match %s: %t, but got %t", i, test.noneMatch, test.tag, test.match, match)
+		}
+	}
+}
+
+func TestLocalizedRoundTrip(t *testing.T) {
+	metrics := &testController{resources: map[string]types.Resource{
+		"1": &types.Metric{Id: 1, Label: "one", Labels: types.Labels{"de": "eins"}, Scales: types.RelationToMany{}},
+	}}
+	a := &Api{controllers: map[string]types.ContextResourceController{types.MetricCollection: metrics}}
+	r := &rest.Request{Request: httptest.NewRequest("GET", "/metrics/1", nil), PathParams: map[string]string{"id": "1"}}
+	r.Header.Set("Accept-Language", "de")
+	w := testResponseWriter{httptest.NewRecorder()}
+	get(metrics, a.compound(types.MetricCollection))(w, r)
+	if body := w.Body.String(); body != `{"id":1,"label":"one","localizedLabel":"eins","labels":{"de":"eins"},"links":{"scales":[]}}` {
+		t.Fatalf("Unexpected localized metric %s", body)
+	}
+	r = &rest.Request{Request: httptest.NewRequest("PUT", "/metrics/1", w.Body), PathParams: map[string]string{"id": "1"}}
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Accept-Language", "de")
+	put(metrics)(testResponseWriter{httptest.NewRecorder()}, r)
+	if m := metrics.resources["1"].(*types.Metric); m.Label != "one" || !reflect.DeepEqual(m.Labels, types.Labels{"de": "eins"}) {
+		t.Errorf("Expected the labels to survive a round trip, but got %+v", m)
+	}
+}
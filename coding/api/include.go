@@ -0,0 +1,139 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/janvogt/gotambora/coding/types"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// includer turns the primary data of a response into the document served. res are the resources contained in primary.
+type includer func(r *rest.Request, langs []string, primary interface{}, res ...types.Resource) (doc interface{}, err error)
+
+// compound returns the includer for resources served at the given endpoint. Without the parameter "include" the primary data is served as is. Otherwise the document contains the primary data under the name of the endpoint and the resources reached by following the included links under "linked", grouped by their collection. Links are followed from the primary resources, paths like "metrics.scales" continue from the linked resources.
+func (s *Api) compound(endpoint string) includer {
+	return func(r *rest.Request, langs []string, primary interface{}, res ...types.Resource) (doc interface{}, err error) {
+		paths := decodeList(r.URL.Query(), "include")
+		if len(paths) == 0 {
+			return primary, nil
+		}
+		l := &linked{ctx: r.Context(), api: s, langs: langs, ids: make(map[string]map[types.Id]types.Resource), Resources: make(map[string][]types.Resource)}
+		err = l.follow(res, newIncludeTree(paths))
+		if err == nil {
+			doc = map[string]interface{}{endpoint: primary, "linked": l.Resources}
+		}
+		return
+	}
+}
+
+// includeTree arranges the dotted paths of links in a tree of links to follow one after another.
+type includeTree map[string]includeTree
+
+func newIncludeTree(paths []string) includeTree {
+	t := make(includeTree)
+	for _, p := range paths {
+		node := t
+		for _, link := range strings.Split(p, ".") {
+			if node[link] == nil {
+				node[link] = make(includeTree)
+			}
+			node = node[link]
+		}
+	}
+	return t
+}
+
+// linked collects the resources included in a compound document. Every resource is included only once.
+type linked struct {
+	ctx       context.Context
+	api       *Api
+	langs     []string
+	ids       map[string]map[types.Id]types.Resource
+	Resources map[string][]types.Resource
+}
+
+// follow includes the resources linked from res by the links in the tree and follows the subtrees from them.
+func (l *linked) follow(res []types.Resource, tree includeTree) error {
+	links := make([]string, 0, len(tree))
+	for link := range tree {
+		links = append(links, link)
+	}
+	sort.Strings(links)
+	for _, link := range links {
+		subtree := tree[link]
+		collection, ids := "", make([]types.Id, 0)
+		for _, r := range res {
+			linker, ok := r.(types.Linker)
+			var c string
+			var linkedIds []types.Id
+			if ok {
+				c, linkedIds, ok = linker.Linked(link)
+			}
+			if !ok {
+				return types.NewHttpError(http.StatusBadRequest, fmt.Errorf("Can't include %s, there is no such link.", link))
+			}
+			collection, ids = c, append(ids, linkedIds...)
+		}
+		next, err := l.load(collection, ids)
+		if err != nil {
+			return err
+		}
+		if err = l.follow(next, subtree); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// load reads the resources of the collection with the given ids which have not been included yet and returns all resources with these ids.
+func (l *linked) load(collection string, ids []types.Id) (res []types.Resource, err error) {
+	if len(ids) == 0 {
+		return
+	}
+	ctrl, ok := l.api.controllers[collection]
+	if !ok {
+		return nil, types.NewHttpError(http.StatusBadRequest, fmt.Errorf("Can't include %s, they are not served.", collection))
+	}
+	loaded := l.ids[collection]
+	if loaded == nil {
+		loaded = make(map[types.Id]types.Resource)
+		l.ids[collection] = loaded
+	}
+	missing := make([]string, 0)
+	for _, id := range ids {
+		if _, ok := loaded[id]; !ok {
+			missing = append(missing, id.AsString())
+			loaded[id] = nil
+		}
+	}
+	if len(missing) != 0 {
+		reader := ctrl.Query(l.ctx, map[string][]string{"id": missing})
+		defer reader.Close()
+		for {
+			r := ctrl.New()
+			ok, err = reader.Read(r)
+			if !ok {
+				break
+			}
+			linker, isLinker := r.(types.Linker)
+			if !isLinker {
+				return nil, types.NewHttpError(http.StatusBadRequest, fmt.Errorf("Can't include %s.", collection))
+			}
+			localize(l.langs, r)
+			loaded[linker.GetId()] = r
+			l.Resources[collection] = append(l.Resources[collection], r)
+		}
+		if err != nil {
+			return
+		}
+	}
+	for _, id := range ids {
+		if r := loaded[id]; r != nil {
+			res = append(res, r)
+		}
+	}
+	return
+}
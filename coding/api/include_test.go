@@ -0,0 +1,111 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/janvogt/gotambora/coding/types"
+	"net/http/httptest"
+	"testing"
+)
+
+// testController serves the metrics or scales of a map. Query only filters by id.
+type testController struct {
+	types.ContextResourceController
+	resources map[string]types.Resource
+	queries   int
+}
+
+func (c *testController) New() types.Resource {
+	for _, r := range c.resources {
+		if _, ok := r.(*types.Scale); ok {
+			return new(types.Scale)
+		}
+	}
+	return new(types.Metric)
+}
+
+func (c *testController) Query(ctx context.Context, q map[string][]string) types.ResourceReader {
+	c.queries++
+	res := make([]types.Resource, 0)
+	for _, id := range q["id"] {
+		if r, ok := c.resources[id]; ok {
+			res = append(res, r)
+		}
+	}
+	return &testReader{res}
+}
+
+func (c *testController) Read(ctx context.Context, id types.Id) (types.Resource, error) {
+	res := c.New()
+	_, err := (&testReader{[]types.Resource{c.resources[id.AsString()]}}).Read(res)
+	return res, err
+}
+
+func (c *testController) Update(ctx context.Context, r types.Resource) error {
+	id := r.(types.Linker).GetId()
+	c.resources[id.AsString()] = r
+	return nil
+}
+
+type testReader struct {
+	res []types.Resource
+}
+
+func (tr *testReader) Read(r types.Resource) (ok bool, err error) {
+	if len(tr.res) == 0 {
+		return
+	}
+	j, err := json.Marshal(tr.res[0])
+	if err == nil {
+		err = json.Unmarshal(j, r)
+	}
+	tr.res = tr.res[1:]
+	return err == nil, err
+}
+
+func (tr *testReader) Close() error {
+	return nil
+}
+
+func TestCompound(t *testing.T) {
+	metrics := &testController{resources: map[string]types.Resource{
+		"1": &types.Metric{Id: 1, Label: "one", Scales: types.RelationToMany{3, 4}},
+		"2": &types.Metric{Id: 2, Label: "two", Scales: types.RelationToMany{4}},
+	}}
+	scales := &testController{resources: map[string]types.Resource{
+		"3": &types.Scale{Id: 3, Label: "three", Type: types.ScaleNominal, Values: types.Values{}},
+		"4": &types.Scale{Id: 4, Label: "four", Type: types.ScaleNominal, Values: types.Values{}},
+	}}
+	a := &Api{controllers: map[string]types.ContextResourceController{types.MetricCollection: metrics, types.ScaleCollection: scales}}
+	node := &types.Node{Id: 5, Label: "node", Metrics: types.RelationToMany{1, 2}, Children: types.RelationToMany{}}
+	tests := []struct {
+		url     string
+		doc     string
+		status  int
+		queries int
+	}{
+		{"/nodes/5", `{"id":5,"label":"node","inheritMetrics":false,"links":{"children":[],"effectiveMetrics":null,"metrics":[1,2],"parent":null,"references":null}}`, 0, 0},
+		{"/nodes/5?include=metrics.scales,metrics", `{"linked":{"metrics":[{"id":1,"label":"one","links":{"scales":[3,4]}},{"id":2,"label":"two","links":{"scales":[4]}}],"scales":[{"id":3,"label":"three","type":"nominal","values":[]},{"id":4,"label":"four","type":"nominal","values":[]}]},"nodes":{"id":5,"label":"node","inheritMetrics":false,"links":{"children":[],"effectiveMetrics":null,"metrics":[1,2],"parent":null,"references":null}}}`, 0, 1},
+		{"/nodes/5?include=children", `{"linked":{},"nodes":{"id":5,"label":"node","inheritMetrics":false,"links":{"children":[],"effectiveMetrics":null,"metrics":[1,2],"parent":null,"references":null}}}`, 0, 0},
+		{"/nodes/5?include=metrics.nodes", ``, 400, 1},
+		{"/nodes/5?include=effectiveMetrics", ``, 0, 0},
+	}
+	for i, test := range tests {
+		metrics.queries = 0
+		r := &rest.Request{Request: httptest.NewRequest("GET", test.url, nil)}
+		doc, err := a.compound(types.NodeCollection)(r, nil, node, node)
+		if test.status != 0 {
+			if herr, ok := err.(types.HttpError); !ok || herr.Status() != test.status {
+				t.Errorf("Testcase %d: Expected status %d, but got %s", i, test.status, err)
+			}
+		} else if err != nil {
+			t.Errorf("Testcase %d: Unexpected Error: %s", i, err)
+		} else if j, _ := json.Marshal(doc); test.doc != "" && string(j) != test.doc {
+			t.Errorf("Testcase %d: Unexpected document:\n%s\nexpected:\n%s\n", i, j, test.doc)
+		}
+		if metrics.queries != test.queries {
+			t.Errorf("Testcase %d: Expected %d queries for metrics, but got %d", i, test.queries, metrics.queries)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/janvogt/gotambora/coding/types"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// negotiateLanguages returns the languages to localize the response in and marks the response to vary by the requested languages.
+func negotiateLanguages(w rest.ResponseWriter, r *rest.Request) []string {
+	w.Header().Add("Vary", "Accept-Language")
+	return languages(decodeList(r.URL.Query(), "lang"), r.Header.Get("Accept-Language"))
+}
+
+// languages returns the fallback chain of languages. The explicitly requested languages come first followed by the accepted languages ordered by their quality. Finally the base language of every language with a region like "de" for "de-CH" is added.
+func languages(requested []string, accepted string) (langs []string) {
+	weights := make(byQuality, 0)
+	for _, part := range strings.Split(accepted, ",") {
+		params := strings.Split(part, ";")
+		lang, quality := strings.TrimSpace(params[0]), 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		if lang != "" && lang != "*" && quality > 0 {
+			weights = append(weights, weightedLanguage{lang, quality})
+		}
+	}
+	sort.Stable(weights)
+	seen := make(map[string]bool)
+	add := func(lang string) {
+		if lang = strings.ToLower(lang); !seen[lang] {
+			langs = append(langs, lang)
+			seen[lang] = true
+		}
+	}
+	for _, lang := range requested {
+		add(lang)
+	}
+	for _, w := range weights {
+		add(w.lang)
+	}
+	for _, lang := range langs {
+		if i := strings.IndexAny(lang, "-_"); i > 0 {
+			add(lang[:i])
+		}
+	}
+	return
+}
+
+type weightedLanguage struct {
+	lang    string
+	quality float64
+}
+
+// byQuality sorts languages by descending quality.
+type byQuality []weightedLanguage
+
+func (b byQuality) Len() int           { return len(b) }
+func (b byQuality) Less(i, j int) bool { return b[i].quality > b[j].quality }
+func (b byQuality) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+// localize localizes the given resource if it supports labels in several languages.
+func localize(langs []string, res interface{}) {
+	if l, ok := res.(types.Localizer); ok {
+		l.Localize(langs)
+	}
+}
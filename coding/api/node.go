@@ -0,0 +1,197 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/janvogt/gotambora/coding/types"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// AddNodeResource adds the node resource on the given endpoint like AddResource and the endpoints to navigate the node hierarchy.
+func (s *Api) AddNodeResource(endpoint string, ctrl types.ContextNodeController) {
+	s.AddResource(endpoint, ctrl)
+	s.routes = append(
+		s.routes,
+		&rest.Route{"GET", "/" + endpoint + "/:id/tree", tree(ctrl)},
+		&rest.Route{"GET", "/" + endpoint + "/:id/ancestors", ancestors(ctrl)},
+		&rest.Route{"POST", "/" + endpoint + "/:id/move", move(ctrl)},
+		&rest.Route{"PUT", "/" + endpoint + "/:id/children", reorder(ctrl)},
+		&rest.Route{"POST", "/" + endpoint + "/:id/merge", merge(ctrl)},
+	)
+}
+
+// move moves a node to the parent and position given in the body and serves the moved node.
+func move(ctrl types.ContextNodeController) rest.HandlerFunc {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		id, err := decodeId(r)
+		if occured := handleError(err, w); occured {
+			return
+		}
+		m := new(types.NodeMove)
+		err = types.NewHttpError(http.StatusBadRequest, r.DecodeJsonPayload(m))
+		if occured := handleError(err, w); occured {
+			return
+		}
+		position := -1
+		if m.Position != nil {
+			position = *m.Position
+		}
+		n, err := ctrl.Move(r.Context(), id, m.Parent, position)
+		if occured := handleError(err, w); occured {
+			return
+		}
+		localize(negotiateLanguages(w, r), n)
+		w.WriteJson(n)
+	}
+}
+
+// merge merges a node into the target given in the body and serves the report of everything moved.
+func merge(ctrl types.ContextNodeController) rest.HandlerFunc {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		id, err := decodeId(r)
+		if occured := handleError(err, w); occured {
+			return
+		}
+		m := new(types.NodeMerge)
+		err = types.NewHttpError(http.StatusBadRequest, r.DecodeJsonPayload(m))
+		if occured := handleError(err, w); occured {
+			return
+		}
+		report, err := ctrl.Merge(r.Context(), id, m.Target)
+		if occured := handleError(err, w); occured {
+			return
+		}
+		w.WriteJson(report)
+	}
+}
+
+// reorder orders the children of a node like the ids given in the body and serves the node. The children are only reordered at the revision of the node given by the header If-Match, if any.
+func reorder(ctrl types.ContextNodeController) rest.HandlerFunc {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		id, err := decodeId(r)
+		if occured := handleError(err, w); occured {
+			return
+		}
+		ctx, err := ifMatch(r)
+		if occured := handleError(err, w); occured {
+			return
+		}
+		children := make(types.RelationToMany, 0)
+		err = types.NewHttpError(http.StatusBadRequest, r.DecodeJsonPayload(&children))
+		if occured := handleError(err, w); occured {
+			return
+		}
+		n, err := ctrl.Reorder(ctx, id, children)
+		if occured := handleError(err, w); occured {
+			return
+		}
+		setETag(w, n)
+		localize(negotiateLanguages(w, r), n)
+		w.WriteJson(n)
+	}
+}
+
+// ancestors serves the ancestors of a node starting at the root.
+func ancestors(ctrl types.ContextNodeController) rest.HandlerFunc {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		id, err := decodeId(r)
+		if occured := handleError(err, w); occured {
+			return
+		}
+		path, err := ctrl.Ancestors(r.Context(), id)
+		if occured := handleError(err, w); occured {
+			return
+		}
+		path.Localize(negotiateLanguages(w, r))
+		w.WriteJson(path)
+	}
+}
+
+// tree serves the subtree below a node. The depth of the subtree can be limited by the "depth" parameter, "include" adds the links to metrics and references. The tree is nested unless "format" is flat.
+func tree(ctrl types.ContextNodeController) rest.HandlerFunc {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		id, err := decodeId(r)
+		if occured := handleError(err, w); occured {
+			return
+		}
+		q := r.URL.Query()
+		depth, err := decodeInt(q, "depth", -1)
+		if occured := handleError(err, w); occured {
+			return
+		}
+		nested := true
+		switch q.Get("format") {
+		case "", "nested":
+		case "flat":
+			nested = false
+		default:
+			handleError(types.NewHttpError(http.StatusBadRequest, fmt.Errorf("Unknown tree format %s, expected nested or flat.", q.Get("format"))), w)
+			return
+		}
+		reader := ctrl.Tree(r.Context(), id, depth, decodeList(q, "include"))
+		defer reader.Close()
+		langs := negotiateLanguages(w, r)
+		nodes := make([]*types.TreeNode, 0)
+		for {
+			n := new(types.TreeNode)
+			var ok bool
+			ok, err = reader.Read(n)
+			if !ok {
+				break
+			}
+			n.Localize(langs)
+			nodes = append(nodes, n)
+		}
+		if occured := handleError(err, w); occured {
+			return
+		}
+		if len(nodes) == 0 {
+			handleError(types.NewHttpError(http.StatusNotFound, fmt.Errorf("No node with id %d", id)), w)
+			return
+		}
+		if nested {
+			w.WriteJson(types.NestTree(nodes))
+		} else {
+			w.WriteJson(nodes)
+		}
+	}
+}
+
+// decodeInt decodes the integer query parameter with the given name. If the parameter is not set def is returned.
+func decodeInt(q map[string][]string, name string, def int) (i int, err error) {
+	if len(q[name]) == 0 || q[name][0] == "" {
+		return def, nil
+	}
+	i, e := strconv.Atoi(q[name][0])
+	if e != nil {
+		err = types.NewHttpError(http.StatusBadRequest, errors.New("Parameter "+name+" has to be an integer."))
+	}
+	return
+}
+
+// decodeBool decodes the boolean query parameter with the given name. If the parameter is not set it is false.
+func decodeBool(q map[string][]string, name string) (b bool, err error) {
+	if len(q[name]) == 0 || q[name][0] == "" {
+		return
+	}
+	b, e := strconv.ParseBool(q[name][0])
+	if e != nil {
+		err = types.NewHttpError(http.StatusBadRequest, errors.New("Parameter "+name+" has to be a boolean."))
+	}
+	return
+}
+
+// decodeList decodes the comma separated lists of the query parameter with the given name.
+func decodeList(q map[string][]string, name string) (list []string) {
+	for _, v := range q[name] {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"github.com/janvogt/gotambora/coding/types"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// pageLinks returns the Link header pointing to the first, previous, next and last page of the same size as the requested page. It is empty if the page is not limited.
+func pageLinks(u *url.URL, p types.Page, total int) string {
+	if p.Limit <= 0 {
+		return ""
+	}
+	links := make([]string, 0, 4)
+	link := func(offset int, rel string) {
+		q := u.Query()
+		q.Set("limit", strconv.Itoa(p.Limit))
+		q.Set("offset", strconv.Itoa(offset))
+		links = append(links, "<"+u.Path+"?"+q.Encode()+`>; rel="`+rel+`"`)
+	}
+	last := 0
+	if total > 0 {
+		last = (total - 1) / p.Limit * p.Limit
+	}
+	link(0, "first")
+	if p.Offset > 0 {
+		prev := p.Offset - p.Limit
+		if prev < 0 {
+			prev = 0
+		}
+		link(prev, "prev")
+	}
+	if p.Offset+p.Limit < total {
+		link(p.Offset+p.Limit, "next")
+	}
+	link(last, "last")
+	return strings.Join(links, ", ")
+}
@@ -0,0 +1,347 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/janvogt/gotambora/coding/types"
+	"io"
+	"math/big"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	mediaMergePatch = "application/merge-patch+json"
+	mediaJsonPatch  = "application/json-patch+json"
+)
+
+// applyPatch applies the patch read from body to the JSON document doc. The patch is a JSON Merge Patch (RFC 7396) or, if contentType says so, a JSON Patch (RFC 6902). Plain JSON is taken as merge patch.
+func applyPatch(contentType string, doc []byte, body io.Reader) (patched []byte, err error) {
+	media, _, e := mime.ParseMediaType(contentType)
+	if e != nil {
+		return nil, types.NewHttpError(http.StatusUnsupportedMediaType, e)
+	}
+	var target interface{}
+	if err = decodeNumbers(bytes.NewReader(doc), &target); err != nil {
+		return
+	}
+	switch media {
+	case mediaMergePatch, "application/json":
+		var p interface{}
+		if e = decodeNumbers(body, &p); e != nil {
+			return nil, types.NewHttpError(http.StatusBadRequest, e)
+		}
+		target = mergePatch(target, p)
+	case mediaJsonPatch:
+		ops := make([]patchOperation, 0)
+		if e = decodeNumbers(body, &ops); e != nil {
+			return nil, types.NewHttpError(http.StatusBadRequest, e)
+		}
+		for _, op := range ops {
+			target, err = op.apply(target)
+			if err != nil {
+				return
+			}
+		}
+	default:
+		return nil, types.NewHttpError(http.StatusUnsupportedMediaType, fmt.Errorf("Unsupported patch format %s, expected %s or %s.", media, mediaMergePatch, mediaJsonPatch))
+	}
+	return json.Marshal(target)
+}
+
+// decodeNumbers decodes JSON keeping numbers as json.Number, so ids are not rounded.
+func decodeNumbers(r io.Reader, v interface{}) error {
+	d := json.NewDecoder(r)
+	d.UseNumber()
+	return d.Decode(v)
+}
+
+// mergePatch applies the merge patch p to target as specified by RFC 7396. Members of objects set to null are removed, all other values replace the target.
+func mergePatch(target, p interface{}) interface{} {
+	patch, ok := p.(map[string]interface{})
+	if !ok {
+		return p
+	}
+	doc, ok := target.(map[string]interface{})
+	if !ok {
+		doc = make(map[string]interface{})
+	}
+	for key, value := range patch {
+		if value == nil {
+			doc = without(doc, key)
+		} else {
+			doc[key] = mergePatch(doc[key], value)
+		}
+	}
+	return doc
+}
+
+// without returns a copy of the object doc without the member key. The builtin delete is shadowed by the handler in this package.
+func without(doc map[string]interface{}, key string) map[string]interface{} {
+	w := make(map[string]interface{}, len(doc))
+	for k, v := range doc {
+		if k != key {
+			w[k] = v
+		}
+	}
+	return w
+}
+
+// patchOperation is an operation of a JSON Patch (RFC 6902).
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	From  string      `json:"from"`
+	Value interface{} `json:"value"`
+}
+
+// apply applies the operation to doc and returns the changed document. Operations on missing locations are unprocessable, a failed test is a conflict.
+func (o patchOperation) apply(doc interface{}) (interface{}, error) {
+	path, err := parsePointer(o.Path)
+	if err != nil {
+		return nil, err
+	}
+	switch o.Op {
+	case "add":
+		return path.update(doc, o.Value, addMember)
+	case "remove":
+		if len(path) == 0 {
+			return nil, types.NewHttpError(http.StatusUnprocessableEntity, errors.New("Can't remove the whole resource."))
+		}
+		return path.update(doc, nil, removeMember)
+	case "replace":
+		return path.update(doc, o.Value, replaceMember)
+	case "move", "copy":
+		from, err := parsePointer(o.From)
+		if err != nil {
+			return nil, err
+		}
+		value, err := from.get(doc)
+		if err != nil {
+			return nil, err
+		}
+		if o.Op == "move" {
+			if strings.HasPrefix(o.Path+"/", o.From+"/") && o.Path != o.From {
+				return nil, types.NewHttpError(http.StatusUnprocessableEntity, fmt.Errorf("Can't move %s into itself.", o.From))
+			}
+			if doc, err = from.update(doc, nil, removeMember); err != nil {
+				return nil, err
+			}
+		} else if value, err = deepCopy(value); err != nil {
+			return nil, err
+		}
+		return path.update(doc, value, addMember)
+	case "test":
+		value, err := path.get(doc)
+		if err != nil {
+			return nil, err
+		}
+		if !equalValues(value, o.Value) {
+			return nil, types.NewHttpError(http.StatusConflict, fmt.Errorf("Test of %s failed.", o.Path))
+		}
+		return doc, nil
+	}
+	return nil, types.NewHttpError(http.StatusBadRequest, fmt.Errorf("Unknown patch operation %q.", o.Op))
+}
+
+// equalValues compares JSON values decoded by decodeNumbers. Numbers are equal if their values are, so 1 equals 1.0 and 1e0.
+func equalValues(a, b interface{}) bool {
+	switch a := a.(type) {
+	case json.Number:
+		b, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		ra, okA := new(big.Rat).SetString(string(a))
+		rb, okB := new(big.Rat).SetString(string(b))
+		return okA && okB && ra.Cmp(rb) == 0
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, v := range a {
+			if w, ok := b[k]; !ok || !equalValues(v, w) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !equalValues(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func deepCopy(v interface{}) (c interface{}, err error) {
+	j, err := json.Marshal(v)
+	if err == nil {
+		err = decodeNumbers(bytes.NewReader(j), &c)
+	}
+	return
+}
+
+// pointer is a JSON Pointer (RFC 6901) split into its reference tokens.
+type pointer []string
+
+var pointerUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+
+func parsePointer(s string) (p pointer, err error) {
+	if s == "" {
+		return pointer{}, nil
+	}
+	if !strings.HasPrefix(s, "/") {
+		return nil, types.NewHttpError(http.StatusBadRequest, fmt.Errorf("Invalid JSON pointer %q.", s))
+	}
+	for _, token := range strings.Split(s[1:], "/") {
+		p = append(p, pointerUnescaper.Replace(token))
+	}
+	return
+}
+
+// get returns the value at the pointer in doc.
+func (p pointer) get(doc interface{}) (interface{}, error) {
+	for i, token := range p {
+		var ok bool
+		doc, ok = member(doc, token)
+		if !ok {
+			return nil, missingError(p[:i+1])
+		}
+	}
+	return doc, nil
+}
+
+// update changes the member the pointer refers to in its parent using change and returns the changed document. The root is replaced by value.
+func (p pointer) update(doc, value interface{}, change func(parent interface{}, token string, value interface{}) (interface{}, bool)) (interface{}, error) {
+	if len(p) == 0 {
+		return value, nil
+	}
+	if len(p) == 1 {
+		changed, ok := change(doc, p[0], value)
+		if !ok {
+			return nil, missingError(p)
+		}
+		return changed, nil
+	}
+	child, ok := member(doc, p[0])
+	if !ok {
+		return nil, missingError(p[:1])
+	}
+	child, err := p[1:].update(child, value, change)
+	if err != nil {
+		if missing, ok := err.(missingPointerError); ok {
+			err = missingError(append(pointer{p[0]}, missing...))
+		}
+		return nil, err
+	}
+	doc, _ = replaceMember(doc, p[0], child)
+	return doc, nil
+}
+
+// member returns the member of an object or the element of an array with the given token.
+func member(doc interface{}, token string) (interface{}, bool) {
+	switch doc := doc.(type) {
+	case map[string]interface{}:
+		v, ok := doc[token]
+		return v, ok
+	case []interface{}:
+		if i, ok := index(token, len(doc)); ok && i < len(doc) {
+			return doc[i], true
+		}
+	}
+	return nil, false
+}
+
+// addMember sets the member of an object or inserts an element into an array. The token "-" appends to an array.
+func addMember(doc interface{}, token string, value interface{}) (interface{}, bool) {
+	switch doc := doc.(type) {
+	case map[string]interface{}:
+		doc[token] = value
+		return doc, true
+	case []interface{}:
+		i, ok := len(doc), token == "-"
+		if !ok {
+			i, ok = index(token, len(doc))
+		}
+		if ok {
+			return append(doc[:i], append([]interface{}{value}, doc[i:]...)...), true
+		}
+	}
+	return nil, false
+}
+
+// removeMember removes an existing member of an object or element of an array.
+func removeMember(doc interface{}, token string, value interface{}) (interface{}, bool) {
+	switch doc := doc.(type) {
+	case map[string]interface{}:
+		if _, ok := doc[token]; ok {
+			return without(doc, token), true
+		}
+	case []interface{}:
+		if i, ok := index(token, len(doc)); ok && i < len(doc) {
+			return append(doc[:i], doc[i+1:]...), true
+		}
+	}
+	return nil, false
+}
+
+// replaceMember replaces an existing member of an object or element of an array.
+func replaceMember(doc interface{}, token string, value interface{}) (interface{}, bool) {
+	if _, ok := member(doc, token); !ok {
+		return nil, false
+	}
+	switch doc := doc.(type) {
+	case map[string]interface{}:
+		doc[token] = value
+		return doc, true
+	case []interface{}:
+		i, _ := index(token, len(doc))
+		doc[i] = value
+		return doc, true
+	}
+	return nil, false
+}
+
+// index parses an array index of at most max. Leading zeros are not permitted.
+func index(token string, max int) (int, bool) {
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, false
+	}
+	i, err := strconv.Atoi(token)
+	return i, err == nil && i >= 0 && i <= max
+}
+
+// missingPointerError reports a pointer to a location which does not exist.
+type missingPointerError pointer
+
+func missingError(p pointer) missingPointerError {
+	return missingPointerError(append(pointer{}, p...))
+}
+
+// Error satisfies the HttpError interface
+func (m missingPointerError) Error() string {
+	escaper := strings.NewReplacer("~", "~0", "/", "~1")
+	path := ""
+	for _, token := range m {
+		path += "/" + escaper.Replace(token)
+	}
+	return fmt.Sprintf("There is no member at %s.", path)
+}
+
+// Status satisfies the HttpError interface
+func (m missingPointerError) Status() int {
+	return http.StatusUnprocessableEntity
+}
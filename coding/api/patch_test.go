@@ -0,0 +1,60 @@
+package api
+
+import (
+	"bytes"
+	"github.com/janvogt/gotambora/coding/types"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestApplyPatch(t *testing.T) {
+	node := `{"id":3,"label":"Storm","labels":{"de":"Sturm"},"inheritMetrics":true,"links":{"children":[4,5],"metrics":[1],"parent":1,"references":[7]}}`
+	tests := []struct {
+		contentType string
+		patch       string
+		patched     string
+		status      int
+	}{
+		{mediaMergePatch, `{"label":"Gale","labels":{"de":null,"fr":"Tempête"}}`, `{"id":3,"label":"Gale","labels":{"fr":"Tempête"},"inheritMetrics":true,"links":{"children":[4,5],"metrics":[1],"parent":1,"references":[7]}}`, 0},
+		{"application/json; charset=utf-8", `{"links":{"metrics":[]}}`, `{"id":3,"label":"Storm","labels":{"de":"Sturm"},"inheritMetrics":true,"links":{"children":[4,5],"metrics":[],"parent":1,"references":[7]}}`, 0},
+		{mediaJsonPatch, `[{"op":"add","path":"/links/references/-","value":9},{"op":"add","path":"/links/children/0","value":6},{"op":"remove","path":"/labels/de"}]`, `{"id":3,"label":"Storm","labels":{},"inheritMetrics":true,"links":{"children":[6,4,5],"metrics":[1],"parent":1,"references":[7,9]}}`, 0},
+		{mediaJsonPatch, `[{"op":"test","path":"/label","value":"Storm"},{"op":"replace","path":"/label","value":"Gale"},{"op":"copy","from":"/links/metrics","path":"/links/references"},{"op":"move","from":"/links/children/1","path":"/links/parent"}]`, `{"id":3,"label":"Gale","labels":{"de":"Sturm"},"inheritMetrics":true,"links":{"children":[4],"metrics":[1],"parent":5,"references":[1]}}`, 0},
+		{mediaJsonPatch, `[{"op":"test","path":"/label","value":"Gale"}]`, ``, 409},
+		{mediaJsonPatch, `[{"op":"test","path":"/links/parent","value":1.0},{"op":"test","path":"/links","value":{"children":[4,5e0],"metrics":[1],"parent":1,"references":[7]}}]`, node, 0},
+		{mediaJsonPatch, `[{"op":"test","path":"/links/parent","value":"1"}]`, ``, 409},
+		{mediaJsonPatch, `[{"op":"test","path":"/links/children","value":[4]}]`, ``, 409},
+		{mediaJsonPatch, `[{"op":"replace","path":"/links/children/2","value":6}]`, ``, 422},
+		{mediaJsonPatch, `[{"op":"remove","path":"/labels/fr"}]`, ``, 422},
+		{mediaJsonPatch, `[{"op":"add","path":"/links/children/01","value":6}]`, ``, 422},
+		{mediaJsonPatch, `[{"op":"move","from":"/links","path":"/links/metrics"}]`, ``, 422},
+		{mediaJsonPatch, `[{"op":"increment","path":"/label"}]`, ``, 400},
+		{mediaJsonPatch, `{"op":"add"}`, ``, 400},
+		{"text/plain", `label=Gale`, ``, 415},
+	}
+	for i, test := range tests {
+		patched, err := applyPatch(test.contentType, []byte(node), strings.NewReader(test.patch))
+		if test.status != 0 {
+			if e, ok := err.(types.HttpError); !ok || e.Status() != test.status {
+				t.Errorf("Testcase %d: Expected error with status %d, but got %v", i, test.status, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Testcase %d: Unexpected Error: %s", i, err)
+		} else if !equalJson(t, patched, []byte(test.patched)) {
+			t.Errorf("Testcase %d: Unexpected result:\n%s\nexpected:\n%s\n", i, patched, test.patched)
+		}
+	}
+}
+
+func equalJson(t *testing.T, a, b []byte) bool {
+	var va, vb interface{}
+	if err := decodeNumbers(bytes.NewReader(a), &va); err != nil {
+		t.Fatal(err)
+	}
+	if err := decodeNumbers(bytes.NewReader(b), &vb); err != nil {
+		t.Fatal(err)
+	}
+	return reflect.DeepEqual(va, vb)
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/janvogt/gotambora/coding/types"
+	"github.com/lib/pq"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Error writes err as problem details to w. Errors which are no HttpErrors are logged and reported as internal server error without disclosing them.
+func Error(w rest.ResponseWriter, err error) {
+	p := types.NewProblem(translateError(err))
+	if p.Status == http.StatusInternalServerError {
+		log.Printf("Internal server error: %s %#v", err, err)
+	}
+	w.Header().Set("Content-Type", types.MediaProblem)
+	w.WriteHeader(p.Status)
+	w.WriteJson(p)
+}
+
+// translateError translates errors of the context and of the database into HttpErrors. Other errors are returned unchanged.
+func translateError(err error) error {
+	if err == context.DeadlineExceeded || err == context.Canceled {
+		return types.NewCodedError(http.StatusServiceUnavailable, "timeout", errors.New("The request timed out or has been cancelled."))
+	}
+	if e, ok := err.(*pq.Error); ok {
+		if translated := translatePqError(e); translated != nil {
+			return translated
+		}
+	}
+	return err
+}
+
+// referenceFields maps the names of the foreign keys, without the table prefix, to the fields of the resources they check.
+var referenceFields = map[string]string{
+	"nodes_parent_fkey":        "/links/parent",
+	"links_to_fkey":            "/links/references",
+	"node_metric_metric_fkey":  "/links/metrics",
+	"metric_scale_scale_fkey":  "/links/scales",
+	"events_type_fkey":         "/links/type",
+	"event_ratings_value_fkey": "/links/ratings",
+	"event_values_scale_fkey":  "/values",
+}
+
+// columnFields maps the columns which must not be null, as table and column without the table prefix, to the fields of the resources they store.
+var columnFields = map[string]string{
+	"nodes.label":        "/label",
+	"nodes.inherit":      "/inheritMetrics",
+	"links.to":           "/links/references",
+	"links.type":         "/links/references",
+	"scales.label":       "/label",
+	"scales.type":        "/type",
+	"values.label":       "/values",
+	"metrics.label":      "/label",
+	"event_values.scale": "/values",
+	"event_values.value": "/values",
+}
+
+// translatePqError explains violated constraints and invalid input reported by Postgres. It returns nil for all other errors, they are internal. The details Postgres gives name tables and constraints, so they are never passed on.
+func translatePqError(e *pq.Error) error {
+	switch e.Code.Name() {
+	case "foreign_key_violation":
+		if strings.Contains(e.Detail, "is still referenced") {
+			return types.NewCodedError(http.StatusConflict, "still_referenced", errors.New("The resource is still referenced by other resources."))
+		}
+		return types.NewCodedError(http.StatusUnprocessableEntity, "unknown_reference", explain("The resource refers to a resource which does not exist", referenceFields, e.Constraint))
+	case "restrict_violation":
+		return types.NewCodedError(http.StatusConflict, "still_referenced", errors.New("The resource is still referenced by other resources."))
+	case "unique_violation":
+		return types.NewCodedError(http.StatusConflict, "duplicate", errors.New("A resource with the same values exists already."))
+	case "check_violation":
+		return types.NewCodedError(http.StatusUnprocessableEntity, "invalid_value", errors.New("The resource contains a value which is not permitted."))
+	case "not_null_violation":
+		return types.NewCodedError(http.StatusUnprocessableEntity, "missing_value", explain("The resource is missing a value", columnFields, e.Table+"."+e.Column))
+	case "invalid_text_representation", "numeric_value_out_of_range":
+		return types.NewCodedError(http.StatusBadRequest, "invalid_input", errors.New("The request contains a malformed value."))
+	case "query_canceled":
+		return types.NewCodedError(http.StatusServiceUnavailable, "timeout", errors.New("The request timed out or has been cancelled."))
+	}
+	return nil
+}
+
+// explain returns an error with the given explanation followed by the field fields maps name to, if it is known. The names in fields lack the table prefix.
+func explain(explanation string, fields map[string]string, name string) error {
+	for unprefixed, field := range fields {
+		if name == unprefixed || strings.HasSuffix(name, "_"+unprefixed) {
+			return fmt.Errorf("%s at %s.", explanation, field)
+		}
+	}
+	return errors.New(explanation + ".")
+}
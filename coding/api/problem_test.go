@@ -0,0 +1,43 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"github.com/lib/pq"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestError(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		body   string
+	}{
+		{context.DeadlineExceeded, http.StatusServiceUnavailable, `{"code":"timeout","detail":"The request timed out or has been cancelled.","status":503,"title":"Service Unavailable"}`},
+		{&pq.Error{Code: "23503", Detail: `Key (id)=(1) is still referenced from table "node_metric".`}, http.StatusConflict, `{"code":"still_referenced","detail":"The resource is still referenced by other resources.","status":409,"title":"Conflict"}`},
+		{&pq.Error{Code: "23503", Detail: `Key (metric)=(5) is not present in table "prefix_metrics".`, Constraint: "prefix_node_metric_metric_fkey"}, http.StatusUnprocessableEntity, `{"code":"unknown_reference","detail":"The resource refers to a resource which does not exist at /links/metrics.","status":422,"title":"Unprocessable Entity"}`},
+		{&pq.Error{Code: "23503", Detail: `Key (type)=(5) is not present in table "types".`, Constraint: "other_fkey"}, http.StatusUnprocessableEntity, `{"code":"unknown_reference","detail":"The resource refers to a resource which does not exist.","status":422,"title":"Unprocessable Entity"}`},
+		{&pq.Error{Code: "23001"}, http.StatusConflict, `{"code":"still_referenced","detail":"The resource is still referenced by other resources.","status":409,"title":"Conflict"}`},
+		{&pq.Error{Code: "23505", Detail: `Key (name)=(synonym) already exists.`}, http.StatusConflict, `{"code":"duplicate","detail":"A resource with the same values exists already.","status":409,"title":"Conflict"}`},
+		{&pq.Error{Code: "23502", Table: "prefix_scales", Column: "type"}, http.StatusUnprocessableEntity, `{"code":"missing_value","detail":"The resource is missing a value at /type.","status":422,"title":"Unprocessable Entity"}`},
+		{&pq.Error{Code: "23502", Table: "prefix_nodes", Column: "index"}, http.StatusUnprocessableEntity, `{"code":"missing_value","detail":"The resource is missing a value.","status":422,"title":"Unprocessable Entity"}`},
+		{&pq.Error{Code: "23514"}, http.StatusUnprocessableEntity, `{"code":"invalid_value","detail":"The resource contains a value which is not permitted.","status":422,"title":"Unprocessable Entity"}`},
+		{&pq.Error{Code: "42P01", Message: `relation "nodes" does not exist`}, http.StatusInternalServerError, `{"code":"internal_server_error","detail":"An unexpected error occurred.","status":500,"title":"Internal Server Error"}`},
+		{errors.New("lost connection"), http.StatusInternalServerError, `{"code":"internal_server_error","detail":"An unexpected error occurred.","status":500,"title":"Internal Server Error"}`},
+	}
+	for i, test := range tests {
+		w := testResponseWriter{httptest.NewRecorder()}
+		Error(w, test.err)
+		if w.Code != test.status {
+			t.Errorf("Testcase %d: Unexpected status %d, expected %d", i, w.Code, test.status)
+		}
+		if contentType := w.Header().Get("Content-Type"); contentType != "application/problem+json" {
+			t.Errorf("Testcase %d: Unexpected content type %s", i, contentType)
+		}
+		if body := w.Body.String(); body != test.body {
+			t.Errorf("Testcase %d: Unexpected body:\n%s\nexpected:\n%s\n", i, body, test.body)
+		}
+	}
+}
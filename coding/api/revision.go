@@ -0,0 +1,72 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/janvogt/gotambora/coding/types"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// etag returns the entity tag of the revision of res. It is empty if res has no revisions.
+func etag(res interface{}) string {
+	if r, ok := res.(types.Revisioned); ok {
+		return `"` + strconv.FormatInt(r.GetRevision(), 10) + `"`
+	}
+	return ""
+}
+
+// setETag sets the header ETag to the entity tag of res, if it has revisions.
+func setETag(w rest.ResponseWriter, res interface{}) {
+	if tag := etag(res); tag != "" {
+		w.Header().Set("ETag", tag)
+	}
+}
+
+// ifMatch returns the context of r requiring the revision given by the header If-Match. Without the header or with "*" any revision may be changed. A tag not denoting a revision can't match.
+func ifMatch(r *rest.Request) (ctx context.Context, err error) {
+	ctx = r.Context()
+	match := strings.TrimSpace(r.Header.Get("If-Match"))
+	if match == "" || match == "*" {
+		return
+	}
+	if strings.Contains(match, ",") {
+		return nil, types.NewHttpError(http.StatusBadRequest, errors.New("If-Match supports a single entity tag only."))
+	}
+	if len(match) < 2 || match[0] != '"' || match[len(match)-1] != '"' {
+		return nil, types.NewHttpError(http.StatusPreconditionFailed, fmt.Errorf("The entity tag %s doesn't match any revision.", match))
+	}
+	revision, e := strconv.ParseInt(match[1:len(match)-1], 10, 64)
+	if e != nil {
+		return nil, types.NewHttpError(http.StatusPreconditionFailed, fmt.Errorf("The entity tag %s doesn't match any revision.", match))
+	}
+	return types.RequireRevision(ctx, revision), nil
+}
+
+// basedOn returns ctx requiring the revision res has been read at, so the resource can't be changed meanwhile. It fails if ctx requires another revision already.
+func basedOn(ctx context.Context, res types.Resource) (context.Context, error) {
+	r, ok := res.(types.Revisioned)
+	if !ok {
+		return ctx, nil
+	}
+	if required, ok := types.RequiredRevision(ctx); ok && required != r.GetRevision() {
+		return nil, types.NewHttpError(http.StatusPreconditionFailed, fmt.Errorf("The resource has been changed meanwhile. It is at revision %d, not %d.", r.GetRevision(), required))
+	}
+	return types.RequireRevision(ctx, r.GetRevision()), nil
+}
+
+// notModified reports whether the header If-None-Match of r matches the entity tag. Weak tags match as well.
+func notModified(r *rest.Request, tag string) bool {
+	if tag == "" {
+		return false
+	}
+	for _, t := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		if t = strings.TrimSpace(t); t == "*" || strings.TrimPrefix(t, "W/") == tag {
+			return true
+		}
+	}
+	return false
+}
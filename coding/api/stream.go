@@ -0,0 +1,88 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/janvogt/gotambora/coding/types"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// mediaNdjson is the media type of line delimited JSON.
+const mediaNdjson = "application/x-ndjson"
+
+// acceptsNdjson returns whether the accept header prefers line delimited JSON to a JSON array.
+func acceptsNdjson(accept string) bool {
+	ndjson, array := 0.0, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		media, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, err := strconv.ParseFloat(params["q"], 64); err == nil {
+			quality = q
+		}
+		switch media {
+		case mediaNdjson:
+			ndjson = quality
+		case "application/json":
+			array = quality
+		}
+	}
+	return ndjson > array
+}
+
+// writeResources streams the resources returned by next to the response as JSON array or, if ndjson is set, as line delimited JSON. Nothing is written before the first resource has been read, so an error up to then is served as usual. A later error can't be reported anymore and aborts the response instead.
+func writeResources(w rest.ResponseWriter, ndjson bool, next func() (res types.Resource, ok bool, err error)) {
+	res, ok, err := next()
+	if occured := handleError(err, w); occured {
+		return
+	}
+	out := w.(http.ResponseWriter)
+	encode, begin, separator, terminator, end := w.EncodeJson, "[", ",", "", "]"
+	w.Header().Set("Content-Type", "application/json")
+	if ndjson {
+		encode, begin, separator, terminator, end = json.Marshal, "", "", "\n", ""
+		w.Header().Set("Content-Type", mediaNdjson)
+	}
+	w.WriteHeader(http.StatusOK)
+	if _, err = out.Write([]byte(begin)); err != nil {
+		return
+	}
+	for first := true; ok; first = false {
+		var b []byte
+		b, err = encode(res)
+		if err != nil {
+			abort(w, err)
+			return
+		}
+		if !first {
+			b = append([]byte(separator), b...)
+		}
+		if _, err = out.Write(append(b, terminator...)); err != nil {
+			return
+		}
+		res, ok, err = next()
+	}
+	if err != nil {
+		abort(w, err)
+		return
+	}
+	out.Write([]byte(end))
+}
+
+// abort ends a response which has been started already but can't be completed by closing the connection, so the client can tell the truncated body from a complete one. If the connection can't be hijacked, the handler is aborted by panicking with http.ErrAbortHandler.
+func abort(w rest.ResponseWriter, err error) {
+	log.Printf("Aborted response: %s", err)
+	if h, ok := w.(http.Hijacker); ok {
+		if conn, _, e := h.Hijack(); e == nil {
+			conn.Close()
+			return
+		}
+	}
+	panic(http.ErrAbortHandler)
+}
@@ -1,20 +1,25 @@
 package coding
 
 import (
+	"context"
+	"errors"
 	"github.com/ant0ine/go-json-rest/rest"
 	"github.com/janvogt/gotambora/coding/api"
 	"github.com/janvogt/gotambora/coding/database"
 	"github.com/janvogt/gotambora/coding/types"
 	"net/http"
+	"time"
 )
 
-// NewHandler creates a new ressource handler for the ressources of the coding subsystem.
-func NewHandler(ds types.DataSource) (handler http.Handler, e error) {
-	a := &api.Api{}
-	a.AddRoute(&rest.Route{"GET", "/import", makeHandler(ds, ImportNodesHandler)})
-	a.AddResource("nodes", ds.NodeController())
-	a.AddResource("scales", ds.ScaleController())
-	a.AddResource("metrics", ds.MetricController())
+// NewHandler creates a new ressource handler for the ressources of the coding subsystem. Requests taking longer than timeout are cancelled unless timeout is zero. Streamed queries and the import are not limited by timeout.
+func NewHandler(ds types.DataSource, timeout time.Duration) (handler http.Handler, e error) {
+	a := &api.Api{Timeout: timeout}
+	a.AddUntimedRoute(&rest.Route{"GET", "/import", makeHandler(ds, ImportNodesHandler)})
+	a.AddRoute(&rest.Route{"GET", "/linktypes", makeHandler(ds, LinkTypesHandler)})
+	a.AddNodeResource(types.NodeCollection, ds.NodeController())
+	a.AddResource(types.ScaleCollection, ds.ScaleController())
+	a.AddResource(types.MetricCollection, ds.MetricController())
+	a.AddResource(types.EventCollection, ds.EventController())
 	return a.Handler()
 }
 
@@ -25,17 +30,27 @@ func makeHandler(ds types.DataSource, h func(rest.ResponseWriter, *rest.Request,
 	}
 }
 
-// ImportNodes imports old pav values from the datasource.
+// ImportNodes imports old pav values from the datasource. The import is not transactional, so it is neither cancelled by a timeout nor if the client disconnects, which would leave it half done.
 func ImportNodesHandler(w rest.ResponseWriter, r *rest.Request, d types.DataSource) {
 	db, ok := d.(*database.DB)
 	if !ok {
-		rest.Error(w, "Need Database to import from.", http.StatusInternalServerError)
+		api.Error(w, types.NewCodedError(http.StatusNotImplemented, "import_unsupported", errors.New("Need Database to import from.")))
 		return
 	}
-	err := ImportNodes(db)
+	err := ImportNodes(context.Background(), db)
 	if err != nil {
-		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		api.Error(w, err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
 }
+
+// LinkTypesHandler serves the vocabulary of types for references between nodes.
+func LinkTypesHandler(w rest.ResponseWriter, r *rest.Request, d types.DataSource) {
+	linkTypes, err := d.NodeController().LinkTypes(r.Context())
+	if err != nil {
+		api.Error(w, err)
+		return
+	}
+	w.WriteJson(linkTypes)
+}
@@ -1,32 +1,45 @@
 package database
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"github.com/jmoiron/sqlx"
-	"reflect"
+	"github.com/lib/pq"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 )
 
-// A DB datasource.
+// A DB datasource. Its tables and other objects are either named with a common prefix like "coding_nodes" or placed in a dedicated Postgres schema like "coding"."nodes".
 type DB struct {
 	*sqlx.DB
-	prefix string
+	prefix string // prefix of all names if the objects are named with a prefix.
+	schema string // schema containing all objects if they are placed in a schema.
+	stmts  statements
 }
 
-// NewDB creates a new DB datasource using a given sql.DB. Creates the necessary schema if it does not exist.
+// NewDB creates a new DB datasource using a given sql.DB naming all objects with the given prefix. Creates the necessary schema if it does not exist. It fails if the schema has another version than SchemaVersion, older schemas need to be migrated using Migrate.
 func NewDB(db *sqlx.DB, prefix string) (ds *DB, err error) {
-	newDB := &DB{db, prefix}
-	v, err := newDB.Version()
+	newDB, err := Open(db, prefix)
 	if err != nil {
 		return
 	}
-	switch {
-	case v == 0:
-		err = newDB.createSchema()
-	case v > 1:
-		err = errors.New(fmt.Sprintf("Database Version for coding is %d. Can't downgrade to needed version 1. (db.Version() returned %[1]d)", v, newDB.prefix))
+	err = newDB.check()
+	if err != nil {
+		return
 	}
+	ds = newDB
+	return
+}
+
+// NewSchemaDB creates a new DB datasource using a given sql.DB placing all objects in the Postgres schema with the given name like NewDB. The Postgres schema is created if it does not exist.
+func NewSchemaDB(db *sqlx.DB, schema string) (ds *DB, err error) {
+	newDB, err := OpenSchema(db, schema)
+	if err != nil {
+		return
+	}
+	err = newDB.check()
 	if err != nil {
 		return
 	}
@@ -34,21 +47,65 @@ func NewDB(db *sqlx.DB, prefix string) (ds *DB, err error) {
 	return
 }
 
+// Open creates a new DB datasource using a given sql.DB naming all objects with the given prefix without checking or creating its schema. It is meant to maintain the schema, e.g. using Migrate or Clean. It fails if prefix is no valid identifier. The prefix is folded to lower case like Postgres does for the unquoted names it is used in.
+func Open(db *sqlx.DB, prefix string) (*DB, error) {
+	if !prefixPattern.MatchString(prefix) {
+		return nil, fmt.Errorf("Invalid prefix %q, expected at most %d letters, digits or underscores starting with a letter or underscore.", prefix, maxPrefixLength)
+	}
+	return &DB{DB: db, prefix: strings.ToLower(prefix)}, nil
+}
+
+// OpenSchema creates a new DB datasource using a given sql.DB placing all objects in the Postgres schema with the given name like Open. It fails if schema is no valid identifier. All objects are referred to by their schema qualified name, so the search_path of the connections needs not contain the schema, only the extensions pg_trgm and unaccent are looked up using it.
+func OpenSchema(db *sqlx.DB, schema string) (*DB, error) {
+	if !schemaPattern.MatchString(schema) {
+		return nil, fmt.Errorf("Invalid schema %q, expected at most %d letters, digits or underscores starting with a letter or underscore.", schema, maxIdentifierLength)
+	}
+	return &DB{DB: db, schema: schema}, nil
+}
+
+// maxIdentifierLength is the maximal length of identifiers in Postgres.
+const maxIdentifierLength = 63
+
+// maxPrefixLength leaves enough room in the identifiers for the longest name prefixed, like "node_redirects".
+const maxPrefixLength = 47
+
+var (
+	prefixPattern = regexp.MustCompile(fmt.Sprintf(`^[A-Za-z_][A-Za-z0-9_]{0,%d}$`, maxPrefixLength-1))
+	schemaPattern = regexp.MustCompile(fmt.Sprintf(`^[A-Za-z_][A-Za-z0-9_]{0,%d}$`, maxIdentifierLength-1))
+)
+
+// check creates the schema if there is none and fails if the schema has another version than SchemaVersion.
+func (db *DB) check() (err error) {
+	v, err := db.Version()
+	if err != nil {
+		return
+	}
+	switch {
+	case v == 0:
+		err = db.migrate(context.Background(), v, SchemaVersion)
+	case v < SchemaVersion:
+		err = fmt.Errorf("Database Version for %s is %d. It needs to be migrated to version %d first.", db.namespace(), v, SchemaVersion)
+	case v > SchemaVersion:
+		err = fmt.Errorf("Database Version for %s is %d. Can't downgrade to needed version %d.", db.namespace(), v, SchemaVersion)
+	}
+	return
+}
+
 // Version returns the verion of the current schema. 0 means there is no schema set.
 func (db *DB) Version() (v uint64, err error) {
-	res, err := db.Query(fmt.Sprintf("SELECT 1 FROM pg_proc WHERE proname = '%[1]s_version';", db.prefix))
-	if err != nil || !res.Next() {
+	var exists bool
+	err = db.QueryRow(`SELECT to_regproc($1) IS NOT NULL`, db.table("version")).Scan(&exists)
+	if err != nil || !exists {
 		return
 	}
-	row := db.QueryRow(fmt.Sprintf("SELECT %[1]s_version();", db.prefix))
-	err = row.Scan(&v)
+	err = db.QueryRow(`SELECT ` + db.table("version") + `()`).Scan(&v)
 	return
 }
 
 // Clean deletes all changes to the DB done by this package. It's a no-op if nothing was changed.
 func (db *DB) Clean() error {
-	return db.performWithTransaction(func(tx *sqlx.Tx) (err error) {
-		_, err = tx.Exec(fmt.Sprintf(dropSchemaSQLTemplate, db.prefix))
+	return db.performWithTransaction(context.Background(), func(tx *sqlx.Tx) (err error) {
+		_, err = tx.Exec(db.format(dropSchemaSQLTemplate))
 		return
 	})
 }
@@ -74,36 +131,54 @@ func exponentialRetry(abort <-chan chan<- error, f func() error) bool {
 	return true
 }
 
-// inParameter creates the sql string for a named IN clause. The paremeters are numbered sequencially and prefix with the given string. If values is not a slice it panics.
-func inParameter(prefix string, values interface{}, parameter map[string]interface{}) (sqlStr string) {
-	first := true
-	v := reflect.ValueOf(values)
-	if v.Len() == 0 {
-		return
-	}
-	sqlStr += "("
-	for i := 0; i < v.Len(); i++ {
-		parName := fmt.Sprintf("%s%d", prefix, i)
-		parameter[parName] = v.Index(i).Interface()
-		if !first {
-			sqlStr += ", "
-		} else {
-			first = false
-		}
-		sqlStr += ":" + parName
+// anyParameter creates the sql string for a named = ANY clause passing all values as one array parameter with the given name. The SQL is the same for any number of values, so its statement can be reused. If values is not a slice it panics.
+func anyParameter(name string, values interface{}, parameter map[string]interface{}) (sqlStr string) {
+	parameter[name] = pq.Array(values)
+	return "= ANY(:" + name + ") "
+}
+
+// flag reports whether the query parameter with the given name is set to a true value like "true" or "1".
+func flag(q map[string][]string, name string) bool {
+	if len(q[name]) == 0 {
+		return false
 	}
-	sqlStr += ") "
-	return
+	b, err := strconv.ParseBool(q[name][0])
+	return err == nil && b
 }
 
-// table returns the prefixed tablename based on the given subfix.
+// table returns the prefixed or schema qualified name of the table or other object with the given name.
 func (db *DB) table(name string) string {
-	return db.prefix + "_" + name
+	return db.qualifier() + name
+}
+
+// qualifier returns what precedes the names of all objects, the prefix followed by an underscore or the quoted schema followed by a dot.
+func (db *DB) qualifier() string {
+	if db.schema != "" {
+		return pq.QuoteIdentifier(db.schema) + "."
+	}
+	return db.prefix + "_"
 }
 
-// performWithTransaction performs the given function f embedded in a transaction performing a roll back on failure.
-func (db *DB) performWithTransaction(f func(tx *sqlx.Tx) error) (err error) {
-	txn, err := db.Beginx()
+// namespace returns the name of the schema or the prefix for messages.
+func (db *DB) namespace() string {
+	if db.schema != "" {
+		return db.schema
+	}
+	return db.prefix
+}
+
+// format formats a SQL template with the qualifier as first argument and, for names which can't be qualified like those of indexes, the prefix followed by an underscore or nothing as second argument.
+func (db *DB) format(template string) string {
+	unqualified := ""
+	if db.schema == "" {
+		unqualified = db.prefix + "_"
+	}
+	return fmt.Sprintf(template, db.qualifier(), unqualified)
+}
+
+// performWithTransaction performs the given function f embedded in a transaction performing a roll back on failure. The transaction is rolled back as well if ctx is done before it is committed.
+func (db *DB) performWithTransaction(ctx context.Context, f func(tx *sqlx.Tx) error) (err error) {
+	txn, err := db.BeginTxx(ctx, nil)
 	if err != nil {
 		return
 	}
@@ -116,128 +191,127 @@ func (db *DB) performWithTransaction(f func(tx *sqlx.Tx) error) (err error) {
 	return
 }
 
-// createSchema creates the necessary DB schema. It is an error if it exists already.
-func (db *DB) createSchema() error {
-	return db.performWithTransaction(func(tx *sqlx.Tx) (err error) {
-		_, err = tx.Exec(fmt.Sprintf(createSchemaSQLTemplate, db.prefix))
-		return
-	})
-}
-
 const labelFieldType = `text NOT NULL`
 
+// labelsFieldType stores the labels in several languages as a json object keyed by language.
+const labelsFieldType = `jsonb NOT NULL DEFAULT '{}'`
+
 const idFieldType = `bigint`
 
 const nodesTable = `
-CREATE SEQUENCE %[1]s_nodes_id_seq MINVALUE 1;
-CREATE TABLE %[1]s_nodes (
-	id     ` + idFieldType + ` PRIMARY KEY DEFAULT nextval('%[1]s_nodes_id_seq'),
+CREATE SEQUENCE %[1]snodes_id_seq MINVALUE 1;
+CREATE TABLE %[1]snodes (
+	id     ` + idFieldType + ` PRIMARY KEY DEFAULT nextval('%[1]snodes_id_seq'),
 	label  ` + labelFieldType + `,
-  parent ` + idFieldType + ` REFERENCES %[1]s_nodes(id) ON DELETE CASCADE
+  parent ` + idFieldType + ` REFERENCES %[1]snodes(id) ON DELETE CASCADE
 );
-ALTER SEQUENCE %[1]s_nodes_id_seq OWNED BY %[1]s_nodes.id;
+ALTER SEQUENCE %[1]snodes_id_seq OWNED BY %[1]snodes.id;
 `
 
 const linksTable = `
-CREATE TABLE %[1]s_links (
-  "from" ` + idFieldType + ` NOT NULL REFERENCES %[1]s_nodes(id) ON DELETE CASCADE,
-  "to"   ` + idFieldType + ` NOT NULL REFERENCES %[1]s_nodes(id) ON DELETE CASCADE,
+CREATE TABLE %[1]slinks (
+  "from" ` + idFieldType + ` NOT NULL REFERENCES %[1]snodes(id) ON DELETE CASCADE,
+  "to"   ` + idFieldType + ` NOT NULL REFERENCES %[1]snodes(id) ON DELETE CASCADE,
 	PRIMARY KEY ("from", "to") DEFERRABLE
 );
 `
 
+// defaultLinkType is the type of links between nodes if no other type is given.
+const defaultLinkType = "related"
+
 const scalesTables = `
-CREATE SEQUENCE %[1]s_scales_id_seq;
-CREATE TABLE %[1]s_scales (
-	id    ` + idFieldType + ` PRIMARY KEY DEFAULT nextval('%[1]s_scales_id_seq'),
+CREATE SEQUENCE %[1]sscales_id_seq;
+CREATE TABLE %[1]sscales (
+	id    ` + idFieldType + ` PRIMARY KEY DEFAULT nextval('%[1]sscales_id_seq'),
 	label ` + labelFieldType + `,
 	type text NOT NULL
 );
-ALTER SEQUENCE %[1]s_scales_id_seq OWNED BY %[1]s_scales.id;
+ALTER SEQUENCE %[1]sscales_id_seq OWNED BY %[1]sscales.id;
 
-CREATE SEQUENCE %[1]s_values_id_seq;
-CREATE TABLE %[1]s_values (
-  id      ` + idFieldType + ` PRIMARY KEY DEFAULT nextval('%[1]s_values_id_seq'),
-	scale   ` + idFieldType + ` NOT NULL REFERENCES %[1]s_scales(id) ON DELETE CASCADE,
+CREATE SEQUENCE %[1]svalues_id_seq;
+CREATE TABLE %[1]svalues (
+  id      ` + idFieldType + ` PRIMARY KEY DEFAULT nextval('%[1]svalues_id_seq'),
+	scale   ` + idFieldType + ` NOT NULL REFERENCES %[1]sscales(id) ON DELETE CASCADE,
 	"index" int NOT NULL,
 	label   ` + labelFieldType + `,
   UNIQUE (scale, "index") DEFERRABLE
 );
-ALTER SEQUENCE %[1]s_values_id_seq OWNED BY %[1]s_values.id;
+ALTER SEQUENCE %[1]svalues_id_seq OWNED BY %[1]svalues.id;
 
-CREATE TABLE %[1]s_units (
-	scale ` + idFieldType + ` PRIMARY KEY REFERENCES %[1]s_scales(id) ON DELETE CASCADE,
+CREATE TABLE %[1]sunits (
+	scale ` + idFieldType + ` PRIMARY KEY REFERENCES %[1]sscales(id) ON DELETE CASCADE,
 	unit ` + labelFieldType + `,
   "min" double precision,
   "max" double precision
 );
 
-CREATE TYPE %[1]s_scale_value AS (
+CREATE TYPE %[1]sscale_value AS (
   id bigint,
   label text
 );
 `
 
 const metricsTable = `
-CREATE SEQUENCE %[1]s_metrics_id_seq;
-CREATE TABLE %[1]s_metrics (
-  id    ` + idFieldType + ` PRIMARY KEY DEFAULT nextval('%[1]s_metrics_id_seq'),
+CREATE SEQUENCE %[1]smetrics_id_seq;
+CREATE TABLE %[1]smetrics (
+  id    ` + idFieldType + ` PRIMARY KEY DEFAULT nextval('%[1]smetrics_id_seq'),
   label ` + labelFieldType + `
 );
-ALTER SEQUENCE %[1]s_metrics_id_seq OWNED BY %[1]s_metrics.id;
+ALTER SEQUENCE %[1]smetrics_id_seq OWNED BY %[1]smetrics.id;
 
-CREATE TABLE %[1]s_metric_scale (
-  metric ` + idFieldType + ` NOT NULL REFERENCES %[1]s_metrics(id) ON DELETE CASCADE,
-  scale   ` + idFieldType + ` NOT NULL REFERENCES %[1]s_scales(id) ON DELETE CASCADE,
+CREATE TABLE %[1]smetric_scale (
+  metric ` + idFieldType + ` NOT NULL REFERENCES %[1]smetrics(id) ON DELETE CASCADE,
+  scale   ` + idFieldType + ` NOT NULL REFERENCES %[1]sscales(id) ON DELETE CASCADE,
   PRIMARY KEY (metric, scale) DEFERRABLE
 );
 
-CREATE TABLE %[1]s_node_metric (
-  node    ` + idFieldType + ` NOT NULL REFERENCES %[1]s_nodes(id) ON DELETE CASCADE,
-  metric ` + idFieldType + ` NOT NULL REFERENCES %[1]s_metrics(id) ON DELETE RESTRICT,
+CREATE TABLE %[1]snode_metric (
+  node    ` + idFieldType + ` NOT NULL REFERENCES %[1]snodes(id) ON DELETE CASCADE,
+  metric ` + idFieldType + ` NOT NULL REFERENCES %[1]smetrics(id) ON DELETE RESTRICT,
   PRIMARY KEY (node, metric) DEFERRABLE
 );
 `
 
 const eventsTable = `
-CREATE SEQUENCE %[1]s_events_id_seq;
-CREATE TABLE %[1]s_events (
-  id   ` + idFieldType + ` PRIMARY KEY DEFAULT nextval('%[1]s_events_id_seq'),
-  type ` + idFieldType + ` REFERENCES %[1]s_nodes(id) ON DELETE RESTRICT
+CREATE SEQUENCE %[1]sevents_id_seq;
+CREATE TABLE %[1]sevents (
+  id   ` + idFieldType + ` PRIMARY KEY DEFAULT nextval('%[1]sevents_id_seq'),
+  type ` + idFieldType + ` REFERENCES %[1]snodes(id) ON DELETE RESTRICT
 );
-ALTER SEQUENCE %[1]s_events_id_seq OWNED BY %[1]s_events.id;
+ALTER SEQUENCE %[1]sevents_id_seq OWNED BY %[1]sevents.id;
 
-CREATE TABLE %[1]s_event_ratings (
-  event   ` + idFieldType + ` NOT NULL REFERENCES %[1]s_events(id) ON DELETE CASCADE,
-  value   ` + idFieldType + ` REFERENCES %[1]s_values(id) ON UPDATE CASCADE ON DELETE RESTRICT
+CREATE TABLE %[1]sevent_ratings (
+  event   ` + idFieldType + ` NOT NULL REFERENCES %[1]sevents(id) ON DELETE CASCADE,
+  value   ` + idFieldType + ` REFERENCES %[1]svalues(id) ON UPDATE CASCADE ON DELETE RESTRICT
 );
 
-CREATE TABLE %[1]s_event_values (
-  event   ` + idFieldType + ` NOT NULL REFERENCES %[1]s_events(id) ON DELETE CASCADE,
-  scale   ` + idFieldType + ` NOT NULL REFERENCES %[1]s_units(scale) ON UPDATE CASCADE ON DELETE RESTRICT,
+CREATE TABLE %[1]sevent_values (
+  event   ` + idFieldType + ` NOT NULL REFERENCES %[1]sevents(id) ON DELETE CASCADE,
+  scale   ` + idFieldType + ` NOT NULL REFERENCES %[1]sunits(scale) ON UPDATE CASCADE ON DELETE RESTRICT,
   value   double precision NOT NULL
 );
 `
 
-const createSchemaSQLTemplate = nodesTable + linksTable + scalesTables + metricsTable + eventsTable + `
-CREATE FUNCTION %[1]s_version() RETURNS bigint
-  AS 'SELECT CAST(1 AS bigint);'
-  LANGUAGE SQL
-  IMMUTABLE;
-`
+// createSchemaSQLTemplate creates the baseline schema of version 1 as released before migrations were introduced. It must not be changed, later versions are reached by the migrations following it.
+const createSchemaSQLTemplate = nodesTable + linksTable + scalesTables + metricsTable + eventsTable
 
+// dropSchemaSQLTemplate drops the objects of all versions of the schema, so Clean works whatever the version is.
 const dropSchemaSQLTemplate = `
-DROP FUNCTION IF EXISTS %[1]s_version();
-DROP TABLE IF EXISTS %[1]s_event_values;
-DROP TABLE IF EXISTS %[1]s_event_ratings;
-DROP TABLE IF EXISTS %[1]s_events;
-DROP TABLE IF EXISTS %[1]s_node_metric;
-DROP TABLE IF EXISTS %[1]s_metric_scale;
-DROP TABLE IF EXISTS %[1]s_metrics;
-DROP TABLE IF EXISTS %[1]s_units;
-DROP TABLE IF EXISTS %[1]s_values;
-DROP TYPE IF EXISTS %[1]s_scale_value;
-DROP TABLE IF EXISTS %[1]s_scales;
-DROP TABLE IF EXISTS %[1]s_links;
-DROP TABLE IF EXISTS %[1]s_nodes;
+DROP FUNCTION IF EXISTS %[1]sversion();
+DROP TABLE IF EXISTS %[1]sevent_values;
+DROP TABLE IF EXISTS %[1]sevent_ratings;
+DROP TABLE IF EXISTS %[1]sevents;
+DROP TABLE IF EXISTS %[1]snode_metric;
+DROP TABLE IF EXISTS %[1]smetric_scale;
+DROP TABLE IF EXISTS %[1]smetrics;
+DROP TABLE IF EXISTS %[1]sunits;
+DROP TABLE IF EXISTS %[1]svalues;
+DROP TYPE IF EXISTS %[1]sscale_value;
+DROP TABLE IF EXISTS %[1]sscales;
+DROP TABLE IF EXISTS %[1]slinks;
+DROP TABLE IF EXISTS %[1]slink_types;
+DROP TABLE IF EXISTS %[1]snode_redirects;
+DROP TABLE IF EXISTS %[1]snodes;
+DROP FUNCTION IF EXISTS %[1]ssearch_text(text, jsonb);
+DROP FUNCTION IF EXISTS %[1]ssearch_term(text);
 `
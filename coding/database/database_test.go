@@ -1,10 +1,13 @@
 package database
 
 import (
+	"context"
+	"database/sql/driver"
 	"errors"
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/janvogt/gotambora/coding/types"
 	"github.com/jmoiron/sqlx"
+	"strings"
 	"testing"
 	"time"
 )
@@ -14,24 +17,24 @@ var ErrTest = errors.New("Test Error")
 func TestVersion(t *testing.T) {
 	db := newTestDB(t, "coding")
 	defer closeDb(t, db.DB)
-	sqlmock.ExpectQuery("SELECT 1 FROM pg_proc WHERE proname = 'coding_version';").WillReturnError(ErrTest)
+	sqlmock.ExpectQuery(`SELECT to_regproc\(\$1\) IS NOT NULL`).WithArgs("coding_version").WillReturnError(ErrTest)
 	v, err := db.Version()
 	if v != 0 || err != ErrTest {
 		t.Errorf("db.Version() should return 0 and error from DB, but got version %d and err %#v", v, err)
 	}
-	sqlmock.ExpectQuery("SELECT 1 FROM pg_proc WHERE proname = 'coding_version';").WillReturnRows(sqlmock.NewRows([]string{"row"}))
+	sqlmock.ExpectQuery(`SELECT to_regproc\(\$1\) IS NOT NULL`).WithArgs("coding_version").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
 	v, err = db.Version()
 	if v != 0 || err != nil {
 		t.Errorf("db.Version() should return 0 and no error if version routine does not exist, but got version %d and err %#v", v, err)
 	}
-	sqlmock.ExpectQuery("SELECT 1 FROM pg_proc WHERE proname = 'coding_version';").WillReturnRows(sqlmock.NewRows([]string{"row"}).AddRow(""))
-	sqlmock.ExpectQuery("SELECT coding_version\\(\\);").WillReturnError(ErrTest)
+	sqlmock.ExpectQuery(`SELECT to_regproc\(\$1\) IS NOT NULL`).WithArgs("coding_version").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	sqlmock.ExpectQuery("SELECT coding_version\\(\\)").WillReturnError(ErrTest)
 	v, err = db.Version()
 	if v != 0 || err != ErrTest {
 		t.Errorf("db.Version() should return 0 and error from db or scan if call to version routing fails, but got version %d and err %#v", v, err)
 	}
-	sqlmock.ExpectQuery("SELECT 1 FROM pg_proc WHERE proname = 'coding_version';").WillReturnRows(sqlmock.NewRows([]string{"row"}).AddRow(""))
-	sqlmock.ExpectQuery("SELECT coding_version\\(\\);").WillReturnRows(sqlmock.NewRows([]string{"row"}).AddRow(" "))
+	sqlmock.ExpectQuery(`SELECT to_regproc\(\$1\) IS NOT NULL`).WithArgs("coding_version").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	sqlmock.ExpectQuery("SELECT coding_version\\(\\)").WillReturnRows(sqlmock.NewRows([]string{"row"}).AddRow(" "))
 	v, err = db.Version()
 	if v != 0 || err == nil {
 		t.Errorf("db.Version() should return 0 and error if version routine returns unexpected result \" \", but got version %d and err %#v", v, err)
@@ -42,6 +45,13 @@ func TestVersion(t *testing.T) {
 	if v != someVersion || err != nil {
 		t.Errorf("db.Version() should return %d and no error if version routine returns that version, but got version %d and err %#v", someVersion, v, err)
 	}
+	schemaDB := &DB{DB: db.DB, schema: "Coding"}
+	sqlmock.ExpectQuery(`SELECT to_regproc\(\$1\) IS NOT NULL`).WithArgs(`"Coding".version`).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	sqlmock.ExpectQuery(`SELECT "Coding"\.version\(\)`).WillReturnRows(sqlmock.NewRows([]string{"row"}).AddRow(someVersion))
+	v, err = schemaDB.Version()
+	if v != someVersion || err != nil {
+		t.Errorf("db.Version() should call the version routine in the schema, but got version %d and err %#v", v, err)
+	}
 }
 
 func TestTable(t *testing.T) {
@@ -51,13 +61,63 @@ func TestTable(t *testing.T) {
 	if tab := db.table(someTable); tab != somePrefix+"_"+someTable {
 		t.Errorf("Expected table name for \"%s\" with prefix \"%s\" to be \"%s\", but got \"%s\"", someTable, somePrefix, somePrefix+"_"+someTable, tab)
 	}
+	db = DB{schema: "Schema"}
+	if tab := db.table(someTable); tab != `"Schema".table` {
+		t.Errorf("Expected table name for \"%s\" in schema \"Schema\" to be quoted and qualified, but got \"%s\"", someTable, tab)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	template := `CREATE INDEX %[2]snodes_search ON %[1]snodes USING gin ( %[1]ssearch_text(label, labels) gin_trgm_ops );`
+	if q := (&DB{prefix: "coding"}).format(template); q != `CREATE INDEX coding_nodes_search ON coding_nodes USING gin ( coding_search_text(label, labels) gin_trgm_ops );` {
+		t.Errorf("Unexpected SQL with prefix: %s", q)
+	}
+	if q := (&DB{schema: "coding"}).format(template); q != `CREATE INDEX nodes_search ON "coding".nodes USING gin ( "coding".search_text(label, labels) gin_trgm_ops );` {
+		t.Errorf("Unexpected SQL in schema: %s", q)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema bool
+		valid  bool
+	}{
+		{"coding", false, true},
+		{"_coding_2", false, true},
+		{"Coding", false, true},
+		{"coding; DROP TABLE users; --", false, false},
+		{"", false, false},
+		{strings.Repeat("p", maxPrefixLength+1), false, false},
+		{"Coding", true, true},
+		{`coding"`, true, false},
+		{"1coding", true, false},
+		{strings.Repeat("s", maxIdentifierLength), true, true},
+		{strings.Repeat("s", maxIdentifierLength+1), true, false},
+	}
+	for i, test := range tests {
+		var err error
+		if test.schema {
+			_, err = OpenSchema(nil, test.name)
+		} else {
+			_, err = Open(nil, test.name)
+		}
+		if test.valid && err != nil {
+			t.Errorf("Testcase %d: Unexpected Error for %q: %s", i, test.name, err)
+		} else if !test.valid && err == nil {
+			t.Errorf("Testcase %d: Expected error for invalid identifier %q", i, test.name)
+		}
+	}
+	if db, err := Open(nil, "Coding"); err != nil || db.table("nodes") != "coding_nodes" {
+		t.Errorf("Expected the prefix to be folded to lower case, but got %+v and err %v", db, err)
+	}
 }
 
 func TestPerformWithTransaction(t *testing.T) {
 	db := newTestDB(t, "coding")
 	defer closeDb(t, db.DB)
 	sqlmock.ExpectBegin()
-	err := db.performWithTransaction(func(tx *sqlx.Tx) (err error) {
+	err := db.performWithTransaction(context.Background(), func(tx *sqlx.Tx) (err error) {
 		if tx == nil {
 			t.Errorf("Argument of performWithTransaction() should never be called without valid transaction.")
 		}
@@ -70,7 +130,7 @@ func TestPerformWithTransaction(t *testing.T) {
 		t.Errorf("Successful call to argument of performWithTransaction() should not return error, but got %s", err)
 	}
 	sqlmock.ExpectBegin().WillReturnError(ErrTest)
-	err = db.performWithTransaction(func(tx *sqlx.Tx) (err error) {
+	err = db.performWithTransaction(context.Background(), func(tx *sqlx.Tx) (err error) {
 		t.Errorf("Argument of performWithTransaction() should never be called if Begin fails.")
 		return
 	})
@@ -78,7 +138,7 @@ func TestPerformWithTransaction(t *testing.T) {
 		t.Errorf("performWithTransaction() should return error of failed db.Begin(), but got %s", err)
 	}
 	sqlmock.ExpectBegin()
-	err = db.performWithTransaction(func(tx *sqlx.Tx) (err error) {
+	err = db.performWithTransaction(context.Background(), func(tx *sqlx.Tx) (err error) {
 		sqlmock.ExpectRollback()
 		return ErrTest
 	})
@@ -86,7 +146,7 @@ func TestPerformWithTransaction(t *testing.T) {
 		t.Errorf("performWithTransaction() should return error returned by its argument, but got %s", err)
 	}
 	sqlmock.ExpectBegin()
-	err = db.performWithTransaction(func(tx *sqlx.Tx) (err error) {
+	err = db.performWithTransaction(context.Background(), func(tx *sqlx.Tx) (err error) {
 		sqlmock.ExpectCommit().WillReturnError(ErrTest)
 		return
 	})
@@ -95,23 +155,6 @@ func TestPerformWithTransaction(t *testing.T) {
 	}
 }
 
-func TestCreateSchema(t *testing.T) {
-	db := newTestDB(t, "coding")
-	defer closeDb(t, db.DB)
-	sqlmockExpectCreateSchema("coding")
-	err := db.createSchema()
-	if err != nil {
-		t.Errorf("createSchema() should suceed if schema creation is sucessful, but got %s", err)
-	}
-	sqlmock.ExpectBegin()
-	sqlmock.ExpectExec(".*? CREATE FUNCTION coding_version\\(\\) RETURNS bigint AS 'SELECT CAST\\(1 AS bigint\\);' LANGUAGE SQL IMMUTABLE;").WillReturnError(ErrTest)
-	sqlmock.ExpectRollback()
-	err = db.createSchema()
-	if err != ErrTest {
-		t.Errorf("createSchema() should return error if schema creation fails, but got err = %s", err)
-	}
-}
-
 func TestClean(t *testing.T) {
 	db := newTestDB(t, "coding")
 	defer closeDb(t, db.DB)
@@ -135,24 +178,29 @@ func TestNewDb(t *testing.T) {
 	dbx := setUpTestDB(t)
 	defer closeDb(t, dbx)
 	somePrefix := "prefix"
-	sqlmockExpectVersion(somePrefix, 2)
+	sqlmockExpectVersion(somePrefix, SchemaVersion+1)
 	db, err := NewDB(dbx, somePrefix)
 	if db != nil || err == nil {
-		t.Errorf("NewDB() should fail and not create a db schema if version > 1, but got db = %#v", db)
+		t.Errorf("NewDB() should fail and not create a db schema if version > %d, but got db = %#v", SchemaVersion, db)
+	}
+	sqlmockExpectVersion(somePrefix, 1)
+	db, err = NewDB(dbx, somePrefix)
+	if db != nil || err == nil {
+		t.Errorf("NewDB() should fail and not migrate the schema if version < %d, but got db = %#v", SchemaVersion, db)
 	}
 	sqlmockExpectVersion(somePrefix, 0)
 	sqlmockExpectCreateSchema(somePrefix)
 	db, err = NewDB(dbx, somePrefix)
 	if db == nil || err != nil {
-		t.Errorf("NewDB() should sucessfully create schema if version < 1, but got %s", err)
+		t.Errorf("NewDB() should sucessfully create schema if version = 0, but got %s", err)
 	}
 	if db.DB == nil || db.prefix != somePrefix {
 		t.Errorf("Expected NewDB() to have valid non-nil DB field and correct prefix %s, but got %#v", somePrefix, db)
 	}
-	sqlmockExpectVersion(somePrefix, 1)
+	sqlmockExpectVersion(somePrefix, SchemaVersion)
 	db, err = NewDB(dbx, somePrefix)
 	if err != nil {
-		t.Errorf("NewDB() should succeed and leave DB untouched if version = 1, but got err = %#v", db)
+		t.Errorf("NewDB() should succeed and leave DB untouched if version = %d, but got err = %#v", SchemaVersion, db)
 	}
 	if db.DB == nil || db.prefix != somePrefix {
 		t.Errorf("Expected NewDB() to have valid non-nil DB field and correct prefix %s, but got %#v", somePrefix, db)
@@ -201,26 +249,31 @@ func TestExponentialRetry(t *testing.T) {
 	}
 }
 
-func TestInParameter(t *testing.T) {
+func TestAnyParameter(t *testing.T) {
 	tests := []struct {
-		prefix         string
-		values         interface{}
-		parameter      map[string]interface{}
-		parameterAfter map[string]interface{}
-		result         string
+		name   string
+		values interface{}
+		array  string
+		result string
 	}{
-		{"prefix", []int{1, 2, 3}, make(map[string]interface{}), map[string]interface{}{"prefix0": 1, "prefix1": 2, "prefix2": 3}, "(:prefix0, :prefix1, :prefix2) "},
-		{"prefix", []int{}, make(map[string]interface{}), map[string]interface{}{}, ""},
-		{"prefix2", []string{"H", "B"}, make(map[string]interface{}), map[string]interface{}{"prefix20": "H", "prefix21": "B"}, "(:prefix20, :prefix21) "},
-		{"prefix", []string{"H"}, make(map[string]interface{}), map[string]interface{}{"prefix0": "H"}, "(:prefix0) "},
+		{"name", []int{1, 2, 3}, "{1,2,3}", "= ANY(:name) "},
+		{"name", []int{}, "{}", "= ANY(:name) "},
+		{"name2", []string{"H", "B"}, `{"H","B"}`, "= ANY(:name2) "},
+		{"name", []types.Id{7}, "{7}", "= ANY(:name) "},
 	}
 	for testId, test := range tests {
-		res := inParameter(test.prefix, test.values, test.parameter)
+		parameter := make(map[string]interface{})
+		res := anyParameter(test.name, test.values, parameter)
 		if res != test.result {
-			t.Errorf("Test Case %d: Expected %s to be %s, when testing inParameter().", testId, res, test.result)
+			t.Errorf("Test Case %d: Expected %s to be %s, when testing anyParameter().", testId, res, test.result)
+		}
+		if len(parameter) != 1 {
+			t.Errorf("Test Case %d: Expected one parameter, got %#v, when testing anyParameter().", testId, parameter)
+			continue
 		}
-		if !compareStringMap(test.parameter, test.parameterAfter) {
-			t.Errorf("Test Case %d: Expected %#v to be %#v, when testing inParameter().", testId, test.parameter, test.parameterAfter)
+		v, err := parameter[test.name].(driver.Valuer).Value()
+		if err != nil || v != test.array {
+			t.Errorf("Test Case %d: Expected parameter %s to be %s, got %#v (%v), when testing anyParameter().", testId, test.name, test.array, v, err)
 		}
 	}
 }
@@ -245,34 +298,33 @@ func compareIdSlice(a []types.Id, b []types.Id) (equal bool) {
 	return
 }
 
-func compareStringMap(a map[string]interface{}, b map[string]interface{}) (equal bool) {
-	equal = len(a) == len(b)
-	if !equal || a == nil || b == nil {
-		return
-	}
-	for k, va := range a {
-		vb, ok := b[k]
-		if !ok {
-			return false
-		}
-		if va != vb {
-			return false
-		}
-	}
-	return
-}
-
 func sqlmockExpectVersion(prefix string, version uint64) {
-	sqlmock.ExpectQuery("SELECT 1 FROM pg_proc WHERE proname = '" + prefix + "_version';").WillReturnRows(sqlmock.NewRows([]string{"row"}).AddRow(""))
-	sqlmock.ExpectQuery("SELECT " + prefix + "_version\\(\\);").WillReturnRows(sqlmock.NewRows([]string{"row"}).AddRow(version))
+	sqlmock.ExpectQuery(`SELECT to_regproc\(\$1\) IS NOT NULL`).WithArgs(prefix + "_version").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(version != 0))
+	if version != 0 {
+		sqlmock.ExpectQuery("SELECT " + prefix + "_version\\(\\)").WillReturnRows(sqlmock.NewRows([]string{"row"}).AddRow(version))
+	}
 }
 
 func sqlmockExpectCreateSchema(prefix string) {
 	sqlmock.ExpectBegin()
-	sqlmock.ExpectExec(".*? CREATE FUNCTION " + prefix + "_version\\(\\) RETURNS bigint AS 'SELECT CAST\\(1 AS bigint\\);' LANGUAGE SQL IMMUTABLE;").WillReturnResult(sqlmock.NewResult(1, 1))
+	for _, m := range migrations {
+		sqlmock.ExpectExec(migrationRegex(prefix, m.version)).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
 	sqlmock.ExpectCommit()
 }
 
+func sqlmockExpectRevise(table string, id types.Id, revision int64) {
+	sqlmock.ExpectQuery(`UPDATE ` + table + ` SET revision = revision \+ 1 WHERE id = \$1 RETURNING revision - 1`).WithArgs(id).WillReturnRows(sqlmock.NewRows([]string{"revision"}).AddRow(revision))
+}
+
+func sqlmockExpectTouchParent(id types.Id) {
+	sqlmock.ExpectExec(`UPDATE prefix_nodes SET revision = revision \+ 1 WHERE id = \( SELECT parent FROM prefix_nodes WHERE id = \$1 \)`).WithArgs(id).WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func sqlmockExpectTouchHeirs(ids string) {
+	sqlmock.ExpectExec(`WITH RECURSIVE heirs \( id, path \) AS \( SELECT id, ARRAY\[id\] FROM prefix_nodes WHERE parent = ANY\(\$1\) AND inherit UNION ALL .* WHERE c.inherit .* UPDATE prefix_nodes SET revision = revision \+ 1 WHERE id IN \( SELECT id FROM heirs \)`).WithArgs(ids).WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
 func newTestDB(t *testing.T, prefix string) (db *DB) {
 	dbx := setUpTestDB(t)
 	db = &DB{DB: dbx, prefix: prefix}
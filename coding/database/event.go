@@ -0,0 +1,280 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/janvogt/gotambora/coding/types"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"net/http"
+	"strings"
+)
+
+func (db *DB) EventController() types.ContextResourceController {
+	return &EventController{db}
+}
+
+type EventController struct {
+	db *DB
+}
+
+// New implements the ResourceController interface
+func (ec *EventController) New() (r types.Resource) {
+	return new(types.Event)
+}
+
+// Query implements the ResourceController interface
+func (ec *EventController) Query(ctx context.Context, q map[string][]string) types.ResourceReader {
+	args := make(map[string]interface{})
+	where := ""
+	if len(q["type"]) != 0 {
+		where += "AND e.type " + anyParameter("type", q["type"], args)
+	}
+	if len(q["rating"]) != 0 {
+		where += "AND e.id IN ( SELECT event FROM " + ec.db.table("event_ratings") + " WHERE value " + anyParameter("rating", q["rating"], args) + ") "
+	}
+	if where != "" {
+		where = "WHERE " + where[4:]
+	}
+	res := &EventReader{count: count(ctx, ec.db, ec.db.table("events")+" e", where, args)}
+	page, err := paginate(q, map[string]string{"id": "id", "type": "type"}, "", args)
+	if err != nil {
+		res.err = err
+		return res
+	}
+	var stmt *sqlx.NamedStmt
+	stmt, res.err = ec.db.prepareNamed(selectEvents(ec.db.table("events"), ec.db.table("event_ratings"), ec.db.table("event_values"), where) + page)
+	if res.err != nil {
+		return res
+	}
+	res.rows, res.err = stmt.QueryxContext(ctx, args)
+	return res
+}
+
+// Create implements the ResourceController interface
+func (ec *EventController) Create(ctx context.Context, r types.Resource) (err error) {
+	e, err := assertEvent(r)
+	if err != nil {
+		return
+	}
+	args := make(map[string]interface{})
+	q := "WITH" + ec.newEvent(e, args) + "," + ec.newRatings(e, args) + "," + ec.newValues(e, args) + " " + selectEvents("new_event", "new_ratings", "new_values", "")
+	err = ec.db.performWithTransaction(ctx, func(tx *sqlx.Tx) (err error) {
+		err = ec.validate(ctx, tx, e)
+		if err != nil {
+			return
+		}
+		stmt, err := tx.PrepareNamedContext(ctx, q)
+		if err != nil {
+			return
+		}
+		return stmt.GetContext(ctx, e, args)
+	})
+	return
+}
+
+func (ec *EventController) newEvent(e *types.Event, args map[string]interface{}) string {
+	args["newEventType"] = e.Type
+	return ` new_event AS ( INSERT INTO ` + ec.db.table("events") + ` ( type ) VALUES ( :newEventType ) RETURNING * )`
+}
+
+func (ec *EventController) newRatings(e *types.Event, args map[string]interface{}) string {
+	if e.Ratings == nil || len(e.Ratings) == 0 {
+		return ` new_ratings AS ( SELECT * FROM ` + ec.db.table("event_ratings") + ` WHERE FALSE )`
+	}
+	args["newRatings"] = idArray(e.Ratings)
+	return ` new_ratings AS ( INSERT INTO ` + ec.db.table("event_ratings") + ` (event, value) SELECT e.id, r.id FROM new_event e, unnest(CAST(:newRatings AS bigint[])) AS r ( id ) RETURNING * )`
+}
+
+func (ec *EventController) newValues(e *types.Event, args map[string]interface{}) string {
+	if e.Values == nil || len(e.Values) == 0 {
+		return ` new_values AS ( SELECT * FROM ` + ec.db.table("event_values") + ` WHERE FALSE )`
+	}
+	args["newValuesScales"], args["newValuesValues"] = measurementArrays(e.Values)
+	return ` new_values AS ( INSERT INTO ` + ec.db.table("event_values") + ` (event, scale, value) SELECT e.id, v.scale, v.value FROM new_event e, unnest(CAST(:newValuesScales AS bigint[]), CAST(:newValuesValues AS double precision[])) AS v ( scale, value ) RETURNING * )`
+}
+
+// measurementArrays returns the scales and the values of the measurements as array parameters.
+func measurementArrays(ms types.Measurements) (scales pq.Int64Array, values pq.Float64Array) {
+	scales, values = make(pq.Int64Array, len(ms)), make(pq.Float64Array, len(ms))
+	for i, m := range ms {
+		scales[i], values[i] = int64(m.Scale), m.Value
+	}
+	return
+}
+
+// validate checks that every rating of e is a value of a scale in one of the metrics linked to the event's type or inherited by it and that every measured value lies within the bounds of its interval scale. All violations are reported at once as types.InvalidRatingsError.
+func (ec *EventController) validate(ctx context.Context, tx *sqlx.Tx, e *types.Event) (err error) {
+	if len(e.Ratings) == 0 && len(e.Values) == 0 {
+		return
+	}
+	args := map[string]interface{}{"validateType": e.Type}
+	permitted := ` ` + inheritingNodes(ec.db, "id = :validateType") + ` SELECT ms.scale FROM inheriting i JOIN ` + ec.db.table("node_metric") + ` nm ON nm.node = i.id JOIN ` + ec.db.table("metric_scale") + ` ms ON nm.metric = ms.metric `
+	checks := make([]string, 0, 2)
+	if len(e.Ratings) != 0 {
+		args["validateRatings"] = idArray(e.Ratings)
+		checks = append(checks, `SELECT r.id AS value, NULL::::bigint AS scale, NULL::::double precision AS measured, CASE WHEN v.id IS NULL THEN 'unknown value' WHEN v.scale NOT IN (`+permitted+`) THEN 'value of a scale not in the metrics of the event type' END AS reason FROM unnest(CAST(:validateRatings AS bigint[])) AS r ( id ) LEFT JOIN `+ec.db.table("values")+` v ON v.id = r.id`)
+	}
+	if len(e.Values) != 0 {
+		args["validateValuesScales"], args["validateValuesValues"] = measurementArrays(e.Values)
+		checks = append(checks, `SELECT NULL::::bigint AS value, m.scale AS scale, m.value AS measured, CASE WHEN u.scale IS NULL THEN 'not an interval scale' WHEN u.scale NOT IN (`+permitted+`) THEN 'scale not in the metrics of the event type' WHEN m.value < u.min THEN 'value below minimum of the scale' WHEN m.value > u.max THEN 'value above maximum of the scale' END AS reason FROM unnest(CAST(:validateValuesScales AS bigint[]), CAST(:validateValuesValues AS double precision[])) AS m ( scale, value ) LEFT JOIN `+ec.db.table("units")+` u ON u.scale = m.scale`)
+	}
+	stmt, err := tx.PrepareNamedContext(ctx, `SELECT * FROM ( `+strings.Join(checks, ` UNION ALL `)+` ) AS c WHERE reason IS NOT NULL`)
+	if err != nil {
+		return
+	}
+	invalid := make(types.InvalidRatingsError, 0)
+	err = stmt.SelectContext(ctx, &invalid, args)
+	if err == nil && len(invalid) != 0 {
+		err = invalid
+	}
+	return
+}
+
+// Read implements the ResourceController interface
+func (ec *EventController) Read(ctx context.Context, id types.Id) (r types.Resource, err error) {
+	stmt, err := ec.db.preparex(selectEvents(ec.db.table("events"), ec.db.table("event_ratings"), ec.db.table("event_values"), "WHERE e.id = $1"))
+	if err != nil {
+		return
+	}
+	e := new(types.Event)
+	err = stmt.GetContext(ctx, e, id)
+	if err == nil {
+		r = e
+	} else if err == sql.ErrNoRows {
+		err = types.NewHttpError(http.StatusNotFound, fmt.Errorf("No event with id %d", id))
+	}
+	return
+}
+
+// Update implements the ResourceController interface
+func (ec *EventController) Update(ctx context.Context, r types.Resource) (err error) {
+	e, err := assertEvent(r)
+	if err != nil {
+		return
+	}
+	args := make(map[string]interface{})
+	q := "WITH" + ec.updatedEvent(e, args) + "," + ec.updatedRatings(e, args) + "," + ec.updatedValues(e, args) + " " + selectEvents("updated_event", "updated_ratings", "updated_values", "")
+	err = ec.db.performWithTransaction(ctx, func(tx *sqlx.Tx) (err error) {
+		err = ec.db.revise(ctx, tx, "event", ec.db.table("events"), e.Id)
+		if err != nil {
+			return
+		}
+		err = ec.validate(ctx, tx, e)
+		if err != nil {
+			return
+		}
+		stmt, err := tx.PrepareNamedContext(ctx, q)
+		if err != nil {
+			return
+		}
+		return stmt.GetContext(ctx, e, args)
+	})
+	if err == sql.ErrNoRows {
+		err = types.NewHttpError(http.StatusNotFound, fmt.Errorf("No event with id %d", e.Id))
+	}
+	return
+}
+
+func (ec *EventController) updatedEvent(e *types.Event, args map[string]interface{}) string {
+	args["updatedEventId"], args["updatedEventType"] = e.Id, e.Type
+	return ` updated_event AS ( UPDATE ` + ec.db.table("events") + ` SET type = :updatedEventType WHERE id = :updatedEventId RETURNING * )`
+}
+
+func (ec *EventController) updatedRatings(e *types.Event, args map[string]interface{}) (q string) {
+	q = ` deleted_ratings AS ( DELETE FROM ` + ec.db.table("event_ratings") + ` WHERE event IN ( SELECT id FROM updated_event ) )`
+	if e.Ratings == nil || len(e.Ratings) == 0 {
+		q += `, updated_ratings AS ( SELECT * FROM ` + ec.db.table("event_ratings") + ` WHERE FALSE )`
+		return
+	}
+	args["updatedRatings"] = idArray(e.Ratings)
+	q += `, updated_ratings AS ( INSERT INTO ` + ec.db.table("event_ratings") + ` (event, value) SELECT e.id, r.id FROM updated_event e, unnest(CAST(:updatedRatings AS bigint[])) AS r ( id ) RETURNING * )`
+	return
+}
+
+func (ec *EventController) updatedValues(e *types.Event, args map[string]interface{}) (q string) {
+	q = ` deleted_values AS ( DELETE FROM ` + ec.db.table("event_values") + ` WHERE event IN ( SELECT id FROM updated_event ) )`
+	if e.Values == nil || len(e.Values) == 0 {
+		q += `, updated_values AS ( SELECT * FROM ` + ec.db.table("event_values") + ` WHERE FALSE )`
+		return
+	}
+	args["updatedValuesScales"], args["updatedValuesValues"] = measurementArrays(e.Values)
+	q += `, updated_values AS ( INSERT INTO ` + ec.db.table("event_values") + ` (event, scale, value) SELECT e.id, v.scale, v.value FROM updated_event e, unnest(CAST(:updatedValuesScales AS bigint[]), CAST(:updatedValuesValues AS double precision[])) AS v ( scale, value ) RETURNING * )`
+	return
+}
+
+// Delete implements the ResourceController interface
+func (ec *EventController) Delete(ctx context.Context, id types.Id, cascade bool) (err error) {
+	return ec.db.deleteResource(ctx, "event", ec.db.table("events"), ec.impactQuery(), id, cascade, nil)
+}
+
+// Impact implements the ResourceController interface
+func (ec *EventController) Impact(ctx context.Context, id types.Id) (*types.Impact, error) {
+	return ec.db.resourceImpact(ctx, "event", ec.impactQuery(), id)
+}
+
+// impactQuery returns the query for the rows affected by deleting an event, i.e. the event with its ratings and measured values.
+func (ec *EventController) impactQuery() string {
+	return `SELECT 'deleted' AS effect, 'events' AS kind, row_to_json(e) AS row FROM ` + ec.db.table("events") + ` e WHERE e.id = $1` +
+		` UNION ALL SELECT 'deleted', 'event_ratings', row_to_json(r) FROM ` + ec.db.table("event_ratings") + ` r WHERE r.event = $1` +
+		` UNION ALL SELECT 'deleted', 'event_values', row_to_json(v) FROM ` + ec.db.table("event_values") + ` v WHERE v.event = $1`
+}
+
+type EventReader struct {
+	err   error
+	rows  *sqlx.Rows
+	count counter
+}
+
+// Read implements the types.DocumentReader interface
+func (er *EventReader) Read(r types.Resource) (ok bool, err error) {
+	if er.err != nil {
+		err = er.err
+		return
+	}
+	e, err := assertEvent(r)
+	if err != nil {
+		return
+	}
+	if ok = er.rows.Next(); ok {
+		err = er.rows.StructScan(e)
+	} else {
+		er.rows.Close()
+	}
+	if err != nil {
+		ok, er.err = false, err
+	}
+	return
+}
+
+// Close implements the types.DocumentReader interface
+func (er *EventReader) Close() error {
+	if er.rows == nil {
+		return er.err
+	}
+	return er.rows.Close()
+}
+
+// Total implements the types.CountingReader interface
+func (er *EventReader) Total() (int, error) {
+	if er.err != nil {
+		return 0, er.err
+	}
+	return er.count.total()
+}
+
+func assertEvent(r types.Resource) (e *types.Event, err error) {
+	switch r := r.(type) {
+	case *types.Event:
+		e = r
+	default:
+		err = fmt.Errorf("Unsuported Resource type, expected *Event.")
+	}
+	return
+}
+
+// selectEvents selects the events with their ratings and measured values aggregated as json. Ratings and values are selected in subqueries as joining both would multiply the rows.
+func selectEvents(events, ratings, values, where string) string {
+	return `SELECT e.id, e.type, e.revision, COALESCE(( SELECT json_agg(r.value) FROM ` + ratings + ` r WHERE r.event = e.id ), '[]') AS ratings, COALESCE(( SELECT json_agg(v) FROM ` + values + ` v WHERE v.event = e.id ), '[]') AS values FROM ` + events + ` e ` + where
+}
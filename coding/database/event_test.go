@@ -0,0 +1,98 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/janvogt/gotambora/coding/types"
+	"reflect"
+	"testing"
+)
+
+func TestCreateEvent(t *testing.T) {
+	col := []string{"id", "type", "ratings", "values"}
+	qEvent := `WITH new_event AS \( INSERT INTO prefix_events \( type \) VALUES \( \$1 \) RETURNING \* \),`
+	qSelect := ` SELECT e.id, e.type, e.revision, COALESCE\(\( SELECT json_agg\(r.value\) FROM new_ratings r WHERE r.event = e.id \), '\[\]'\) AS ratings, COALESCE\(\( SELECT json_agg\(v\) FROM new_values v WHERE v.event = e.id \), '\[\]'\) AS values FROM new_event e `
+	qValidate := `SELECT \* FROM \( SELECT r.id AS value, .* FROM unnest\(CAST\(\$2 AS bigint\[\]\)\) AS r \( id \) .* UNION ALL SELECT NULL::bigint AS value, m.scale AS scale, .* FROM unnest\(CAST\(\$4 AS bigint\[\]\), CAST\(\$5 AS double precision\[\]\)\) AS m \( scale, value \) .* \) AS c WHERE reason IS NOT NULL`
+	tests := []struct {
+		v  bool
+		q  string
+		en *types.Event
+		a  []driver.Value
+		r  []driver.Value
+		ee *types.Event
+	}{
+		{
+			true,
+			qEvent + ` new_ratings AS \( INSERT INTO prefix_event_ratings \(event, value\) SELECT e.id, r.id FROM new_event e, unnest\(CAST\(\$2 AS bigint\[\]\)\) AS r \( id \) RETURNING \* \), new_values AS \( INSERT INTO prefix_event_values \(event, scale, value\) SELECT e.id, v.scale, v.value FROM new_event e, unnest\(CAST\(\$3 AS bigint\[\]\), CAST\(\$4 AS double precision\[\]\)\) AS v \( scale, value \) RETURNING \* \)` + qSelect,
+			&types.Event{0, types.OptionalId{7, true}, types.RelationToMany{3, 4}, types.Measurements{types.Measurement{2, 12.5}}, 0},
+			[]driver.Value{7, "{3,4}", "{2}", "{12.5}"},
+			[]driver.Value{1, 7, `[3,4]`, `[{"event":1,"scale":2,"value":12.5}]`},
+			&types.Event{1, types.OptionalId{7, true}, types.RelationToMany{3, 4}, types.Measurements{types.Measurement{2, 12.5}}, 0},
+		},
+		{
+			false,
+			qEvent + ` new_ratings AS \( SELECT \* FROM prefix_event_ratings WHERE FALSE \), new_values AS \( SELECT \* FROM prefix_event_values WHERE FALSE \)` + qSelect,
+			&types.Event{0, types.OptionalId{}, types.RelationToMany{}, nil, 0},
+			[]driver.Value{nil},
+			[]driver.Value{2, nil, `[]`, `[]`},
+			&types.Event{2, types.OptionalId{}, types.RelationToMany{}, types.Measurements{}, 0},
+		},
+	}
+	for i, test := range tests {
+		db := newTestDB(t, "prefix")
+		sqlmock.ExpectBegin()
+		if test.v {
+			sqlmock.ExpectPrepare()
+			sqlmock.ExpectQuery(qValidate).WillReturnRows(sqlmock.NewRows([]string{"value", "scale", "measured", "reason"}))
+		}
+		sqlmock.ExpectPrepare()
+		sqlmock.ExpectQuery(test.q).WithArgs(test.a...).WillReturnRows(sqlmock.NewRows(col).AddRow(test.r...))
+		sqlmock.ExpectCommit()
+		c := &EventController{db}
+		e := c.Create(context.Background(), test.en)
+		if e != nil {
+			t.Errorf("Testcase %d: Unexcpected Error: %s\n", i, e)
+		} else if !reflect.DeepEqual(test.en, test.ee) {
+			t.Errorf("Testcase %d: Unexpected result:\n%+v\nexpected:\n%+v\n", i, test.en, test.ee)
+		} else if e = db.Close(); e != nil {
+			t.Errorf("Testcase %d: Unexpected database interaction: %s \n", i, e)
+		}
+	}
+}
+
+func TestCreateEventInvalidRatings(t *testing.T) {
+	db := newTestDB(t, "prefix")
+	sqlmock.ExpectBegin()
+	sqlmock.ExpectPrepare()
+	sqlmock.ExpectQuery(`SELECT \* FROM \( SELECT r.id AS value, .* FROM unnest\(CAST\(\$2 AS bigint\[\]\)\) AS r \( id \) LEFT JOIN prefix_values v ON v.id = r.id \) AS c WHERE reason IS NOT NULL`).
+		WithArgs(7, "{3,4}").
+		WillReturnRows(sqlmock.NewRows([]string{"value", "scale", "measured", "reason"}).AddRow(4, nil, nil, "unknown value"))
+	sqlmock.ExpectRollback()
+	c := &EventController{db}
+	err := c.Create(context.Background(), &types.Event{0, types.OptionalId{7, true}, types.RelationToMany{3, 4}, nil, 0})
+	expected := types.InvalidRatingsError{types.InvalidRating{Value: types.OptionalId{4, true}, Reason: "unknown value"}}
+	if !reflect.DeepEqual(err, expected) {
+		t.Errorf("Expected invalid ratings error:\n%+v\nbut got:\n%+v\n", expected, err)
+	} else if err = db.Close(); err != nil {
+		t.Errorf("Unexpected database interaction: %s \n", err)
+	}
+}
+
+func TestReadEvent(t *testing.T) {
+	q := `SELECT e.id, e.type, e.revision, COALESCE\(\( SELECT json_agg\(r.value\) FROM prefix_event_ratings r WHERE r.event = e.id \), '\[\]'\) AS ratings, COALESCE\(\( SELECT json_agg\(v\) FROM prefix_event_values v WHERE v.event = e.id \), '\[\]'\) AS values FROM prefix_events e WHERE e.id = \$1`
+	col := []string{"id", "type", "ratings", "values"}
+	db := newTestDB(t, "prefix")
+	sqlmock.ExpectPrepare()
+	sqlmock.ExpectQuery(q).WithArgs(5).WillReturnRows(sqlmock.NewRows(col).AddRow(5, 2, `[8]`, `[]`))
+	c := &EventController{db}
+	e, err := c.Read(context.Background(), types.Id(5))
+	expected := &types.Event{5, types.OptionalId{2, true}, types.RelationToMany{8}, types.Measurements{}, 0}
+	if err != nil {
+		t.Errorf("Unexcpected Error: %s\n", err)
+	} else if !reflect.DeepEqual(e, expected) {
+		t.Errorf("Unexpected result:\n%+v\nexpected:\n%+v\n", e, expected)
+	} else if err = db.Close(); err != nil {
+		t.Errorf("Unexpected database interaction: %s \n", err)
+	}
+}
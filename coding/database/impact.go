@@ -0,0 +1,72 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"github.com/janvogt/gotambora/coding/types"
+	"github.com/jmoiron/sqlx"
+	"net/http"
+)
+
+// impact queries the rows affected by deleting the resource with the given id. The query q selects the effect, the kind and the json encoded row of every affected row. The effect is one of "deleted", "cascaded" or "blocking".
+func impact(ctx context.Context, db sqlx.QueryerContext, q string, id types.Id) (impact *types.Impact, err error) {
+	rows, err := db.QueryxContext(ctx, q, id)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	i := types.NewImpact()
+	for rows.Next() {
+		var effect string
+		var row types.AffectedRow
+		err = rows.Scan(&effect, &row.Kind, &row.Row)
+		if err == nil {
+			err = i.Add(effect, row)
+		}
+		if err != nil {
+			return
+		}
+	}
+	if err = rows.Err(); err == nil {
+		impact = i
+	}
+	return
+}
+
+// resourceImpact returns the impact of deleting the resource with the given id as queried by q. It is an error if the resource does not exist.
+func (db *DB) resourceImpact(ctx context.Context, name, q string, id types.Id) (i *types.Impact, err error) {
+	i, err = impact(ctx, db, q, id)
+	if err == nil && len(i.Deleted) == 0 {
+		i, err = nil, types.NewHttpError(http.StatusNotFound, fmt.Errorf("No %s found with id %d", name, id))
+	}
+	return
+}
+
+// deleteResource deletes the resource with the given id from table if there are no blocking rows and it is at the revision required by ctx, if any. Rows of other resources are only deleted if cascade is true. The impact is queried by q. If deleting is not nil, it is called within the same transaction right before the resource is deleted.
+func (db *DB) deleteResource(ctx context.Context, name, table, q string, id types.Id, cascade bool, deleting func(tx *sqlx.Tx) error) error {
+	return db.performWithTransaction(ctx, func(tx *sqlx.Tx) (err error) {
+		err = db.lockRevision(ctx, tx, name, table, id)
+		if err != nil {
+			return
+		}
+		i, err := impact(ctx, tx, q, id)
+		switch {
+		case err != nil:
+			return
+		case len(i.Deleted) == 0:
+			return types.NewHttpError(http.StatusNotFound, fmt.Errorf("No %s found with id %d", name, id))
+		case len(i.Blocking) != 0:
+			return types.ImpactError{Impact: i, Message: fmt.Sprintf("The %s with id %d can't be deleted as long as it is referenced.", name, id)}
+		case len(i.Cascaded) != 0 && !cascade:
+			return types.ImpactError{Impact: i, Message: fmt.Sprintf("Deleting the %s with id %d deletes other resources as well. Set cascade=true to delete them.", name, id)}
+		}
+		if deleting != nil {
+			err = deleting(tx)
+			if err != nil {
+				return
+			}
+		}
+		_, err = tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE id = $1", id)
+		return
+	})
+}
@@ -0,0 +1,156 @@
+package database
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/janvogt/gotambora/coding/types"
+	"reflect"
+	"testing"
+)
+
+func TestNodeImpact(t *testing.T) {
+	q := `WITH RECURSIVE tree \(.*\) SELECT CASE WHEN t.depth = 0 THEN 'deleted' ELSE 'cascaded' END AS effect, 'nodes' AS kind, row_to_json\(n\) AS row FROM tree t JOIN prefix_nodes n ON n.id = t.id UNION ALL .* UNION ALL SELECT 'blocking', 'events', row_to_json\(e\) FROM prefix_events e WHERE e.type IN \( SELECT id FROM tree \)`
+	col := []string{"effect", "kind", "row"}
+	db := newTestDB(t, "prefix")
+	sqlmock.ExpectQuery(q).WithArgs(1).WillReturnRows(sqlmock.NewRows(col).AddRow("deleted", "nodes", `{"id":1}`).AddRow("cascaded", "nodes", `{"id":2}`).AddRow("deleted", "node_metric", `{"node":1,"metric":3}`))
+	c := &NodeController{db}
+	impact, err := c.Impact(context.Background(), types.Id(1))
+	expected := &types.Impact{
+		[]types.AffectedRow{types.AffectedRow{"nodes", types.Row(`{"id":1}`)}, types.AffectedRow{"node_metric", types.Row(`{"node":1,"metric":3}`)}},
+		[]types.AffectedRow{types.AffectedRow{"nodes", types.Row(`{"id":2}`)}},
+		[]types.AffectedRow{},
+	}
+	if err != nil {
+		t.Errorf("Unexcpected Error: %s\n", err)
+	} else if !reflect.DeepEqual(impact, expected) {
+		t.Errorf("Unexpected result:\n%+v\nexpected:\n%+v\n", impact, expected)
+	}
+	sqlmock.ExpectQuery(q).WithArgs(4).WillReturnRows(sqlmock.NewRows(col))
+	impact, err = c.Impact(context.Background(), types.Id(4))
+	if herr, ok := err.(types.HttpError); !ok || herr.Status() != 404 || impact != nil {
+		t.Errorf("Expected not found error for unknown node, but got impact %+v and err %s", impact, err)
+	}
+	if err = db.Close(); err != nil {
+		t.Errorf("Unexpected database interaction: %s \n", err)
+	}
+}
+
+func TestScaleImpact(t *testing.T) {
+	q := `SELECT 'deleted' AS effect, 'scales' AS kind, row_to_json\(s\) AS row FROM prefix_scales s WHERE s.id = \$1 UNION ALL SELECT 'deleted', 'values', .* FROM prefix_values v WHERE v.scale = \$1 UNION ALL .* prefix_units u .* UNION ALL SELECT 'cascaded', 'metric_scale', .* UNION ALL SELECT 'blocking', 'event_ratings', .* JOIN prefix_values v ON v.id = r.value WHERE v.scale = \$1 UNION ALL SELECT 'blocking', 'event_values', row_to_json\(ev\) FROM prefix_event_values ev WHERE ev.scale = \$1`
+	col := []string{"effect", "kind", "row"}
+	db := newTestDB(t, "prefix")
+	sqlmock.ExpectQuery(q).WithArgs(2).WillReturnRows(sqlmock.NewRows(col).AddRow("deleted", "scales", `{"id":2}`).AddRow("deleted", "values", `{"id":7,"scale":2}`).AddRow("cascaded", "metric_scale", `{"metric":1,"scale":2}`).AddRow("blocking", "event_ratings", `{"event":4,"value":7}`))
+	c := &ScaleController{db}
+	impact, err := c.Impact(context.Background(), types.Id(2))
+	expected := &types.Impact{
+		[]types.AffectedRow{types.AffectedRow{"scales", types.Row(`{"id":2}`)}, types.AffectedRow{"values", types.Row(`{"id":7,"scale":2}`)}},
+		[]types.AffectedRow{types.AffectedRow{"metric_scale", types.Row(`{"metric":1,"scale":2}`)}},
+		[]types.AffectedRow{types.AffectedRow{"event_ratings", types.Row(`{"event":4,"value":7}`)}},
+	}
+	if err != nil {
+		t.Errorf("Unexcpected Error: %s\n", err)
+	} else if !reflect.DeepEqual(impact, expected) {
+		t.Errorf("Unexpected result:\n%+v\nexpected:\n%+v\n", impact, expected)
+	}
+	if err = db.Close(); err != nil {
+		t.Errorf("Unexpected database interaction: %s \n", err)
+	}
+}
+
+func TestMetricImpact(t *testing.T) {
+	q := `SELECT 'deleted' AS effect, 'metrics' AS kind, row_to_json\(m\) AS row FROM prefix_metrics m WHERE m.id = \$1 UNION ALL SELECT 'deleted', 'metric_scale', row_to_json\(ms\) FROM prefix_metric_scale ms WHERE ms.metric = \$1 UNION ALL SELECT 'blocking', 'node_metric', row_to_json\(nm\) FROM prefix_node_metric nm WHERE nm.metric = \$1`
+	col := []string{"effect", "kind", "row"}
+	db := newTestDB(t, "prefix")
+	sqlmock.ExpectQuery(q).WithArgs(1).WillReturnRows(sqlmock.NewRows(col).AddRow("deleted", "metrics", `{"id":1}`).AddRow("deleted", "metric_scale", `{"metric":1,"scale":2}`).AddRow("blocking", "node_metric", `{"node":3,"metric":1}`))
+	c := &MetricController{db}
+	impact, err := c.Impact(context.Background(), types.Id(1))
+	expected := &types.Impact{
+		[]types.AffectedRow{types.AffectedRow{"metrics", types.Row(`{"id":1}`)}, types.AffectedRow{"metric_scale", types.Row(`{"metric":1,"scale":2}`)}},
+		[]types.AffectedRow{},
+		[]types.AffectedRow{types.AffectedRow{"node_metric", types.Row(`{"node":3,"metric":1}`)}},
+	}
+	if err != nil {
+		t.Errorf("Unexcpected Error: %s\n", err)
+	} else if !reflect.DeepEqual(impact, expected) {
+		t.Errorf("Unexpected result:\n%+v\nexpected:\n%+v\n", impact, expected)
+	}
+	sqlmock.ExpectQuery(q).WithArgs(4).WillReturnRows(sqlmock.NewRows(col))
+	impact, err = c.Impact(context.Background(), types.Id(4))
+	if herr, ok := err.(types.HttpError); !ok || herr.Status() != 404 || impact != nil {
+		t.Errorf("Expected not found error for unknown metric, but got impact %+v and err %s", impact, err)
+	}
+	if err = db.Close(); err != nil {
+		t.Errorf("Unexpected database interaction: %s \n", err)
+	}
+}
+
+func TestEventImpact(t *testing.T) {
+	q := `SELECT 'deleted' AS effect, 'events' AS kind, row_to_json\(e\) AS row FROM prefix_events e WHERE e.id = \$1 UNION ALL SELECT 'deleted', 'event_ratings', row_to_json\(r\) FROM prefix_event_ratings r WHERE r.event = \$1 UNION ALL SELECT 'deleted', 'event_values', row_to_json\(v\) FROM prefix_event_values v WHERE v.event = \$1`
+	col := []string{"effect", "kind", "row"}
+	db := newTestDB(t, "prefix")
+	sqlmock.ExpectQuery(q).WithArgs(5).WillReturnRows(sqlmock.NewRows(col).AddRow("deleted", "events", `{"id":5,"type":1}`).AddRow("deleted", "event_ratings", `{"event":5,"value":7}`).AddRow("deleted", "event_values", `{"event":5,"scale":3,"value":2.5}`))
+	c := &EventController{db}
+	impact, err := c.Impact(context.Background(), types.Id(5))
+	expected := &types.Impact{
+		[]types.AffectedRow{types.AffectedRow{"events", types.Row(`{"id":5,"type":1}`)}, types.AffectedRow{"event_ratings", types.Row(`{"event":5,"value":7}`)}, types.AffectedRow{"event_values", types.Row(`{"event":5,"scale":3,"value":2.5}`)}},
+		[]types.AffectedRow{},
+		[]types.AffectedRow{},
+	}
+	if err != nil {
+		t.Errorf("Unexcpected Error: %s\n", err)
+	} else if !reflect.DeepEqual(impact, expected) {
+		t.Errorf("Unexpected result:\n%+v\nexpected:\n%+v\n", impact, expected)
+	}
+	if err = db.Close(); err != nil {
+		t.Errorf("Unexpected database interaction: %s \n", err)
+	}
+}
+
+func TestDeleteNode(t *testing.T) {
+	q := `WITH RECURSIVE tree \(.*\) SELECT CASE WHEN t.depth = 0 THEN 'deleted' ELSE 'cascaded' END AS effect, .*`
+	col := []string{"effect", "kind", "row"}
+	db := newTestDB(t, "prefix")
+	c := &NodeController{db}
+	sqlmock.ExpectBegin()
+	sqlmock.ExpectQuery(q).WithArgs(1).WillReturnRows(sqlmock.NewRows(col).AddRow("deleted", "nodes", `{"id":1}`).AddRow("cascaded", "nodes", `{"id":2}`))
+	sqlmock.ExpectRollback()
+	err := c.Delete(context.Background(), types.Id(1), false)
+	if ierr, ok := err.(types.ImpactError); !ok || ierr.Status() != 409 || len(ierr.Impact.Cascaded) != 1 {
+		t.Errorf("Expected conflict for deletion without cascade, but got %s", err)
+	}
+	sqlmock.ExpectBegin()
+	sqlmock.ExpectQuery(q).WithArgs(1).WillReturnRows(sqlmock.NewRows(col).AddRow("deleted", "nodes", `{"id":1}`).AddRow("cascaded", "nodes", `{"id":2}`))
+	sqlmockExpectTouchParent(1)
+	sqlmock.ExpectExec(`WITH RECURSIVE tree \(.*\) UPDATE prefix_nodes SET revision = revision \+ 1 WHERE id IN \( SELECT l."from" FROM prefix_links l WHERE l."to" IN \( SELECT id FROM tree \) \) AND id NOT IN \( SELECT id FROM tree \)`).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlmock.ExpectExec(`DELETE FROM prefix_nodes WHERE id = \$1`).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlmock.ExpectCommit()
+	if err = c.Delete(context.Background(), types.Id(1), true); err != nil {
+		t.Errorf("Unexcpected Error: %s\n", err)
+	}
+	sqlmock.ExpectBegin()
+	sqlmock.ExpectQuery(q).WithArgs(1).WillReturnRows(sqlmock.NewRows(col).AddRow("deleted", "nodes", `{"id":1}`).AddRow("blocking", "events", `{"id":5,"type":1}`))
+	sqlmock.ExpectRollback()
+	err = c.Delete(context.Background(), types.Id(1), true)
+	if ierr, ok := err.(types.ImpactError); !ok || ierr.Status() != 409 || len(ierr.Impact.Blocking) != 1 {
+		t.Errorf("Expected conflict for deletion of referenced node, but got %s", err)
+	}
+	if err = db.Close(); err != nil {
+		t.Errorf("Unexpected database interaction: %s \n", err)
+	}
+}
+
+func TestDeleteScale(t *testing.T) {
+	db := newTestDB(t, "prefix")
+	c := &ScaleController{db}
+	sqlmock.ExpectBegin()
+	sqlmock.ExpectQuery(`SELECT 'deleted' AS effect, 'scales' AS kind, .*`).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"effect", "kind", "row"}).AddRow("deleted", "scales", `{"id":1}`).AddRow("cascaded", "metric_scale", `{"metric":2,"scale":1}`))
+	sqlmock.ExpectExec(`UPDATE prefix_metrics SET revision = revision \+ 1 WHERE id IN \( SELECT metric FROM prefix_metric_scale WHERE scale = \$1 \)`).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlmock.ExpectExec(`DELETE FROM prefix_scales WHERE id = \$1`).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlmock.ExpectCommit()
+	if err := c.Delete(context.Background(), types.Id(1), true); err != nil {
+		t.Errorf("Unexcpected Error: %s\n", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Errorf("Unexpected database interaction: %s \n", err)
+	}
+}
@@ -0,0 +1,115 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"github.com/janvogt/gotambora/coding/types"
+	"github.com/jmoiron/sqlx"
+	"net/http"
+)
+
+// Merge satisfies the types.ContextNodeController interface. The revisions of the parent of the merged node, of the heirs of the target, of the nodes referencing the merged node and of the moved children and events are incremented.
+func (nc *NodeController) Merge(ctx context.Context, id, target types.Id) (report *types.MergeReport, err error) {
+	if id == target {
+		err = types.NewHttpError(http.StatusConflict, fmt.Errorf("Node %d can't be merged into itself.", id))
+		return
+	}
+	r := &types.MergeReport{Source: id, Target: target}
+	err = nc.db.performWithTransaction(ctx, func(tx *sqlx.Tx) (err error) {
+		err = nc.lockMerged(ctx, tx, id, target)
+		if err != nil {
+			return
+		}
+		moves := []struct {
+			ids *types.RelationToMany
+			q   string
+		}{
+			{&r.Children, `WITH moved AS ( UPDATE ` + nc.db.table("nodes") + ` SET parent = $2, "index" = "index" + ` + nextIndex(nc.db, "$2") + `, revision = revision + 1 WHERE parent = $1 RETURNING id ) SELECT id FROM moved ORDER BY id`},
+			{&r.References, `WITH moved AS ( SELECT "to", type FROM ` + nc.db.table("links") + ` WHERE "from" = $1 AND "to" <> $2 ), inserted AS ( INSERT INTO ` + nc.db.table("links") + ` ("from", "to", type) SELECT $2, m."to", m.type FROM moved m WHERE NOT EXISTS ( SELECT 1 FROM ` + nc.db.table("links") + ` e WHERE e."from" = $2 AND e."to" = m."to" ) ) SELECT "to" FROM moved ORDER BY "to"`},
+			{&r.ReferencedBy, `WITH moved AS ( SELECT "from", type FROM ` + nc.db.table("links") + ` WHERE "to" = $1 AND "from" <> $2 ), inserted AS ( INSERT INTO ` + nc.db.table("links") + ` ("from", "to", type) SELECT m."from", $2, m.type FROM moved m WHERE NOT EXISTS ( SELECT 1 FROM ` + nc.db.table("links") + ` e WHERE e."from" = m."from" AND e."to" = $2 ) ) SELECT "from" FROM moved ORDER BY "from"`},
+			{&r.Metrics, `WITH moved AS ( SELECT metric FROM ` + nc.db.table("node_metric") + ` WHERE node = $1 ), inserted AS ( INSERT INTO ` + nc.db.table("node_metric") + ` (node, metric) SELECT $2, m.metric FROM moved m WHERE NOT EXISTS ( SELECT 1 FROM ` + nc.db.table("node_metric") + ` e WHERE e.node = $2 AND e.metric = m.metric ) ) SELECT metric FROM moved ORDER BY metric`},
+			{&r.Events, `WITH moved AS ( UPDATE ` + nc.db.table("events") + ` SET type = $2, revision = revision + 1 WHERE type = $1 RETURNING id ) SELECT id FROM moved ORDER BY id`},
+		}
+		for _, m := range moves {
+			*m.ids = make(types.RelationToMany, 0)
+			err = tx.SelectContext(ctx, m.ids, m.q, id, target)
+			if err != nil {
+				return
+			}
+		}
+		err = nc.touchParent(ctx, tx, id)
+		if err == nil {
+			err = nc.touchHeirs(ctx, tx, target)
+		}
+		if err == nil {
+			err = nc.db.touch(ctx, tx, nc.db.table("nodes"), r.ReferencedBy)
+		}
+		if err != nil {
+			return
+		}
+		execs := []struct {
+			q    string
+			args []interface{}
+		}{
+			{`UPDATE ` + nc.db.table("nodes") + ` t SET labels = s.labels || t.labels, revision = t.revision + 1 FROM ` + nc.db.table("nodes") + ` s WHERE s.id = $1 AND t.id = $2`, []interface{}{id, target}},
+			{`UPDATE ` + nc.db.table("node_redirects") + ` SET target = $2 WHERE target = $1`, []interface{}{id, target}},
+			{`INSERT INTO ` + nc.db.table("node_redirects") + ` (id, target) VALUES ($1, $2)`, []interface{}{id, target}},
+			{`DELETE FROM ` + nc.db.table("nodes") + ` WHERE id = $1`, []interface{}{id}},
+		}
+		for _, e := range execs {
+			_, err = tx.ExecContext(ctx, e.q, e.args...)
+			if err != nil {
+				return
+			}
+		}
+		return
+	})
+	if err == nil {
+		report = r
+	}
+	return
+}
+
+// lockMerged locks the source and the target of a merge. It is an error if one of them does not exist or if the target is a descendant of the source.
+func (nc *NodeController) lockMerged(ctx context.Context, tx *sqlx.Tx, id, target types.Id) (err error) {
+	found := make([]types.Id, 0, 2)
+	err = tx.SelectContext(ctx, &found, `SELECT id FROM `+nc.db.table("nodes")+` WHERE id IN ($1, $2) ORDER BY id FOR UPDATE`, id, target)
+	if err != nil {
+		return
+	}
+	for _, missing := range []types.Id{id, target} {
+		if !containsId(found, missing) {
+			return types.NewHttpError(http.StatusNotFound, fmt.Errorf("No node with id %d", missing))
+		}
+	}
+	var descendant bool
+	err = tx.GetContext(ctx, &descendant, `WITH RECURSIVE `+ancestry(nc.db, "$1")+` SELECT EXISTS ( SELECT 1 FROM ancestry WHERE id = $2 )`, target, id)
+	if err == nil && descendant {
+		err = types.NewHttpError(http.StatusConflict, fmt.Errorf("Node %d can't be merged into its descendant %d.", id, target))
+	}
+	return
+}
+
+func containsId(ids []types.Id, id types.Id) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// sameIds returns whether a and b contain the same ids regardless of their order and repetitions.
+func sameIds(a, b []types.Id) bool {
+	for _, id := range a {
+		if !containsId(b, id) {
+			return false
+		}
+	}
+	for _, id := range b {
+		if !containsId(a, id) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,66 @@
+package database
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/janvogt/gotambora/coding/types"
+	"reflect"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	db := newTestDB(t, "prefix")
+	sqlmock.ExpectBegin()
+	sqlmock.ExpectQuery(`SELECT id FROM prefix_nodes WHERE id IN \(\$1, \$2\) ORDER BY id FOR UPDATE`).WithArgs(3, 7).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3).AddRow(7))
+	sqlmock.ExpectQuery(`WITH RECURSIVE ancestry \(.*\) SELECT EXISTS \( SELECT 1 FROM ancestry WHERE id = \$2 \)`).WithArgs(7, 3).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	sqlmock.ExpectQuery(`WITH moved AS \( UPDATE prefix_nodes SET parent = \$2, "index" = "index" \+ \( SELECT .* \), revision = revision \+ 1 WHERE parent = \$1 RETURNING id \) SELECT id FROM moved ORDER BY id`).WithArgs(3, 7).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4).AddRow(5))
+	sqlmock.ExpectQuery(`WITH moved AS \( SELECT "to", type FROM prefix_links WHERE "from" = \$1 AND "to" <> \$2 \), inserted AS \( INSERT INTO prefix_links .* \) SELECT "to" FROM moved ORDER BY "to"`).WithArgs(3, 7).WillReturnRows(sqlmock.NewRows([]string{"to"}).AddRow(9))
+	sqlmock.ExpectQuery(`WITH moved AS \( SELECT "from", type FROM prefix_links WHERE "to" = \$1 AND "from" <> \$2 \), .* SELECT "from" FROM moved ORDER BY "from"`).WithArgs(3, 7).WillReturnRows(sqlmock.NewRows([]string{"from"}).AddRow(6))
+	sqlmock.ExpectQuery(`WITH moved AS \( SELECT metric FROM prefix_node_metric WHERE node = \$1 \), .* SELECT metric FROM moved ORDER BY metric`).WithArgs(3, 7).WillReturnRows(sqlmock.NewRows([]string{"metric"}).AddRow(2))
+	sqlmock.ExpectQuery(`WITH moved AS \( UPDATE prefix_events SET type = \$2, revision = revision \+ 1 WHERE type = \$1 RETURNING id \) SELECT id FROM moved ORDER BY id`).WithArgs(3, 7).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(11))
+	sqlmockExpectTouchParent(3)
+	sqlmockExpectTouchHeirs("{7}")
+	sqlmock.ExpectExec(`UPDATE prefix_nodes SET revision = revision \+ 1 WHERE id = ANY\(\$1\)`).WithArgs("{6}").WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlmock.ExpectExec(`UPDATE prefix_nodes t SET labels = s.labels \|\| t.labels, revision = t.revision \+ 1 FROM prefix_nodes s WHERE s.id = \$1 AND t.id = \$2`).WithArgs(3, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlmock.ExpectExec(`UPDATE prefix_node_redirects SET target = \$2 WHERE target = \$1`).WithArgs(3, 7).WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlmock.ExpectExec(`INSERT INTO prefix_node_redirects \(id, target\) VALUES \(\$1, \$2\)`).WithArgs(3, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlmock.ExpectExec(`DELETE FROM prefix_nodes WHERE id = \$1`).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlmock.ExpectCommit()
+	c := &NodeController{db}
+	report, err := c.Merge(context.Background(), types.Id(3), types.Id(7))
+	expected := &types.MergeReport{3, 7, types.RelationToMany{4, 5}, types.RelationToMany{9}, types.RelationToMany{6}, types.RelationToMany{2}, types.RelationToMany{11}}
+	if err != nil {
+		t.Errorf("Unexcpected Error: %s\n", err)
+	} else if !reflect.DeepEqual(report, expected) {
+		t.Errorf("Unexpected result:\n%+v\nexpected:\n%+v\n", report, expected)
+	}
+	sqlmock.ExpectBegin()
+	sqlmock.ExpectQuery(`SELECT id FROM prefix_nodes WHERE id IN \(\$1, \$2\) ORDER BY id FOR UPDATE`).WithArgs(3, 8).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	sqlmock.ExpectRollback()
+	report, err = c.Merge(context.Background(), types.Id(3), types.Id(8))
+	if herr, ok := err.(types.HttpError); !ok || herr.Status() != 404 || report != nil {
+		t.Errorf("Expected not found error for unknown target, but got report %+v and err %s", report, err)
+	}
+	report, err = c.Merge(context.Background(), types.Id(3), types.Id(3))
+	if herr, ok := err.(types.HttpError); !ok || herr.Status() != 409 || report != nil {
+		t.Errorf("Expected conflict when merging node into itself, but got report %+v and err %s", report, err)
+	}
+	if err = db.Close(); err != nil {
+		t.Errorf("Unexpected database interaction: %s \n", err)
+	}
+}
+
+func TestReadMergedNode(t *testing.T) {
+	db := newTestDB(t, "prefix")
+	sqlmock.ExpectPrepare()
+	sqlmock.ExpectQuery(`SELECT n.id, .* WHERE n.id = \$1`).WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	sqlmock.ExpectQuery(`SELECT target FROM prefix_node_redirects WHERE id = \$1`).WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"target"}).AddRow(7))
+	c := &NodeController{db}
+	n, err := c.Read(context.Background(), types.Id(3))
+	if err != (types.RedirectError{3, 7}) || n != nil {
+		t.Errorf("Expected redirect to node 7, but got node %+v and err %s", n, err)
+	}
+	if err = db.Close(); err != nil {
+		t.Errorf("Unexpected database interaction: %s \n", err)
+	}
+}
@@ -1,13 +1,16 @@
 package database
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
 	"github.com/janvogt/gotambora/coding/types"
 	"github.com/jmoiron/sqlx"
 	"net/http"
+	"strings"
 )
 
-func (db *DB) MetricController() types.ResourceController {
+func (db *DB) MetricController() types.ContextResourceController {
 	return &MetricController{db}
 }
 
@@ -20,32 +23,60 @@ func (mc *MetricController) New() (r types.Resource) {
 	return new(types.Metric)
 }
 
-// Query implements the ResourceController interface
-func (mc *MetricController) Query(q map[string][]string) (res types.ResourceReader) {
-	reader := new(MetricReader)
-	reader.rows, reader.err = mc.db.Queryx(selectMetrics(mc.db.table("metrics"), mc.db.table("metric_scale"), ""))
+// Query implements the ResourceController interface. The metrics can be filtered by "id", "label", the "node" linked to them and the "scale" they contain.
+func (mc *MetricController) Query(ctx context.Context, q map[string][]string) (res types.ResourceReader) {
+	args := make(map[string]interface{})
+	conditions := make([]string, 0, 4)
+	if len(q["id"]) != 0 {
+		conditions = append(conditions, "m.id "+anyParameter("id", q["id"], args))
+	}
+	if len(q["label"]) != 0 {
+		conditions = append(conditions, "m.label "+anyParameter("label", q["label"], args))
+	}
+	if len(q["node"]) != 0 {
+		conditions = append(conditions, "m.id IN ( SELECT metric FROM "+mc.db.table("node_metric")+" WHERE node "+anyParameter("node", q["node"], args)+") ")
+	}
+	if len(q["scale"]) != 0 {
+		conditions = append(conditions, "m.id IN ( SELECT metric FROM "+mc.db.table("metric_scale")+" WHERE scale "+anyParameter("scale", q["scale"], args)+") ")
+	}
+	where := ""
+	if len(conditions) != 0 {
+		where = "WHERE " + strings.Join(conditions, "AND ")
+	}
+	reader := &MetricReader{count: count(ctx, mc.db, mc.db.table("metrics")+" m", where, args)}
+	page, err := paginate(q, map[string]string{"id": "id", "label": "label"}, "", args)
+	if err != nil {
+		reader.err = err
+		return reader
+	}
+	var stmt *sqlx.NamedStmt
+	stmt, reader.err = mc.db.prepareNamed(selectMetrics(mc.db.table("metrics"), mc.db.table("metric_scale"), where) + page)
+	if reader.err != nil {
+		return reader
+	}
+	reader.rows, reader.err = stmt.QueryxContext(ctx, args)
 	return reader
 }
 
 // Create implements the ResourceController interface
-func (mc *MetricController) Create(r types.Resource) (err error) {
+func (mc *MetricController) Create(ctx context.Context, r types.Resource) (err error) {
 	m, err := assertMetric(r)
 	if err != nil {
 		return
 	}
 	args := make(map[string]interface{})
 	q := "WITH " + mc.newMetricScale(m, args) + " " + selectMetrics("new_metric", "new_metric_scale", "")
-	stmt, err := mc.db.PrepareNamed(q)
+	stmt, err := mc.db.prepareNamed(q)
 	if err != nil {
 		return
 	}
-	err = stmt.Get(m, args)
+	err = stmt.GetContext(ctx, m, args)
 	return
 }
 
 func (mc *MetricController) newMetric(m *types.Metric, args map[string]interface{}) string {
-	args["newMetricLabel"] = m.Label
-	return ` new_metric AS ( INSERT INTO ` + mc.db.table("metrics") + `( label ) VALUES ( :newMetricLabel ) RETURNING * )`
+	args["newMetricLabel"], args["newMetricLabels"] = m.Label, m.Labels
+	return ` new_metric AS ( INSERT INTO ` + mc.db.table("metrics") + `( label, labels ) VALUES ( :newMetricLabel, :newMetricLabels ) RETURNING * )`
 }
 
 func (mc *MetricController) newMetricScale(m *types.Metric, args map[string]interface{}) (q string) {
@@ -54,50 +85,52 @@ func (mc *MetricController) newMetricScale(m *types.Metric, args map[string]inte
 		q += `, new_metric_scale AS ( SELECT * FROM ` + mc.db.table("metric_scale") + ` WHERE FALSE )`
 		return
 	}
-	v := ""
-	for i, id := range m.Scales {
-		sca := fmt.Sprintf("newMetricScaleScale%d", i)
-		args[sca] = id
-		v += fmt.Sprintf(",(:%s)", sca)
-	}
-	q += `, new_metric_scale AS ( INSERT INTO ` + mc.db.table("metric_scale") + ` ( metric, scale ) SELECT m.id, s.id::::bigint FROM new_metric m, ( VALUES ` + v[1:] + ` ) AS s (id) RETURNING * )`
+	args["newMetricScaleScales"] = idArray(m.Scales)
+	q += `, new_metric_scale AS ( INSERT INTO ` + mc.db.table("metric_scale") + ` ( metric, scale ) SELECT m.id, s.id FROM new_metric m, unnest(CAST(:newMetricScaleScales AS bigint[])) AS s ( id ) RETURNING * )`
 	return
 }
 
 // Read implements the ResourceController interface
-func (mc *MetricController) Read(id types.Id) (r types.Resource, err error) {
-	stmt, err := mc.db.Preparex(selectMetrics(mc.db.table("metrics"), mc.db.table("metric_scale"), "WHERE m.id = $1"))
+func (mc *MetricController) Read(ctx context.Context, id types.Id) (r types.Resource, err error) {
+	stmt, err := mc.db.preparex(selectMetrics(mc.db.table("metrics"), mc.db.table("metric_scale"), "WHERE m.id = $1"))
 	if err != nil {
 		return
 	}
 	m := new(types.Metric)
-	err = stmt.Get(m, id)
-	if err != nil {
-		return
+	err = stmt.GetContext(ctx, m, id)
+	if err == nil {
+		r = m
+	} else if err == sql.ErrNoRows {
+		err = types.NewHttpError(http.StatusNotFound, fmt.Errorf("No metric with id %d", id))
 	}
-	r = m
 	return
 }
 
 // Update implements the ResourceController interface
-func (mc *MetricController) Update(r types.Resource) (err error) {
+func (mc *MetricController) Update(ctx context.Context, r types.Resource) (err error) {
 	m, err := assertMetric(r)
 	if err != nil {
 		return
 	}
 	args := make(map[string]interface{})
 	q := "WITH " + mc.updatedMetricScale(m, args) + " " + selectMetrics("updated_metric", "updated_metric_scale", "")
-	stmt, err := mc.db.PrepareNamed(q)
-	if err != nil {
-		return
-	}
-	err = stmt.Get(m, args)
+	err = mc.db.performWithTransaction(ctx, func(tx *sqlx.Tx) (err error) {
+		err = mc.db.revise(ctx, tx, "metric", mc.db.table("metrics"), m.Id)
+		if err != nil {
+			return
+		}
+		stmt, err := tx.PrepareNamedContext(ctx, q)
+		if err != nil {
+			return
+		}
+		return stmt.GetContext(ctx, m, args)
+	})
 	return
 }
 
 func (mc *MetricController) updatedMetric(m *types.Metric, args map[string]interface{}) string {
-	args["updatedMetricId"], args["updatedMetricLabel"] = m.Id, m.Label
-	return ` updated_metric AS ( UPDATE ` + mc.db.table("metrics") + ` SET label = :updatedMetricLabel WHERE id = :updatedMetricId RETURNING * )`
+	args["updatedMetricId"], args["updatedMetricLabel"], args["updatedMetricLabels"] = m.Id, m.Label, m.Labels
+	return ` updated_metric AS ( UPDATE ` + mc.db.table("metrics") + ` SET label = :updatedMetricLabel, labels = :updatedMetricLabels WHERE id = :updatedMetricId RETURNING * )`
 }
 
 func (mc *MetricController) updatedMetricScale(m *types.Metric, args map[string]interface{}) (q string) {
@@ -106,35 +139,32 @@ func (mc *MetricController) updatedMetricScale(m *types.Metric, args map[string]
 		q += `, updated_metric_scale AS ( SELECT * FROM ` + mc.db.table("metric_scale") + ` WHERE FALSE )`
 		return
 	}
-	v := ""
-	for i, id := range m.Scales {
-		sca := fmt.Sprintf("updatedMetricScaleScale%d", i)
-		args[sca] = id
-		v += fmt.Sprintf(",(:%s)", sca)
-	}
-	q += `, updated_metric_scale AS ( INSERT INTO ` + mc.db.table("metric_scale") + ` ( metric, scale ) SELECT m.id, s.id::::bigint FROM updated_metric m, ( VALUES ` + v[1:] + ` ) AS s (id) RETURNING * )`
+	args["updatedMetricScaleScales"] = idArray(m.Scales)
+	q += `, updated_metric_scale AS ( INSERT INTO ` + mc.db.table("metric_scale") + ` ( metric, scale ) SELECT m.id, s.id FROM updated_metric m, unnest(CAST(:updatedMetricScaleScales AS bigint[])) AS s ( id ) RETURNING * )`
 	return
 }
 
 // Delete implements the ResourceController interface
-func (mc *MetricController) Delete(id types.Id) (err error) {
-	res, err := mc.db.Exec("DELETE FROM "+mc.db.table("metrics")+" WHERE id = $1", id)
-	if err != nil {
-		return
-	}
-	n, err := res.RowsAffected()
-	if err != nil {
-		return
-	}
-	if n != 1 {
-		err = types.NewHttpError(http.StatusNotFound, fmt.Errorf("No metric found with id %d", id))
-	}
-	return
+func (mc *MetricController) Delete(ctx context.Context, id types.Id, cascade bool) (err error) {
+	return mc.db.deleteResource(ctx, "metric", mc.db.table("metrics"), mc.impactQuery(), id, cascade, nil)
+}
+
+// Impact implements the ResourceController interface
+func (mc *MetricController) Impact(ctx context.Context, id types.Id) (*types.Impact, error) {
+	return mc.db.resourceImpact(ctx, "metric", mc.impactQuery(), id)
+}
+
+// impactQuery returns the query for the rows affected by deleting a metric. Nodes using the metric prevent the deletion.
+func (mc *MetricController) impactQuery() string {
+	return `SELECT 'deleted' AS effect, 'metrics' AS kind, row_to_json(m) AS row FROM ` + mc.db.table("metrics") + ` m WHERE m.id = $1` +
+		` UNION ALL SELECT 'deleted', 'metric_scale', row_to_json(ms) FROM ` + mc.db.table("metric_scale") + ` ms WHERE ms.metric = $1` +
+		` UNION ALL SELECT 'blocking', 'node_metric', row_to_json(nm) FROM ` + mc.db.table("node_metric") + ` nm WHERE nm.metric = $1`
 }
 
 type MetricReader struct {
-	err  error
-	rows *sqlx.Rows
+	err   error
+	rows  *sqlx.Rows
+	count counter
 }
 
 // Read implements the types.DocumentReader interface
@@ -160,9 +190,20 @@ func (mr *MetricReader) Read(r types.Resource) (ok bool, err error) {
 
 // Close implements the types.DocumentReader interface
 func (mr *MetricReader) Close() error {
+	if mr.rows == nil {
+		return mr.err
+	}
 	return mr.rows.Close()
 }
 
+// Total implements the types.CountingReader interface
+func (mr *MetricReader) Total() (int, error) {
+	if mr.err != nil {
+		return 0, mr.err
+	}
+	return mr.count.total()
+}
+
 func assertMetric(r types.Resource) (m *types.Metric, err error) {
 	switch r := r.(type) {
 	case *types.Metric:
@@ -174,5 +215,5 @@ func assertMetric(r types.Resource) (m *types.Metric, err error) {
 }
 
 func selectMetrics(metrics, metricScale, where string) string {
-	return "SELECT m.id, m.label, json_agg(ms.scale) AS scales FROM " + metrics + " m LEFT JOIN " + metricScale + " ms ON m.id = ms.metric " + where + " GROUP BY m.id, m.label"
+	return "SELECT m.id, m.label, m.labels, m.revision, json_agg(ms.scale) AS scales FROM " + metrics + " m LEFT JOIN " + metricScale + " ms ON m.id = ms.metric " + where + " GROUP BY m.id, m.label, m.labels, m.revision"
 }
@@ -1 +1,58 @@
 package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/janvogt/gotambora/coding/types"
+	"reflect"
+	"testing"
+)
+
+func TestQueryMetrics(t *testing.T) {
+	col := []string{"id", "label", "labels", "scales"}
+	tests := []struct {
+		q    map[string][]string
+		sql  string
+		args []driver.Value
+	}{
+		{map[string][]string{}, `SELECT m.id, m.label, m.labels, m.revision, json_agg\(ms.scale\) AS scales FROM prefix_metrics m LEFT JOIN prefix_metric_scale ms ON m.id = ms.metric +GROUP BY m.id, m.label, m.labels, m.revision`, []driver.Value{}},
+		{map[string][]string{"id": []string{"1", "2"}, "label": []string{"metric"}}, `ms.metric WHERE m.id = ANY\(\$1\) +AND m.label = ANY\(\$2\) +GROUP BY`, []driver.Value{`{"1","2"}`, `{"metric"}`}},
+		{map[string][]string{"node": []string{"5"}, "scale": []string{"3"}}, `ms.metric WHERE m.id IN \( SELECT metric FROM prefix_node_metric WHERE node = ANY\(\$1\) +\) +AND m.id IN \( SELECT metric FROM prefix_metric_scale WHERE scale = ANY\(\$2\) +\) +GROUP BY`, []driver.Value{`{"5"}`, `{"3"}`}},
+	}
+	for i, test := range tests {
+		db := newTestDB(t, "prefix")
+		sqlmock.ExpectPrepare()
+		sqlmock.ExpectQuery(test.sql).WithArgs(test.args...).WillReturnRows(sqlmock.NewRows(col).AddRow(1, "metric", "{}", `[3,4]`))
+		c := &MetricController{db}
+		reader := c.Query(context.Background(), test.q)
+		m := new(types.Metric)
+		ok, err := reader.Read(m)
+		expected := &types.Metric{1, "metric", types.RelationToMany{3, 4}, types.Labels{}, 0, ""}
+		if !ok || err != nil {
+			t.Errorf("Testcase %d: Expected to read metric, but got ok = %t and err = %v", i, ok, err)
+		} else if !reflect.DeepEqual(m, expected) {
+			t.Errorf("Testcase %d: Unexpected result:\n%+v\nexpected:\n%+v\n", i, m, expected)
+		}
+		if ok, err = reader.Read(m); ok || err != nil {
+			t.Errorf("Testcase %d: Expected end of metrics, but got ok = %t and err = %v", i, ok, err)
+		}
+		if err = db.Close(); err != nil {
+			t.Errorf("Testcase %d: Unexpected database interaction: %s", i, err)
+		}
+	}
+}
+
+func TestReadUnknownMetric(t *testing.T) {
+	db := newTestDB(t, "prefix")
+	sqlmock.ExpectPrepare()
+	sqlmock.ExpectQuery(`SELECT m.id, .* WHERE m.id = \$1`).WithArgs(9).WillReturnRows(sqlmock.NewRows([]string{"id", "label", "labels", "scales"}))
+	c := &MetricController{db}
+	m, err := c.Read(context.Background(), types.Id(9))
+	if herr, ok := err.(types.HttpError); !ok || herr.Status() != 404 || m != nil {
+		t.Errorf("Expected not found error for unknown metric, but got metric %+v and err %s", m, err)
+	}
+	if err = db.Close(); err != nil {
+		t.Errorf("Unexpected database interaction: %s \n", err)
+	}
+}
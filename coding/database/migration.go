@@ -0,0 +1,212 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// migration changes the schema from the previous version to its version. Its SQL templates are formatted using DB.format. Reverting the first migration keeps the Postgres schema of a DB in schema mode.
+type migration struct {
+	version     uint64
+	description string
+	up          string // up changes the schema of the previous version to this version.
+	down        string // down reverts up. Migrations without down can't be reverted.
+}
+
+// migrations are all changes to the schema in ascending order of their version. The first creates the baseline schema of version 1, reverting it drops everything. Migrations must not be changed once released as existing schemas have been created by them, every change to the schema needs a new migration.
+var migrations = []migration{
+	{1, "Create the baseline schema.", createSchemaSQLTemplate, dropSchemaSQLTemplate},
+	{2, "Let nodes inherit the metrics of their parent.", addInheritSQLTemplate, dropInheritSQLTemplate},
+	{3, "Order the children of nodes by their index.", addIndexSQLTemplate, dropIndexSQLTemplate},
+	{4, "Type the links between nodes.", addLinkTypesSQLTemplate, dropLinkTypesSQLTemplate},
+	{5, "Label nodes, scales, values and metrics in several languages.", addLabelsSQLTemplate, dropLabelsSQLTemplate},
+	{6, "Search the labels of nodes ignoring accents and case.", searchFunctions, dropSearchFunctionsSQLTemplate},
+	{7, "Redirect from nodes merged into other nodes.", addRedirectsSQLTemplate, dropRedirectsSQLTemplate},
+	{8, "Count the revisions of nodes, scales, metrics and events.", addRevisionsSQLTemplate, dropRevisionsSQLTemplate},
+}
+
+// SchemaVersion is the version of the schema needed by this package.
+var SchemaVersion = migrations[len(migrations)-1].version
+
+// Migrate migrates the schema to the given version in a single transaction. Migrating to an older version reverts the migrations in between if possible, migrating to version 0 drops everything.
+func (db *DB) Migrate(ctx context.Context, version uint64) error {
+	v, err := db.Version()
+	if err != nil {
+		return err
+	}
+	return db.migrate(ctx, v, version)
+}
+
+// MigrationSQL returns the SQL Migrate would run to migrate the schema to the given version without changing anything. There is one batch of statements per migration, each starting with a comment describing it.
+func (db *DB) MigrationSQL(version uint64) (batches []string, err error) {
+	v, err := db.Version()
+	if err != nil {
+		return
+	}
+	return db.migrationSQL(v, version)
+}
+
+// migrate migrates the schema from version from to version to in a single transaction. Nothing is done if both are equal.
+func (db *DB) migrate(ctx context.Context, from, to uint64) error {
+	batches, err := db.migrationSQL(from, to)
+	if err != nil || len(batches) == 0 {
+		return err
+	}
+	return db.performWithTransaction(ctx, func(tx *sqlx.Tx) (err error) {
+		for _, batch := range batches {
+			_, err = tx.ExecContext(ctx, batch)
+			if err != nil {
+				return
+			}
+		}
+		return
+	})
+}
+
+// migrationSQL returns the batches of statements migrating the schema from version from to version to. Each batch sets the version reached by it.
+func (db *DB) migrationSQL(from, to uint64) (batches []string, err error) {
+	for _, v := range []uint64{from, to} {
+		if v > SchemaVersion {
+			return nil, fmt.Errorf("Unknown version %d of the schema for %s, the latest version is %d.", v, db.namespace(), SchemaVersion)
+		}
+	}
+	for _, m := range migrations {
+		if from < m.version && m.version <= to {
+			batch := fmt.Sprintf("-- Migrate %s to version %d: %s\n", db.namespace(), m.version, m.description)
+			if m.version == 1 && db.schema != "" {
+				batch += "CREATE SCHEMA IF NOT EXISTS " + pq.QuoteIdentifier(db.schema) + ";\n"
+			}
+			batches = append(batches, batch+db.format(m.up)+fmt.Sprintf(versionSQLTemplate, db.qualifier(), m.version))
+		}
+	}
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if to < m.version && m.version <= from {
+			if m.down == "" {
+				return nil, fmt.Errorf("Migration of %s to version %d can't be reverted.", db.namespace(), m.version)
+			}
+			batch := fmt.Sprintf("-- Revert %s to version %d: %s\n", db.namespace(), m.version-1, m.description) + db.format(m.down)
+			if m.version > 1 {
+				batch += fmt.Sprintf(versionSQLTemplate, db.qualifier(), m.version-1)
+			}
+			batches = append(batches, batch)
+		}
+	}
+	return
+}
+
+// versionSQLTemplate sets the version of the schema returned by the function %[1]sversion() to %[2]d.
+const versionSQLTemplate = `
+CREATE OR REPLACE FUNCTION %[1]sversion() RETURNS bigint
+  AS 'SELECT CAST(%[2]d AS bigint);'
+  LANGUAGE SQL
+  IMMUTABLE;
+`
+
+const addInheritSQLTemplate = `
+ALTER TABLE %[1]snodes ADD COLUMN inherit boolean NOT NULL DEFAULT TRUE;
+`
+
+const dropInheritSQLTemplate = `
+ALTER TABLE %[1]snodes DROP COLUMN inherit;
+`
+
+// addIndexSQLTemplate numbers the existing children of each node in the order of their ids.
+const addIndexSQLTemplate = `
+ALTER TABLE %[1]snodes ADD COLUMN "index" int NOT NULL DEFAULT 0;
+UPDATE %[1]snodes n SET "index" = o.position FROM ( SELECT id, row_number() OVER ( PARTITION BY parent ORDER BY id ) - 1 AS position FROM %[1]snodes ) o WHERE n.id = o.id;
+`
+
+const dropIndexSQLTemplate = `
+ALTER TABLE %[1]snodes DROP COLUMN "index";
+`
+
+// addLinkTypesSQLTemplate creates the vocabulary of link types. Existing links get the default type.
+const addLinkTypesSQLTemplate = `
+CREATE TABLE %[1]slink_types (
+  name  text PRIMARY KEY,
+  label ` + labelFieldType + `
+);
+INSERT INTO %[1]slink_types (name, label) VALUES
+  ('` + defaultLinkType + `', 'related to'),
+  ('synonym', 'synonym of'),
+  ('seeAlso', 'see also'),
+  ('replacedBy', 'replaced by'),
+  ('causes', 'causes');
+ALTER TABLE %[1]slinks ADD COLUMN type text NOT NULL DEFAULT '` + defaultLinkType + `' REFERENCES %[1]slink_types(name) ON UPDATE CASCADE ON DELETE RESTRICT;
+`
+
+const dropLinkTypesSQLTemplate = `
+ALTER TABLE %[1]slinks DROP COLUMN type;
+DROP TABLE %[1]slink_types;
+`
+
+const addLabelsSQLTemplate = `
+ALTER TABLE %[1]snodes ADD COLUMN labels ` + labelsFieldType + `;
+ALTER TABLE %[1]sscales ADD COLUMN labels ` + labelsFieldType + `;
+ALTER TABLE %[1]svalues ADD COLUMN labels ` + labelsFieldType + `;
+ALTER TABLE %[1]smetrics ADD COLUMN labels ` + labelsFieldType + `;
+ALTER TYPE %[1]sscale_value ADD ATTRIBUTE labels jsonb;
+`
+
+const dropLabelsSQLTemplate = `
+ALTER TYPE %[1]sscale_value DROP ATTRIBUTE labels;
+ALTER TABLE %[1]snodes DROP COLUMN labels;
+ALTER TABLE %[1]sscales DROP COLUMN labels;
+ALTER TABLE %[1]svalues DROP COLUMN labels;
+ALTER TABLE %[1]smetrics DROP COLUMN labels;
+`
+
+// searchFunctions normalizes search terms and the labels of nodes for accent and case insensitive search. The trigram index speeds up the LIKE and word similarity conditions the search uses for every word.
+const searchFunctions = `
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE EXTENSION IF NOT EXISTS unaccent;
+
+CREATE FUNCTION %[1]ssearch_term(term text) RETURNS text
+  AS $$ SELECT lower(unaccent('unaccent'::regdictionary, $1)) $$
+  LANGUAGE SQL
+  IMMUTABLE;
+
+CREATE FUNCTION %[1]ssearch_text(label text, labels jsonb) RETURNS text
+  AS $$ SELECT %[1]ssearch_term($1 || COALESCE(( SELECT string_agg(' ' || value, '') FROM jsonb_each_text($2) ), '')) $$
+  LANGUAGE SQL
+  IMMUTABLE;
+
+CREATE INDEX %[2]snodes_search ON %[1]snodes USING gin ( %[1]ssearch_text(label, labels) gin_trgm_ops );
+`
+
+const dropSearchFunctionsSQLTemplate = `
+DROP INDEX %[1]snodes_search;
+DROP FUNCTION %[1]ssearch_text(text, jsonb);
+DROP FUNCTION %[1]ssearch_term(text);
+`
+
+const addRedirectsSQLTemplate = `
+CREATE TABLE %[1]snode_redirects (
+  id     ` + idFieldType + ` PRIMARY KEY,
+  target ` + idFieldType + ` NOT NULL REFERENCES %[1]snodes(id) ON DELETE CASCADE
+);
+`
+
+const dropRedirectsSQLTemplate = `
+DROP TABLE %[1]snode_redirects;
+`
+
+// revisionFieldType counts the changes of a resource. It is incremented with every change.
+const revisionFieldType = `bigint NOT NULL DEFAULT 1`
+
+const addRevisionsSQLTemplate = `
+ALTER TABLE %[1]snodes ADD COLUMN revision ` + revisionFieldType + `;
+ALTER TABLE %[1]sscales ADD COLUMN revision ` + revisionFieldType + `;
+ALTER TABLE %[1]smetrics ADD COLUMN revision ` + revisionFieldType + `;
+ALTER TABLE %[1]sevents ADD COLUMN revision ` + revisionFieldType + `;
+`
+
+const dropRevisionsSQLTemplate = `
+ALTER TABLE %[1]snodes DROP COLUMN revision;
+ALTER TABLE %[1]sscales DROP COLUMN revision;
+ALTER TABLE %[1]smetrics DROP COLUMN revision;
+ALTER TABLE %[1]sevents DROP COLUMN revision;
+`
@@ -0,0 +1,75 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"github.com/DATA-DOG/go-sqlmock"
+	"strings"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	db := newTestDB(t, "coding")
+	defer closeDb(t, db.DB)
+	sqlmockExpectVersion("coding", SchemaVersion-1)
+	sqlmock.ExpectBegin()
+	sqlmock.ExpectExec(fmt.Sprintf("-- Migrate coding to version %[1]d: .*?ALTER TABLE coding_nodes ADD COLUMN revision bigint NOT NULL DEFAULT 1;.*?'SELECT CAST\\(%[1]d AS bigint\\);.*", SchemaVersion)).WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlmock.ExpectCommit()
+	if err := db.Migrate(context.Background(), SchemaVersion); err != nil {
+		t.Errorf("Migrate() should migrate version %d to %d, but got %s", SchemaVersion-1, SchemaVersion, err)
+	}
+	sqlmockExpectVersion("coding", 1)
+	sqlmock.ExpectBegin()
+	sqlmock.ExpectExec(migrationRegex("coding", 2)).WillReturnError(ErrTest)
+	sqlmock.ExpectRollback()
+	if err := db.Migrate(context.Background(), SchemaVersion); err != ErrTest {
+		t.Errorf("Migrate() should return error of failing migration, but got %v", err)
+	}
+	sqlmockExpectVersion("coding", SchemaVersion)
+	if err := db.Migrate(context.Background(), SchemaVersion); err != nil {
+		t.Errorf("Migrate() should do nothing if the schema is up to date, but got %s", err)
+	}
+	sqlmockExpectVersion("coding", SchemaVersion)
+	sqlmock.ExpectBegin()
+	sqlmock.ExpectExec(fmt.Sprintf("-- Revert coding to version %[1]d: .*?ALTER TABLE coding_events DROP COLUMN revision; CREATE OR REPLACE FUNCTION coding_version\\(\\) RETURNS bigint AS 'SELECT CAST\\(%[1]d AS bigint\\);' LANGUAGE SQL IMMUTABLE;", SchemaVersion-1)).WillReturnResult(sqlmock.NewResult(0, 0))
+	for v := SchemaVersion - 2; v > 0; v-- {
+		sqlmock.ExpectExec(fmt.Sprintf("-- Revert coding to version %[1]d: .*'SELECT CAST\\(%[1]d AS bigint\\);' LANGUAGE SQL IMMUTABLE;", v)).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	sqlmock.ExpectExec("-- Revert coding to version 0: .*?DROP TABLE IF EXISTS coding_nodes;.*").WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlmock.ExpectCommit()
+	if err := db.Migrate(context.Background(), 0); err != nil {
+		t.Errorf("Migrate() should revert all migrations, but got %s", err)
+	}
+}
+
+func TestMigrationSQL(t *testing.T) {
+	db := newTestDB(t, "coding")
+	defer closeDb(t, db.DB)
+	sqlmockExpectVersion("coding", 0)
+	batches, err := db.MigrationSQL(SchemaVersion)
+	if err != nil || len(batches) != len(migrations) {
+		t.Fatalf("MigrationSQL() should return one batch per migration, but got %d batches and err %v", len(batches), err)
+	}
+	for i, batch := range batches {
+		if prefix := fmt.Sprintf("-- Migrate coding to version %d: ", i+1); !strings.HasPrefix(batch, prefix) {
+			t.Errorf("Batch %d should start with %q, but got %q", i, prefix, batch[:len(prefix)])
+		}
+	}
+	schemaDB := &DB{DB: db.DB, schema: "coding"}
+	batches, err = schemaDB.migrationSQL(0, 1)
+	if err != nil || len(batches) != 1 || !strings.HasPrefix(batches[0], "-- Migrate coding to version 1: Create the baseline schema.\nCREATE SCHEMA IF NOT EXISTS \"coding\";\n") {
+		t.Errorf("migrationSQL() should create the schema first, but got %q and err %v", batches, err)
+	}
+	sqlmockExpectVersion("coding", SchemaVersion+1)
+	if _, err = db.MigrationSQL(SchemaVersion); err == nil {
+		t.Errorf("MigrationSQL() should fail for unknown versions")
+	}
+	if _, err = db.migrationSQL(0, SchemaVersion+1); err == nil {
+		t.Errorf("migrationSQL() should fail for unknown versions")
+	}
+}
+
+// migrationRegex matches the batch of statements migrating the schema to version.
+func migrationRegex(prefix string, version uint64) string {
+	return fmt.Sprintf("-- Migrate %[1]s to version %[2]d: .*? CREATE OR REPLACE FUNCTION %[1]s_version\\(\\) RETURNS bigint AS 'SELECT CAST\\(%[2]d AS bigint\\);' LANGUAGE SQL IMMUTABLE;", prefix, version)
+}
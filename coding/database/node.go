@@ -1,15 +1,19 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"github.com/janvogt/gotambora/coding/types"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"net/http"
+	"sort"
+	"strings"
 )
 
-func (db *DB) NodeController() types.ResourceController {
+func (db *DB) NodeController() types.ContextNodeController {
 	return &NodeController{db}
 }
 
@@ -23,128 +27,261 @@ func (n *NodeController) New() (r types.Resource) {
 }
 
 // Query satisfies the types.Controller interface
-func (nc *NodeController) Query(q map[string][]string) types.ResourceReader {
+func (nc *NodeController) Query(ctx context.Context, q map[string][]string) types.ResourceReader {
 	args := make(map[string]interface{})
-	where := "WHERE "
+	conditions := make([]string, 0, 3)
+	if len(q["id"]) != 0 {
+		conditions = append(conditions, "n.id "+anyParameter("id", q["id"], args))
+	}
 	if len(q["label"]) != 0 {
-		where += "n.label IN " + inParameter("label", q["label"], args)
-		if len(q["parent"]) != 0 {
-			where += "AND "
-		}
+		conditions = append(conditions, "n.label "+anyParameter("label", q["label"], args))
 	}
 	if len(q["parent"]) != 0 {
-		where += "n.parent IN " + inParameter("parent", q["parent"], args)
-	} else if len(q["label"]) == 0 {
-		where += "n.parent is NULL "
+		conditions = append(conditions, "n.parent "+anyParameter("parent", q["parent"], args))
+	}
+	search, rank := nc.search(strings.Join(q["q"], " "), args)
+	if search != "" {
+		conditions = append(conditions, search)
+	}
+	if len(conditions) == 0 {
+		conditions = append(conditions, "n.parent is NULL ")
+	}
+	where := "WHERE " + strings.Join(conditions, "AND ")
+	qSql := selectNode(nc.db, nc.db.table("nodes"), nc.db.table("nodes"), nc.db.table("links"), nc.db.table("node_metric"), where)
+	if flag(q, "path") || rank != "" {
+		qSql = `SELECT s.*, ` + selectPath(nc.db, "s.parent") + ` AS path FROM ( ` + qSql + ` ) s`
 	}
-	qSql := selectNode(nc.db.table("nodes"), nc.db.table("nodes"), nc.db.table("links"), nc.db.table("node_metric"), where)
-	res := new(NodeReader)
+	if rank != "" {
+		rank += ` DESC, s.label, s.id`
+	}
+	res := &NodeReader{count: count(ctx, nc.db, nc.db.table("nodes")+" n", where, args)}
+	page, err := paginate(q, map[string]string{"id": "id", "label": "label", "parent": "parent"}, rank, args)
+	if err != nil {
+		res.err = err
+		return res
+	}
+	qSql += page
 	var stmt *sqlx.NamedStmt
-	stmt, res.err = nc.db.PrepareNamed(qSql)
+	stmt, res.err = nc.db.prepareNamed(qSql)
 	if res.err != nil {
 		return res
 	}
-	res.rows, res.err = stmt.Queryx(args)
+	res.rows, res.err = stmt.QueryxContext(ctx, args)
 	return res
 }
 
-// Create satisfies the types.Controller interface
-func (nc *NodeController) Create(r types.Resource) (err error) {
-	n, err := assertNode(r)
-	if err != nil {
+// maxSearchWords bounds the number of words searched for, as the SQL of the search differs by the number of words. Further words are ignored.
+const maxSearchWords = 8
+
+// search returns the condition for nodes matching all words of the search term in any language and the expression ranking the matching nodes s. Both are empty if there are no words to search for. A word matches if it is contained in a label ignoring case and accents or if it is similar to a word of a label. Every word gets a condition of its own, so the trigram index on the labels applies. Matches at the beginning of a label rank highest followed by matches at the beginning of any word of a label.
+func (nc *NodeController) search(term string, args map[string]interface{}) (condition, rank string) {
+	words := strings.Fields(term)
+	if len(words) == 0 {
 		return
 	}
-	args := make(map[string]interface{})
-	q := "WITH " + nc.newNode(n, args) + "," + nc.newLinks(n, args) + "," + nc.newNodeMetric(n, args) + " " + selectNode("new_node", nc.db.table("nodes"), "new_links", "new_node_metric", "")
-	stmt, err := nc.db.PrepareNamed(q)
+	if len(words) > maxSearchWords {
+		words = words[:maxSearchWords]
+	}
+	likes := make([]string, len(words))
+	for i, word := range words {
+		likes[i] = likeEscaper.Replace(word)
+	}
+	args["search"], args["searchLike"] = pq.Array(words), pq.Array(likes)
+	text := nc.db.table("search_text") + "(n.label, n.labels)"
+	conditions := make([]string, len(words))
+	for i := range words {
+		match, like := fmt.Sprintf("%s((CAST(:search AS text[]))[%d])", nc.db.table("search_term"), i+1), fmt.Sprintf("%s((CAST(:searchLike AS text[]))[%d])", nc.db.table("search_term"), i+1)
+		conditions[i] = text + " LIKE '%' || " + like + " || '%' OR " + text + " %> " + match
+	}
+	condition = "( " + strings.Join(conditions, " ) AND ( ") + " ) "
+	match, like := nc.db.table("search_term")+"(w.word)", nc.db.table("search_term")+"(w.pattern)"
+	text = nc.db.table("search_text") + "(s.label, s.labels)"
+	rank = "( SELECT sum( CASE WHEN " + text + " LIKE " + like + " || '%' THEN 1 WHEN " + text + " LIKE '% ' || " + like + " || '%' THEN 0.5 ELSE 0 END + word_similarity(" + match + ", " + text + ") ) FROM unnest(CAST(:search AS text[]), CAST(:searchLike AS text[])) AS w ( word, pattern ) )"
+	return
+}
+
+// likeEscaper escapes the wildcards of LIKE patterns.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// Create satisfies the types.Controller interface. The revision of the parent is incremented as its children change.
+func (nc *NodeController) Create(ctx context.Context, r types.Resource) (err error) {
+	n, err := assertNode(r)
 	if err != nil {
 		return
 	}
-	err = stmt.Get(n, args)
-	return
+	args := make(map[string]interface{})
+	q := "WITH " + nc.newNode(n, args) + "," + nc.newLinks(n, args) + "," + nc.newNodeMetric(n, args) + " " + selectNode(nc.db, "new_node", nc.db.table("nodes"), "new_links", "new_node_metric", "")
+	return nc.db.performWithTransaction(ctx, func(tx *sqlx.Tx) (err error) {
+		stmt, err := tx.PrepareNamedContext(ctx, q)
+		if err != nil {
+			return
+		}
+		err = stmt.GetContext(ctx, n, args)
+		if err != nil || !n.Parent.Valid {
+			return
+		}
+		return nc.db.touch(ctx, tx, nc.db.table("nodes"), validIds(n.Parent))
+	})
 }
 
 func (nc *NodeController) newNode(n *types.Node, args map[string]interface{}) string {
-	args["newNodeLabel"], args["newNodeParent"] = n.Label, n.Parent
-	return ` new_node AS ( INSERT INTO ` + nc.db.table("nodes") + ` ( label, parent ) VALUES ( :newNodeLabel, :newNodeParent ) RETURNING * )`
+	args["newNodeLabel"], args["newNodeLabels"], args["newNodeParent"], args["newNodeInherit"] = n.Label, n.Labels, n.Parent, n.InheritMetrics
+	return ` new_node AS ( INSERT INTO ` + nc.db.table("nodes") + ` ( label, labels, parent, inherit, "index" ) VALUES ( :newNodeLabel, :newNodeLabels, :newNodeParent, :newNodeInherit, ` + nextIndex(nc.db, ":newNodeParent") + ` ) RETURNING * )`
 }
 
 func (nc *NodeController) newLinks(n *types.Node, args map[string]interface{}) string {
-	if n.References == nil || len(n.References) == 0 {
+	refs := typedReferences(n)
+	if len(refs) == 0 {
 		return ` new_links AS ( SELECT * FROM ` + nc.db.table("links") + ` WHERE FALSE )`
 	}
-	v := ""
-	for i, id := range n.References {
-		to := fmt.Sprintf("newLinksId%d", i)
-		args[to] = id
-		v += fmt.Sprintf(",(:%s)", to)
+	args["newLinksIds"], args["newLinksTypes"] = referenceArrays(refs)
+	return ` new_links AS ( INSERT INTO ` + nc.db.table("links") + ` ("from", "to", type) SELECT n.id, t.id, COALESCE(t.type, '` + defaultLinkType + `') FROM new_node n, unnest(CAST(:newLinksIds AS bigint[]), CAST(:newLinksTypes AS text[])) AS t ( id, type ) RETURNING * )`
+}
+
+// referenceArrays returns the ids and the types of the references as array parameters. Missing types are NULL.
+func referenceArrays(refs []typedReference) (ids pq.Int64Array, typs interface{}) {
+	ids, names := make(pq.Int64Array, len(refs)), make([]sql.NullString, len(refs))
+	for i, ref := range refs {
+		ids[i] = int64(ref.to)
+		names[i].String, names[i].Valid = ref.typ.(string)
 	}
-	return ` new_links AS ( INSERT INTO ` + nc.db.table("links") + ` ("from", "to") SELECT n.id, t.id::::bigint FROM new_node n, ( VALUES ` + v[1:] + ` ) AS t ( id ) RETURNING * )`
+	return ids, pq.Array(names)
+}
+
+// typedReference is a reference to another node with the type of the link. If typ is nil, the type is not given.
+type typedReference struct {
+	to  types.Id
+	typ interface{}
+}
+
+// typedReferences pairs all references of n with their type. Typed references come first ordered by type and references not contained in any group of n.TypedReferences come last without type. n.References is authoritative for the default type: ids grouped by the default type only but missing in n.References are not referenced.
+func typedReferences(n *types.Node) (refs []typedReference) {
+	names := make([]string, 0, len(n.TypedReferences))
+	for name := range n.TypedReferences {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	typed := make(map[types.Id]bool)
+	for _, name := range names {
+		for _, id := range n.TypedReferences[name] {
+			if name == defaultLinkType && !containsId(n.References, id) {
+				continue
+			}
+			if !typed[id] {
+				refs = append(refs, typedReference{id, name})
+				typed[id] = true
+			}
+		}
+	}
+	for _, id := range n.References {
+		if !typed[id] {
+			refs = append(refs, typedReference{id, nil})
+			typed[id] = true
+		}
+	}
+	return
 }
 
 func (nc *NodeController) newNodeMetric(n *types.Node, args map[string]interface{}) string {
 	if n.Metrics == nil || len(n.Metrics) == 0 {
 		return ` new_node_metric AS ( SELECT * FROM ` + nc.db.table("node_metric") + ` WHERE FALSE )`
 	}
-	v := ""
-	for i, id := range n.Metrics {
-		met := fmt.Sprintf("newNodeMetric%d", i)
-		args[met] = id
-		v += fmt.Sprintf(",(:%s)", met)
-	}
-	return ` new_node_metric AS ( INSERT INTO ` + nc.db.table("node_metric") + ` (node, metric) SELECT n.id, m.id::::bigint FROM new_node n, ( VALUES ` + v[1:] + ` ) AS m ( id ) RETURNING * )`
+	args["newNodeMetrics"] = idArray(n.Metrics)
+	return ` new_node_metric AS ( INSERT INTO ` + nc.db.table("node_metric") + ` (node, metric) SELECT n.id, m.id FROM new_node n, unnest(CAST(:newNodeMetrics AS bigint[])) AS m ( id ) RETURNING * )`
 }
 
 // Read satisfies the types.Controller interface
-func (nc *NodeController) Read(id types.Id) (r types.Resource, err error) {
-	stmt, err := nc.db.Preparex(selectNode(nc.db.table("nodes"), nc.db.table("nodes"), nc.db.table("links"), nc.db.table("node_metric"), "WHERE n.id = $1"))
+func (nc *NodeController) Read(ctx context.Context, id types.Id) (r types.Resource, err error) {
+	stmt, err := nc.db.preparex(selectNode(nc.db, nc.db.table("nodes"), nc.db.table("nodes"), nc.db.table("links"), nc.db.table("node_metric"), "WHERE n.id = $1"))
 	if err != nil {
 		return
 	}
 	n := new(types.Node)
-	err = stmt.Get(n, id)
+	err = stmt.GetContext(ctx, n, id)
 	if err == nil {
 		r = n
 	} else if err == sql.ErrNoRows {
+		err = nc.redirect(ctx, id)
+	}
+	return
+}
+
+// redirect returns a RedirectError if the node with the given id has been merged into another node and a not found error otherwise.
+func (nc *NodeController) redirect(ctx context.Context, id types.Id) (err error) {
+	var target types.Id
+	err = nc.db.GetContext(ctx, &target, "SELECT target FROM "+nc.db.table("node_redirects")+" WHERE id = $1", id)
+	switch err {
+	case nil:
+		err = types.RedirectError{Id: id, Target: target}
+	case sql.ErrNoRows:
 		err = types.NewHttpError(http.StatusNotFound, fmt.Errorf("No node with id %d", id))
 	}
 	return
 }
 
-// Update satisfies the types.Controller interface
-func (nc *NodeController) Update(r types.Resource) (err error) {
+// Update satisfies the types.Controller interface. The revisions of the old and the new parent are incremented if the node moves, those of its heirs if its effective metrics may change.
+func (nc *NodeController) Update(ctx context.Context, r types.Resource) (err error) {
 	n, err := assertNode(r)
 	if err != nil {
 		return
 	}
 	args := make(map[string]interface{})
-	q := "WITH" + nc.updatedNode(n, args) + "," + nc.updatedLinks(n, args) + "," + nc.updatedNodeMetric(n, args) + " " + selectNode("updated_node", nc.db.table("nodes"), "updated_links", "updated_node_metric", "")
-	stmt, err := nc.db.PrepareNamed(q)
-	if err != nil {
+	q := "WITH" + nc.updatedNode(n, args) + "," + nc.updatedLinks(n, args) + "," + nc.updatedNodeMetric(n, args) + " " + selectNode(nc.db, "updated_node", nc.db.table("nodes"), "updated_links", "updated_node_metric", "")
+	err = nc.db.performWithTransaction(ctx, func(tx *sqlx.Tx) (err error) {
+		err = nc.db.revise(ctx, tx, "node", nc.db.table("nodes"), n.Id)
+		if err != nil {
+			return
+		}
+		err = nc.checkCycle(ctx, tx, n.Id, n.Parent)
+		if err != nil {
+			return
+		}
+		var old struct {
+			Parent  types.OptionalId
+			Inherit bool
+			Metrics types.RelationToMany
+		}
+		err = tx.GetContext(ctx, &old, `SELECT n.parent, n.inherit, COALESCE(( SELECT json_agg(m.metric) FROM `+nc.db.table("node_metric")+` m WHERE m.node = n.id ), '[]') AS metrics FROM `+nc.db.table("nodes")+` n WHERE n.id = $1`, n.Id)
+		if err != nil {
+			return
+		}
+		err = nc.reorder(ctx, tx, n.Id, n.Children)
+		if err != nil {
+			return
+		}
+		stmt, err := tx.PrepareNamedContext(ctx, q)
+		if err != nil {
+			return
+		}
+		err = stmt.GetContext(ctx, n, args)
+		if err == nil && old.Parent != n.Parent {
+			err = nc.db.touch(ctx, tx, nc.db.table("nodes"), validIds(old.Parent, n.Parent))
+		}
+		if err == nil && (old.Inherit != n.InheritMetrics || !sameIds(old.Metrics, n.Metrics) || old.Parent != n.Parent && n.InheritMetrics) {
+			err = nc.touchHeirs(ctx, tx, n.Id)
+		}
 		return
+	})
+	if err == sql.ErrNoRows {
+		err = types.NewHttpError(http.StatusNotFound, fmt.Errorf("No node with id %d", n.Id))
 	}
-	err = stmt.Get(n, args)
 	return
 }
 
 func (nc *NodeController) updatedNode(n *types.Node, args map[string]interface{}) string {
-	args["updatedNodeId"], args["updatedNodeLabel"], args["updatedNodeParent"] = n.Id, n.Label, n.Parent
-	return ` updated_node AS ( UPDATE ` + nc.db.table("nodes") + ` SET label = :updatedNodeLabel, parent = :updatedNodeParent WHERE id = :updatedNodeId RETURNING * )`
+	args["updatedNodeId"], args["updatedNodeLabel"], args["updatedNodeLabels"], args["updatedNodeParent"], args["updatedNodeInherit"] = n.Id, n.Label, n.Labels, n.Parent, n.InheritMetrics
+	return ` updated_node AS ( UPDATE ` + nc.db.table("nodes") + ` SET label = :updatedNodeLabel, labels = :updatedNodeLabels, parent = :updatedNodeParent, inherit = :updatedNodeInherit, "index" = CASE WHEN parent IS NOT DISTINCT FROM :updatedNodeParent THEN "index" ELSE ` + nextIndex(nc.db, ":updatedNodeParent") + ` END WHERE id = :updatedNodeId RETURNING * )`
 }
 
 func (nc *NodeController) updatedLinks(n *types.Node, args map[string]interface{}) (q string) {
 	q = ` deleted_links AS ( DELETE FROM ` + nc.db.table("links") + ` WHERE "from" = :updatedNodeId )`
-	if n.References == nil || len(n.References) == 0 {
+	refs := typedReferences(n)
+	if len(refs) == 0 {
 		q += `, updated_links AS ( SELECT * FROM ` + nc.db.table("links") + ` WHERE FALSE )`
 		return
 	}
-	v := ""
-	for i, id := range n.References {
-		to := fmt.Sprintf("updatedLinksId%d", i)
-		args[to] = id
-		v += fmt.Sprintf(",(:%s)", to)
-	}
-	q += `, updated_links AS ( INSERT INTO ` + nc.db.table("links") + ` ("from", "to") SELECT n.id, t.id::::bigint FROM updated_node n, ( VALUES ` + v[1:] + ` ) AS t ( id ) RETURNING * )`
+	args["updatedLinksIds"], args["updatedLinksTypes"] = referenceArrays(refs)
+	q += `, updated_links AS ( INSERT INTO ` + nc.db.table("links") + ` ("from", "to", type) SELECT n.id, t.id, COALESCE(t.type, o.type, '` + defaultLinkType + `') FROM updated_node n CROSS JOIN unnest(CAST(:updatedLinksIds AS bigint[]), CAST(:updatedLinksTypes AS text[])) AS t ( id, type ) LEFT JOIN ` + nc.db.table("links") + ` o ON o."from" = n.id AND o."to" = t.id RETURNING * )`
 	return
 }
 
@@ -154,36 +291,53 @@ func (nc *NodeController) updatedNodeMetric(n *types.Node, args map[string]inter
 		q += `, updated_node_metric AS ( SELECT * FROM ` + nc.db.table("node_metric") + ` WHERE FALSE )`
 		return
 	}
-	v := ""
-	for i, id := range n.Metrics {
-		met := fmt.Sprintf("updatedNodeMetric%d", i)
-		args[met] = id
-		v += fmt.Sprintf(",(:%s)", met)
-	}
-	q += `, updated_node_metric AS ( INSERT INTO ` + nc.db.table("node_metric") + ` (node, metric) SELECT n.id, m.id::::bigint FROM updated_node n, ( VALUES ` + v[1:] + ` ) AS m ( id ) RETURNING * )`
+	args["updatedNodeMetrics"] = idArray(n.Metrics)
+	q += `, updated_node_metric AS ( INSERT INTO ` + nc.db.table("node_metric") + ` (node, metric) SELECT n.id, m.id FROM updated_node n, unnest(CAST(:updatedNodeMetrics AS bigint[])) AS m ( id ) RETURNING * )`
 	return
 }
 
-// Delete satisfies the types.Controller interface
-func (nc *NodeController) Delete(id types.Id) (err error) {
-	stmt, err := nc.db.Preparex("DELETE FROM " + nc.db.table("nodes") + " WHERE id = $1")
-	if err != nil {
-		return
-	}
-	res, err := stmt.Exec(id)
-	if err != nil {
+// Delete satisfies the types.Controller interface. The revisions of the parent and of the nodes outside of the deleted subtree referencing it are incremented as their children and references change.
+func (nc *NodeController) Delete(ctx context.Context, id types.Id, cascade bool) (err error) {
+	return nc.db.deleteResource(ctx, "node", nc.db.table("nodes"), nc.impactQuery(), id, cascade, func(tx *sqlx.Tx) (err error) {
+		err = nc.touchParent(ctx, tx, id)
+		if err == nil {
+			err = nc.touchReferrers(ctx, tx, id)
+		}
 		return
-	}
-	rows, err := res.RowsAffected()
-	if err == nil && rows == 0 {
-		err = types.NewHttpError(http.StatusNotFound, fmt.Errorf("No node with id %d found!", id))
-	}
+	})
+}
+
+// touchReferrers increments the revisions of the nodes referencing the node with the given id or one of its descendants which are no descendants themselves.
+func (nc *NodeController) touchReferrers(ctx context.Context, tx *sqlx.Tx, id types.Id) (err error) {
+	_, err = tx.ExecContext(ctx, `WITH RECURSIVE `+subtree(nc.db, "$1", "")+` UPDATE `+nc.db.table("nodes")+` SET revision = revision + 1 WHERE id IN ( SELECT l."from" FROM `+nc.db.table("links")+` l WHERE l."to" IN ( SELECT id FROM tree ) ) AND id NOT IN ( SELECT id FROM tree )`, id)
+	return
+}
+
+// Impact satisfies the types.Controller interface
+func (nc *NodeController) Impact(ctx context.Context, id types.Id) (*types.Impact, error) {
+	return nc.db.resourceImpact(ctx, "node", nc.impactQuery(), id)
+}
+
+// impactQuery returns the query for the rows affected by deleting a node. The descendants of the node are deleted with it as well as all links to and from them and their metrics. Events of the type of the node or one of its descendants prevent the deletion.
+func (nc *NodeController) impactQuery() string {
+	return `WITH RECURSIVE ` + subtree(nc.db, "$1", "") + ` SELECT CASE WHEN t.depth = 0 THEN 'deleted' ELSE 'cascaded' END AS effect, 'nodes' AS kind, row_to_json(n) AS row FROM tree t JOIN ` + nc.db.table("nodes") + ` n ON n.id = t.id` +
+		` UNION ALL SELECT CASE WHEN l."from" = $1 THEN 'deleted' ELSE 'cascaded' END, 'links', row_to_json(l) FROM ` + nc.db.table("links") + ` l WHERE l."from" IN ( SELECT id FROM tree ) OR l."to" IN ( SELECT id FROM tree )` +
+		` UNION ALL SELECT CASE WHEN m.node = $1 THEN 'deleted' ELSE 'cascaded' END, 'node_metric', row_to_json(m) FROM ` + nc.db.table("node_metric") + ` m WHERE m.node IN ( SELECT id FROM tree )` +
+		` UNION ALL SELECT CASE WHEN r.target = $1 THEN 'deleted' ELSE 'cascaded' END, 'node_redirects', row_to_json(r) FROM ` + nc.db.table("node_redirects") + ` r WHERE r.target IN ( SELECT id FROM tree )` +
+		` UNION ALL SELECT 'blocking', 'events', row_to_json(e) FROM ` + nc.db.table("events") + ` e WHERE e.type IN ( SELECT id FROM tree )`
+}
+
+// LinkTypes satisfies the types.ContextNodeController interface
+func (nc *NodeController) LinkTypes(ctx context.Context) (linkTypes []types.LinkType, err error) {
+	linkTypes = make([]types.LinkType, 0)
+	err = nc.db.SelectContext(ctx, &linkTypes, "SELECT name, label FROM "+nc.db.table("link_types")+" ORDER BY name")
 	return
 }
 
 type NodeReader struct {
-	err  error
-	rows *sqlx.Rows
+	err   error
+	rows  *sqlx.Rows
+	count counter
 }
 
 // Read implements the types.DocumentReader interface
@@ -209,9 +363,20 @@ func (n *NodeReader) Read(r types.Resource) (ok bool, err error) {
 
 // Close implements the types.DocumentReader interface
 func (n *NodeReader) Close() error {
+	if n.rows == nil {
+		return n.err
+	}
 	return n.rows.Close()
 }
 
+// Total implements the types.CountingReader interface
+func (n *NodeReader) Total() (int, error) {
+	if n.err != nil {
+		return 0, n.err
+	}
+	return n.count.total()
+}
+
 func assertNode(r types.Resource) (n *types.Node, err error) {
 	switch node := r.(type) {
 	case *types.Node:
@@ -222,6 +387,19 @@ func assertNode(r types.Resource) (n *types.Node, err error) {
 	return
 }
 
-func selectNode(nodesTable, childrenTable, linksTable, metricsTable, where string) string {
-	return `SELECT n.id, n.label, n.parent, json_agg(DISTINCT c.id) AS children, json_agg(DISTINCT l.to) AS references, json_agg(DISTINCT m.metric) AS metrics FROM ` + nodesTable + ` n LEFT JOIN ` + childrenTable + ` c ON n.id = c.parent LEFT JOIN ` + linksTable + ` l ON n.id = l.from LEFT JOIN ` + metricsTable + ` m ON n.id = m.node ` + where + ` GROUP BY n.id, n.label, n.parent`
+// selectNode selects the nodes with their links. References with a type other than the default type are additionally grouped by the type of their link. The effective metrics are the node's own metrics from metricsTable and those inherited from its ancestors.
+func selectNode(db *DB, nodesTable, childrenTable, linksTable, metricsTable, where string) string {
+	effective := `COALESCE(( ` + inheritingNodes(db, "id = n.parent AND n.inherit") + ` SELECT json_agg(DISTINCT e.metric) FROM ( SELECT metric FROM ` + metricsTable + ` WHERE node = n.id UNION SELECT nm.metric FROM inheriting i JOIN ` + db.table("node_metric") + ` nm ON nm.node = i.id ) e ), '[]') AS effective_metrics`
+	typed := `COALESCE(( SELECT json_object_agg(g.type, g.ids) FROM ( SELECT t.type, json_agg(t.to ORDER BY t.to) AS ids FROM ` + linksTable + ` t WHERE t.from = n.id AND t.type <> '` + defaultLinkType + `' GROUP BY t.type ) g ), '{}') AS typed_references`
+	return `SELECT n.id, n.label, n.labels, n.parent, n.inherit, n.revision, ` + selectChildren(childrenTable) + `, json_agg(DISTINCT l.to) AS references, ` + typed + `, json_agg(DISTINCT m.metric) AS metrics, ` + effective + ` FROM ` + nodesTable + ` n LEFT JOIN ` + linksTable + ` l ON n.id = l.from LEFT JOIN ` + metricsTable + ` m ON n.id = m.node ` + where + ` GROUP BY n.id, n.label, n.labels, n.parent, n.inherit, n.revision`
+}
+
+// selectChildren returns a subquery for the json encoded children of node n from childrenTable ordered by their index.
+func selectChildren(childrenTable string) string {
+	return `COALESCE(( SELECT json_agg(c.id ORDER BY c."index", c.id) FROM ` + childrenTable + ` c WHERE c.parent = n.id ), '[]') AS children`
+}
+
+// inheritingNodes returns a recursive common table expression "inheriting" containing the nodes satisfying start and all their ancestors from which metrics are inherited. Nodes which do not inherit metrics end the recursion.
+func inheritingNodes(db *DB, start string) string {
+	return `WITH RECURSIVE inheriting ( id, parent, inherit ) AS ( SELECT id, parent, inherit FROM ` + db.table("nodes") + ` WHERE ` + start + ` UNION SELECT p.id, p.parent, p.inherit FROM ` + db.table("nodes") + ` p JOIN inheriting i ON p.id = i.parent WHERE i.inherit )`
 }
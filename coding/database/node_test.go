@@ -1 +1,175 @@
 package database
+
+import (
+	"context"
+	"fmt"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/janvogt/gotambora/coding/types"
+	"reflect"
+	"testing"
+)
+
+func TestQueryNodesSearch(t *testing.T) {
+	db := newTestDB(t, "prefix")
+	match := `\( prefix_search_text\(n.label, n.labels\) LIKE '%%' \|\| prefix_search_term\(\(CAST\(\$\d+ AS text\[\]\)\)\[%d\]\) \|\| '%%' OR prefix_search_text\(n.label, n.labels\) %%> prefix_search_term\(\(CAST\(\$\d+ AS text\[\]\)\)\[%d\]\) \)`
+	rank := `ORDER BY \( SELECT sum\( CASE WHEN prefix_search_text\(s.label, s.labels\) LIKE prefix_search_term\(w.pattern\) \|\| '%' THEN 1 .* FROM unnest\(CAST\(\$\d+ AS text\[\]\), CAST\(\$\d+ AS text\[\]\)\) AS w \( word, pattern \) \) DESC, s.label, s.id`
+	q := `SELECT s.\*, .* AS path FROM \( SELECT n.id, .* WHERE ` + fmt.Sprintf(match, 1, 1) + ` AND ` + fmt.Sprintf(match, 2, 2) + ` +GROUP BY .* \) s ` + rank
+	col := []string{"id", "label", "labels", "parent", "inherit", "children", "references", "typed_references", "metrics", "effective_metrics", "path"}
+	words, likes := `{"hail","st_rm"}`, `{"hail","st\\_rm"}`
+	sqlmock.ExpectPrepare()
+	sqlmock.ExpectQuery(q).WithArgs(likes, words, likes, words, words, likes).WillReturnRows(sqlmock.NewRows(col).AddRow(3, "Hailstorm", `{"de":"Hagelsturm"}`, 2, true, `[null]`, `[null]`, `{}`, `[null]`, `[]`, `[{"id":1,"label":"Weather","depth":2},{"id":2,"label":"Storm","depth":1}]`))
+	c := &NodeController{db}
+	reader := c.Query(context.Background(), map[string][]string{"q": []string{" hail  st_rm "}})
+	n := new(types.Node)
+	ok, err := reader.Read(n)
+	expected := &types.Node{3, "Hailstorm", types.OptionalId{2, true}, types.RelationToMany{}, types.RelationToMany{}, types.RelationToMany{}, true, types.RelationToMany{}, types.Path{types.PathElement{1, "Weather", nil, ""}, types.PathElement{2, "Storm", nil, ""}}, types.TypedRelations{}, types.Labels{"de": "Hagelsturm"}, 0, ""}
+	if !ok || err != nil {
+		t.Errorf("Expected to read node, but got ok = %t and err = %v", ok, err)
+	} else if !reflect.DeepEqual(n, expected) {
+		t.Errorf("Unexpected result:\n%+v\nexpected:\n%+v\n", n, expected)
+	}
+	if ok, err = reader.Read(n); ok || err != nil {
+		t.Errorf("Expected end of nodes, but got ok = %t and err = %v", ok, err)
+	}
+	reader.Close()
+	sqlmock.ExpectPrepare()
+	sqlmock.ExpectQuery(`WHERE `+fmt.Sprintf(match, 1, 1)+` +GROUP BY .* \) s `+rank).WithArgs(`{"hail"}`, `{"hail"}`, `{"hail"}`, `{"hail"}`).WillReturnRows(sqlmock.NewRows(col))
+	reader = c.Query(context.Background(), map[string][]string{"q": []string{"hail"}})
+	if ok, err = reader.Read(n); ok || err != nil {
+		t.Errorf("Expected no nodes, but got ok = %t and err = %v", ok, err)
+	}
+	reader.Close()
+	if len(db.stmts.named) != 2 {
+		t.Errorf("Expected the search to be prepared once for every number of words, but got %d statements", len(db.stmts.named))
+	}
+	if err = db.Close(); err != nil {
+		t.Errorf("Unexpected database interaction: %s", err)
+	}
+}
+
+func TestQueryNodesPage(t *testing.T) {
+	db := newTestDB(t, "prefix")
+	col := []string{"id", "label", "labels", "parent", "inherit", "children", "references", "typed_references", "metrics", "effective_metrics"}
+	sqlmock.ExpectPrepare()
+	sqlmock.ExpectQuery(`SELECT n.id, .* FROM prefix_nodes n .* WHERE n.parent = ANY\(\$1\) +GROUP BY n.id, n.label, n.labels, n.parent, n.inherit, n.revision ORDER BY label DESC, id LIMIT \$2 OFFSET \$3$`).WithArgs(`{"1"}`, 2, 4).WillReturnRows(sqlmock.NewRows(col).AddRow(3, "Storm", "{}", 1, true, `[]`, `[null]`, `{}`, `[null]`, `[]`))
+	sqlmock.ExpectPrepare()
+	sqlmock.ExpectQuery(`^SELECT count\(\*\) FROM prefix_nodes n WHERE n.parent = ANY\(\$1\)$`).WithArgs(`{"1"}`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(5))
+	c := &NodeController{db}
+	reader := c.Query(context.Background(), map[string][]string{"parent": []string{"1"}, "sort": []string{"-label"}, "limit": []string{"2"}, "offset": []string{"4"}})
+	n := new(types.Node)
+	ok, err := reader.Read(n)
+	if !ok || err != nil {
+		t.Errorf("Expected to read node, but got ok = %t and err = %v", ok, err)
+	} else if n.Id != 3 {
+		t.Errorf("Unexpected node %+v", n)
+	}
+	total, err := reader.(types.CountingReader).Total()
+	if err != nil || total != 5 {
+		t.Errorf("Expected a total of 5, but got %d and err = %v", total, err)
+	}
+	if err = db.Close(); err != nil {
+		t.Errorf("Unexpected database interaction: %s", err)
+	}
+}
+
+func TestQueryNodesInvalidSort(t *testing.T) {
+	db := newTestDB(t, "prefix")
+	c := &NodeController{db}
+	reader := c.Query(context.Background(), map[string][]string{"sort": []string{"effectiveMetrics"}})
+	if _, err := reader.(types.CountingReader).Total(); err == nil {
+		t.Errorf("Expected error for invalid sort")
+	}
+	if ok, err := reader.Read(new(types.Node)); ok || err == nil {
+		t.Errorf("Expected error for invalid sort, but got ok = %t and err = %v", ok, err)
+	}
+	if err := db.Close(); err != nil {
+		t.Errorf("Unexpected database interaction: %s", err)
+	}
+}
+
+func TestUpdateNodeRemovesReference(t *testing.T) {
+	db := newTestDB(t, "prefix")
+	col := []string{"id", "label", "labels", "parent", "inherit", "revision", "children", "references", "typed_references", "metrics", "effective_metrics"}
+	sqlmock.ExpectBegin()
+	sqlmockExpectRevise("prefix_nodes", 2, 1)
+	sqlmockExpectOldNode(2, nil, true, `[]`)
+	sqlmock.ExpectPrepare()
+	sqlmock.ExpectQuery(`WITH updated_node AS .* updated_links AS \( INSERT INTO prefix_links .* t WHERE t.from = n.id AND t.type <> 'related' GROUP BY t.type`).WithArgs("Frost", "{}", nil, true, nil, nil, 2, 2, `{5}`, `{"related"}`, 2).WillReturnRows(sqlmock.NewRows(col).AddRow(2, "Frost", "{}", nil, true, 2, `[]`, `[5]`, `{}`, `[null]`, `[]`))
+	sqlmock.ExpectCommit()
+	c := &NodeController{db}
+	n := &types.Node{Id: 2, Label: "Frost", InheritMetrics: true, References: types.RelationToMany{5}, TypedReferences: types.TypedRelations{"related": {5, 6}}}
+	if err := c.Update(context.Background(), n); err != nil {
+		t.Errorf("Unexcpected Error: %s\n", err)
+	} else if !reflect.DeepEqual(n.References, types.RelationToMany{5}) || len(n.TypedReferences) != 0 {
+		t.Errorf("Expected the removed reference to stay removed, but got %+v", n)
+	}
+	if err := db.Close(); err != nil {
+		t.Errorf("Unexpected database interaction: %s", err)
+	}
+}
+
+func sqlmockExpectOldNode(id types.Id, parent interface{}, inherit bool, metrics string) {
+	sqlmock.ExpectQuery(`SELECT n.parent, n.inherit, COALESCE\(\( SELECT json_agg\(m.metric\) FROM prefix_node_metric m WHERE m.node = n.id \), '\[\]'\) AS metrics FROM prefix_nodes n WHERE n.id = \$1`).WithArgs(id).WillReturnRows(sqlmock.NewRows([]string{"parent", "inherit", "metrics"}).AddRow(parent, inherit, metrics))
+}
+
+func TestCreateNodeTouchesParent(t *testing.T) {
+	db := newTestDB(t, "prefix")
+	col := []string{"id", "label", "labels", "parent", "inherit", "revision", "children", "references", "typed_references", "metrics", "effective_metrics"}
+	sqlmock.ExpectBegin()
+	sqlmock.ExpectPrepare()
+	sqlmock.ExpectQuery(`WITH new_node AS \( INSERT INTO prefix_nodes .*`).WithArgs("Frost", "{}", 1, true, 1).WillReturnRows(sqlmock.NewRows(col).AddRow(2, "Frost", "{}", 1, true, 0, `[]`, `[null]`, `{}`, `[null]`, `[3]`))
+	sqlmock.ExpectExec(`UPDATE prefix_nodes SET revision = revision \+ 1 WHERE id = ANY\(\$1\)`).WithArgs("{1}").WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlmock.ExpectCommit()
+	c := &NodeController{db}
+	n := &types.Node{Label: "Frost", Parent: types.OptionalId{Id: 1, Valid: true}, InheritMetrics: true}
+	if err := c.Create(context.Background(), n); err != nil {
+		t.Errorf("Unexcpected Error: %s\n", err)
+	} else if n.Id != 2 || !reflect.DeepEqual(n.EffectiveMetrics, types.RelationToMany{3}) {
+		t.Errorf("Unexpected result: %+v", n)
+	}
+	if err := db.Close(); err != nil {
+		t.Errorf("Unexpected database interaction: %s", err)
+	}
+}
+
+func TestUpdateNodeTouchesParentsAndHeirs(t *testing.T) {
+	db := newTestDB(t, "prefix")
+	col := []string{"id", "label", "labels", "parent", "inherit", "revision", "children", "references", "typed_references", "metrics", "effective_metrics"}
+	tests := []struct {
+		parent   interface{}
+		inherit  bool
+		metrics  string
+		inherits bool
+		touched  string
+		heirs    bool
+	}{
+		{1, true, `[3]`, true, `{1,4}`, true},
+		{4, false, `[3]`, true, "", true},
+		{4, true, `[3]`, true, "", false},
+		{4, true, `[]`, true, "", true},
+		{1, false, `[3]`, false, `{1,4}`, false},
+	}
+	c := &NodeController{db}
+	for i, test := range tests {
+		sqlmock.ExpectBegin()
+		sqlmockExpectRevise("prefix_nodes", 2, 1)
+		sqlmock.ExpectQuery(`WITH RECURSIVE ancestry \(.*\) SELECT EXISTS \( SELECT 1 FROM ancestry WHERE id = \$2 \)`).WithArgs(4, 2).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+		sqlmockExpectOldNode(2, test.parent, test.inherit, test.metrics)
+		sqlmock.ExpectPrepare()
+		sqlmock.ExpectQuery(`WITH updated_node AS \( UPDATE prefix_nodes .*`).WillReturnRows(sqlmock.NewRows(col).AddRow(2, "Frost", "{}", 4, test.inherits, 2, `[]`, `[null]`, `{}`, `[3]`, `[3]`))
+		if test.touched != "" {
+			sqlmock.ExpectExec(`UPDATE prefix_nodes SET revision = revision \+ 1 WHERE id = ANY\(\$1\)`).WithArgs(test.touched).WillReturnResult(sqlmock.NewResult(0, 2))
+		}
+		if test.heirs {
+			sqlmockExpectTouchHeirs("{2}")
+		}
+		sqlmock.ExpectCommit()
+		n := &types.Node{Id: 2, Label: "Frost", Parent: types.OptionalId{Id: 4, Valid: true}, Metrics: types.RelationToMany{3}, InheritMetrics: test.inherits}
+		if err := c.Update(context.Background(), n); err != nil {
+			t.Errorf("Testcase %d: Unexcpected Error: %s\n", i, err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Errorf("Unexpected database interaction: %s", err)
+	}
+}
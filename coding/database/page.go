@@ -0,0 +1,73 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"github.com/janvogt/gotambora/coding/types"
+	"net/http"
+	"strings"
+)
+
+// paginate returns the ORDER BY, LIMIT and OFFSET clauses for the page requested by q. Only the fields in columns can be sorted by, they are mapped to the column to order by. The resources are ordered by id last to keep the pages stable. Without a requested sort they are ordered by def or by id if def is empty. The clause is empty if neither a sort, a page nor def are given.
+func paginate(q map[string][]string, columns map[string]string, def string, args map[string]interface{}) (clause string, err error) {
+	p, err := types.NewPage(q)
+	if err != nil {
+		return
+	}
+	order, byId := make([]string, 0, len(p.Sort)+1), false
+	for _, field := range p.Sort {
+		dir := ""
+		if strings.HasPrefix(field, "-") {
+			field, dir = field[1:], " DESC"
+		}
+		column, ok := columns[field]
+		if !ok {
+			err = types.NewHttpError(http.StatusBadRequest, fmt.Errorf("Can't sort by %s.", field))
+			return
+		}
+		byId = byId || column == "id"
+		order = append(order, column+dir)
+	}
+	switch {
+	case len(order) != 0 && !byId:
+		order = append(order, "id")
+	case len(order) == 0 && def != "":
+		order = append(order, def)
+	case len(order) == 0 && p.Limited():
+		order = append(order, "id")
+	case len(order) == 0:
+		return
+	}
+	clause = " ORDER BY " + strings.Join(order, ", ")
+	if p.Limit >= 0 {
+		args["pageLimit"] = p.Limit
+		clause += " LIMIT :pageLimit"
+	}
+	if p.Offset > 0 {
+		args["pageOffset"] = p.Offset
+		clause += " OFFSET :pageOffset"
+	}
+	return
+}
+
+// counter counts the resources satisfying a query.
+type counter struct {
+	ctx  context.Context
+	db   *DB
+	q    string
+	args map[string]interface{}
+}
+
+// count returns a counter for the rows of table t satisfying where. The rows are counted within ctx.
+func count(ctx context.Context, db *DB, table, where string, args map[string]interface{}) counter {
+	return counter{ctx, db, "SELECT count(*) FROM " + table + " " + where, args}
+}
+
+func (c counter) total() (total int, err error) {
+	stmt, err := c.db.prepareNamed(c.q)
+	if err != nil {
+		return
+	}
+	err = stmt.GetContext(c.ctx, &total, c.args)
+	return
+}
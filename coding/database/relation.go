@@ -0,0 +1,197 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"github.com/janvogt/gotambora/coding/types"
+	"github.com/jmoiron/sqlx"
+	"net/http"
+	"strings"
+)
+
+// relation is a to-many link of a resource stored as pairs of ids in a table. If typ is not nil only pairs of that type are linked.
+type relation struct {
+	table   string      // table is the table of the pairs.
+	owner   string      // owner is the column of the id of the resource owning the link.
+	related string      // related is the column of the related ids.
+	typ     interface{} // typ is the value of the column "type" of the pairs, if any.
+}
+
+// add links the resource with the given id to related. The type of pairs existing already is changed to the type of the relation.
+func (r relation) add(ctx context.Context, tx *sqlx.Tx, id types.Id, related types.RelationToMany) (err error) {
+	if len(related) == 0 {
+		return
+	}
+	args := map[string]interface{}{"relationOwner": id, "relationType": r.typ}
+	args["related"] = idArray(related)
+	cols, vals := r.owner+", "+r.related, ":relationOwner, n.id"
+	if r.typ != nil {
+		stmt, err := tx.PrepareNamedContext(ctx, `UPDATE `+r.table+` SET type = :relationType WHERE `+r.owner+` = :relationOwner AND `+r.related+` = ANY(:related)`)
+		if err != nil {
+			return err
+		}
+		if _, err = stmt.ExecContext(ctx, args); err != nil {
+			return err
+		}
+		cols, vals = cols+", type", vals+", :relationType"
+	}
+	stmt, err := tx.PrepareNamedContext(ctx, `INSERT INTO `+r.table+` ( `+cols+` ) SELECT `+vals+` FROM ( SELECT DISTINCT v.id FROM unnest(CAST(:related AS bigint[])) AS v ( id ) ) n WHERE NOT EXISTS ( SELECT 1 FROM `+r.table+` e WHERE e.`+r.owner+` = :relationOwner AND e.`+r.related+` = n.id )`)
+	if err != nil {
+		return
+	}
+	_, err = stmt.ExecContext(ctx, args)
+	return
+}
+
+// remove unlinks the resource with the given id from related.
+func (r relation) remove(ctx context.Context, tx *sqlx.Tx, id types.Id, related types.RelationToMany) (err error) {
+	if len(related) == 0 {
+		return
+	}
+	args := map[string]interface{}{"relationOwner": id, "relationType": r.typ}
+	q := `DELETE FROM ` + r.table + ` WHERE ` + r.owner + ` = :relationOwner AND ` + r.related + ` ` + anyParameter("related", related, args)
+	if r.typ != nil {
+		q += `AND type = :relationType`
+	}
+	stmt, err := tx.PrepareNamedContext(ctx, q)
+	if err != nil {
+		return
+	}
+	_, err = stmt.ExecContext(ctx, args)
+	return
+}
+
+// changeRelated revises the resource with the given id in table and changes its links within the same transaction. It is not found if it does not exist.
+func (db *DB) changeRelated(ctx context.Context, name, table string, id types.Id, change func(tx *sqlx.Tx) error) error {
+	return db.performWithTransaction(ctx, func(tx *sqlx.Tx) (err error) {
+		err = db.revise(ctx, tx, name, table, id)
+		if err != nil {
+			return
+		}
+		return change(tx)
+	})
+}
+
+func unknownLink(name, link string) error {
+	return types.NewHttpError(http.StatusNotFound, fmt.Errorf("A %s has no link %s which can be changed.", name, link))
+}
+
+// AddRelated satisfies the types.RelationController interface. Children are moved below the node after its other children. Changing the metrics increments the revisions of the heirs of the node.
+func (nc *NodeController) AddRelated(ctx context.Context, id types.Id, link string, related types.RelationToMany) (r types.Resource, err error) {
+	err = nc.db.changeRelated(ctx, "node", nc.db.table("nodes"), id, func(tx *sqlx.Tx) error {
+		if link == "children" {
+			return nc.adopt(ctx, tx, id, related)
+		}
+		rel, err := nc.relation(link)
+		if err == nil {
+			err = rel.add(ctx, tx, id, related)
+		}
+		if err == nil && link == "metrics" {
+			err = nc.touchHeirs(ctx, tx, id)
+		}
+		return err
+	})
+	if err == nil {
+		r, err = nc.Read(ctx, id)
+	}
+	return
+}
+
+// RemoveRelated satisfies the types.RelationController interface. Removed children become roots. Changing the metrics increments the revisions of the heirs of the node.
+func (nc *NodeController) RemoveRelated(ctx context.Context, id types.Id, link string, related types.RelationToMany) (r types.Resource, err error) {
+	err = nc.db.changeRelated(ctx, "node", nc.db.table("nodes"), id, func(tx *sqlx.Tx) error {
+		if link == "children" {
+			return nc.orphan(ctx, tx, id, related)
+		}
+		rel, err := nc.relation(link)
+		if err == nil {
+			err = rel.remove(ctx, tx, id, related)
+		}
+		if err == nil && link == "metrics" {
+			err = nc.touchHeirs(ctx, tx, id)
+		}
+		return err
+	})
+	if err == nil {
+		r, err = nc.Read(ctx, id)
+	}
+	return
+}
+
+// relation returns the relation of the link of nodes with the given name. Untyped references are added with the default type and removed regardless of their type.
+func (nc *NodeController) relation(link string) (rel relation, err error) {
+	switch {
+	case link == "metrics":
+		rel = relation{nc.db.table("node_metric"), "node", "metric", nil}
+	case link == "references":
+		rel = relation{nc.db.table("links"), `"from"`, `"to"`, nil}
+	case strings.HasPrefix(link, "references:") && len(link) > len("references:"):
+		rel = relation{nc.db.table("links"), `"from"`, `"to"`, link[len("references:"):]}
+	default:
+		err = unknownLink("node", link)
+	}
+	return
+}
+
+// adopt moves the given nodes below the node with the given id in their given order. Children of the node stay where they are.
+func (nc *NodeController) adopt(ctx context.Context, tx *sqlx.Tx, id types.Id, children types.RelationToMany) (err error) {
+	current, err := nc.children(ctx, tx, id)
+	for _, child := range children {
+		if err != nil {
+			return
+		}
+		if !containsId(current, child) {
+			err = nc.move(ctx, tx, child, types.OptionalId{Id: id, Valid: true}, -1)
+			current = append(current, child)
+		}
+	}
+	return
+}
+
+// orphan makes the given children of the node with the given id roots. Other nodes are ignored.
+func (nc *NodeController) orphan(ctx context.Context, tx *sqlx.Tx, id types.Id, children types.RelationToMany) (err error) {
+	current, err := nc.children(ctx, tx, id)
+	for _, child := range children {
+		if err != nil {
+			return
+		}
+		if containsId(current, child) {
+			err = nc.move(ctx, tx, child, types.OptionalId{}, -1)
+		}
+	}
+	return
+}
+
+func (nc *NodeController) children(ctx context.Context, tx *sqlx.Tx, id types.Id) (children []types.Id, err error) {
+	children = make([]types.Id, 0)
+	err = tx.SelectContext(ctx, &children, `SELECT id FROM `+nc.db.table("nodes")+` WHERE parent = $1 ORDER BY "index", id`, id)
+	return
+}
+
+// AddRelated satisfies the types.RelationController interface
+func (mc *MetricController) AddRelated(ctx context.Context, id types.Id, link string, related types.RelationToMany) (r types.Resource, err error) {
+	err = mc.db.changeRelated(ctx, "metric", mc.db.table("metrics"), id, func(tx *sqlx.Tx) error {
+		if link != "scales" {
+			return unknownLink("metric", link)
+		}
+		return relation{mc.db.table("metric_scale"), "metric", "scale", nil}.add(ctx, tx, id, related)
+	})
+	if err == nil {
+		r, err = mc.Read(ctx, id)
+	}
+	return
+}
+
+// RemoveRelated satisfies the types.RelationController interface
+func (mc *MetricController) RemoveRelated(ctx context.Context, id types.Id, link string, related types.RelationToMany) (r types.Resource, err error) {
+	err = mc.db.changeRelated(ctx, "metric", mc.db.table("metrics"), id, func(tx *sqlx.Tx) error {
+		if link != "scales" {
+			return unknownLink("metric", link)
+		}
+		return relation{mc.db.table("metric_scale"), "metric", "scale", nil}.remove(ctx, tx, id, related)
+	})
+	if err == nil {
+		r, err = mc.Read(ctx, id)
+	}
+	return
+}
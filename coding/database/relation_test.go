@@ -0,0 +1,87 @@
+package database
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/janvogt/gotambora/coding/types"
+	"testing"
+)
+
+var relationNodeColumns = []string{"id", "label", "parent", "inherit", "children", "references", "typed_references", "metrics", "effective_metrics"}
+
+func TestAddRelatedReferences(t *testing.T) {
+	db := newTestDB(t, "prefix")
+	sqlmock.ExpectBegin()
+	sqlmockExpectRevise("prefix_nodes", 1, 1)
+	sqlmock.ExpectPrepare()
+	sqlmock.ExpectExec(`UPDATE prefix_links SET type = \$1 WHERE "from" = \$2 AND "to" = ANY\(\$3\)`).WithArgs("synonym", 1, "{5,6}").WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlmock.ExpectPrepare()
+	sqlmock.ExpectExec(`INSERT INTO prefix_links \( "from", "to", type \) SELECT \$1, n.id, \$2 FROM \( SELECT DISTINCT v.id FROM unnest\(CAST\(\$3 AS bigint\[\]\)\) AS v \( id \) \) n WHERE NOT EXISTS \( SELECT 1 FROM prefix_links e WHERE e."from" = \$4 AND e."to" = n.id \)`).WithArgs(1, "synonym", "{5,6}", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlmock.ExpectCommit()
+	sqlmock.ExpectPrepare()
+	sqlmock.ExpectQuery(`SELECT n.id, .* WHERE n.id = \$1`).WithArgs(1).WillReturnRows(sqlmock.NewRows(relationNodeColumns).AddRow(1, "root", nil, true, `[]`, `[5,6]`, `{"synonym":[5,6]}`, `[null]`, `[]`))
+	c := &NodeController{db}
+	r, err := c.AddRelated(context.Background(), types.Id(1), "references:synonym", types.RelationToMany{5, 6})
+	if err != nil {
+		t.Errorf("Unexcpected Error: %s\n", err)
+	} else if n := r.(*types.Node); len(n.TypedReferences["synonym"]) != 2 {
+		t.Errorf("Unexpected result: %+v", n)
+	}
+	if err = db.Close(); err != nil {
+		t.Errorf("Unexpected database interaction: %s \n", err)
+	}
+}
+
+func TestAddRelatedChildren(t *testing.T) {
+	db := newTestDB(t, "prefix")
+	sqlmock.ExpectBegin()
+	sqlmockExpectRevise("prefix_nodes", 1, 1)
+	sqlmock.ExpectQuery(`SELECT id FROM prefix_nodes WHERE parent = \$1 ORDER BY "index", id`).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	sqlmock.ExpectQuery(`WITH RECURSIVE ancestry \(.*\) SELECT EXISTS \( SELECT 1 FROM ancestry WHERE id = \$2 \)`).WithArgs(1, 5).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	sqlmock.ExpectQuery(`SELECT parent, inherit, "index" FROM prefix_nodes WHERE id = \$1 FOR UPDATE`).WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"parent", "inherit", "index"}).AddRow(2, false, 0))
+	sqlmock.ExpectExec(`UPDATE prefix_nodes SET parent = \$1, "index" = \( SELECT .* \), revision = revision \+ 1 WHERE id = \$2`).WithArgs(1, 5).WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlmock.ExpectExec(`UPDATE prefix_nodes SET revision = revision \+ 1 WHERE id = ANY\(\$1\)`).WithArgs("{2,1}").WillReturnResult(sqlmock.NewResult(0, 2))
+	sqlmock.ExpectExec(`UPDATE prefix_nodes n SET "index" = o.position .* WHERE parent IS NOT DISTINCT FROM \$1 \)`).WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlmock.ExpectExec(`UPDATE prefix_nodes n SET "index" = o.position .* WHERE parent IS NOT DISTINCT FROM \$1 \)`).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlmock.ExpectCommit()
+	sqlmock.ExpectPrepare()
+	sqlmock.ExpectQuery(`SELECT n.id, .* WHERE n.id = \$1`).WithArgs(1).WillReturnRows(sqlmock.NewRows(relationNodeColumns).AddRow(1, "root", nil, true, `[4,5]`, `[null]`, `{}`, `[null]`, `[]`))
+	c := &NodeController{db}
+	r, err := c.AddRelated(context.Background(), types.Id(1), "children", types.RelationToMany{4, 5})
+	if err != nil {
+		t.Errorf("Unexcpected Error: %s\n", err)
+	} else if n := r.(*types.Node); len(n.Children) != 2 {
+		t.Errorf("Unexpected result: %+v", n)
+	}
+	if err = db.Close(); err != nil {
+		t.Errorf("Unexpected database interaction: %s \n", err)
+	}
+}
+
+func TestRemoveRelated(t *testing.T) {
+	db := newTestDB(t, "prefix")
+	sqlmock.ExpectBegin()
+	sqlmockExpectRevise("prefix_metrics", 2, 1)
+	sqlmock.ExpectPrepare()
+	sqlmock.ExpectExec(`DELETE FROM prefix_metric_scale WHERE metric = \$1 AND scale = ANY\(\$2\)$`).WithArgs(2, "{3}").WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlmock.ExpectCommit()
+	sqlmock.ExpectPrepare()
+	sqlmock.ExpectQuery(`SELECT m.id, .* WHERE m.id = \$1`).WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"id", "label", "labels", "scales"}).AddRow(2, "metric", "{}", `[4]`))
+	c := &MetricController{db}
+	r, err := c.RemoveRelated(context.Background(), types.Id(2), "scales", types.RelationToMany{3})
+	if err != nil {
+		t.Errorf("Unexcpected Error: %s\n", err)
+	} else if m := r.(*types.Metric); len(m.Scales) != 1 || m.Scales[0] != 4 {
+		t.Errorf("Unexpected result: %+v", m)
+	}
+	sqlmock.ExpectBegin()
+	sqlmockExpectRevise("prefix_metrics", 2, 1)
+	sqlmock.ExpectRollback()
+	r, err = c.RemoveRelated(context.Background(), types.Id(2), "nodes", types.RelationToMany{3})
+	if herr, ok := err.(types.HttpError); !ok || herr.Status() != 404 || r != nil {
+		t.Errorf("Expected not found error for unknown link, but got %+v and err %s", r, err)
+	}
+	if err = db.Close(); err != nil {
+		t.Errorf("Unexpected database interaction: %s \n", err)
+	}
+}
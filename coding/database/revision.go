@@ -0,0 +1,65 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/janvogt/gotambora/coding/types"
+	"github.com/jmoiron/sqlx"
+	"net/http"
+)
+
+// revise increments the revision of the resource with the given id in table and locks it until the transaction ends. If ctx requires a revision, the resource has to be at that revision before. It is not found if it does not exist.
+func (db *DB) revise(ctx context.Context, tx *sqlx.Tx, name, table string, id types.Id) (err error) {
+	var revision int64
+	err = tx.GetContext(ctx, &revision, `UPDATE `+table+` SET revision = revision + 1 WHERE id = $1 RETURNING revision - 1`, id)
+	if err == sql.ErrNoRows {
+		return types.NewHttpError(http.StatusNotFound, fmt.Errorf("No %s with id %d", name, id))
+	} else if err != nil {
+		return
+	}
+	return checkRevision(ctx, name, id, revision)
+}
+
+// lockRevision locks the resource with the given id in table until the transaction ends, if ctx requires a revision, and checks that the resource is at that revision.
+func (db *DB) lockRevision(ctx context.Context, tx *sqlx.Tx, name, table string, id types.Id) (err error) {
+	if _, ok := types.RequiredRevision(ctx); !ok {
+		return
+	}
+	var revision int64
+	err = tx.GetContext(ctx, &revision, `SELECT revision FROM `+table+` WHERE id = $1 FOR UPDATE`, id)
+	if err == sql.ErrNoRows {
+		return types.NewHttpError(http.StatusNotFound, fmt.Errorf("No %s with id %d", name, id))
+	} else if err != nil {
+		return
+	}
+	return checkRevision(ctx, name, id, revision)
+}
+
+// checkRevision fails with status 412 Precondition Failed if ctx requires another revision than the current revision of the resource with the given id.
+func checkRevision(ctx context.Context, name string, id types.Id, revision int64) error {
+	if required, ok := types.RequiredRevision(ctx); ok && required != revision {
+		return types.NewHttpError(http.StatusPreconditionFailed, fmt.Errorf("The %s with id %d has been changed meanwhile. It is at revision %d, not %d.", name, id, revision, required))
+	}
+	return nil
+}
+
+// touch increments the revisions of the resources with the given ids in table without checking them.
+func (db *DB) touch(ctx context.Context, tx *sqlx.Tx, table string, ids []types.Id) (err error) {
+	if len(ids) == 0 {
+		return
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE `+table+` SET revision = revision + 1 WHERE id = ANY($1)`, idArray(ids))
+	return
+}
+
+// validIds returns the ids of the optional ids which are not null.
+func validIds(ids ...types.OptionalId) []types.Id {
+	valid := make([]types.Id, 0, len(ids))
+	for _, id := range ids {
+		if id.Valid {
+			valid = append(valid, id.Id)
+		}
+	}
+	return valid
+}
@@ -0,0 +1,56 @@
+package database
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/janvogt/gotambora/coding/types"
+	"testing"
+)
+
+func TestUpdateMetricRevision(t *testing.T) {
+	db := newTestDB(t, "prefix")
+	c := &MetricController{db}
+	sqlmock.ExpectBegin()
+	sqlmockExpectRevise("prefix_metrics", 2, 4)
+	sqlmock.ExpectRollback()
+	err := c.Update(types.RequireRevision(context.Background(), 3), &types.Metric{Id: 2, Label: "metric"})
+	if herr, ok := err.(types.HttpError); !ok || herr.Status() != 412 {
+		t.Errorf("Expected precondition failed for update of a changed metric, but got %s", err)
+	}
+	sqlmock.ExpectBegin()
+	sqlmock.ExpectQuery(`UPDATE prefix_metrics SET revision = revision \+ 1 WHERE id = \$1 RETURNING revision - 1`).WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"revision"}))
+	sqlmock.ExpectRollback()
+	err = c.Update(context.Background(), &types.Metric{Id: 2, Label: "metric"})
+	if herr, ok := err.(types.HttpError); !ok || herr.Status() != 404 {
+		t.Errorf("Expected not found for update of a missing metric, but got %s", err)
+	}
+	sqlmock.ExpectBegin()
+	sqlmockExpectRevise("prefix_metrics", 2, 3)
+	sqlmock.ExpectPrepare()
+	sqlmock.ExpectQuery(`WITH updated_metric AS \( UPDATE prefix_metrics SET label = \$1, labels = \$2 WHERE id = \$3 RETURNING \* \)`).WithArgs("metric", "{}", 2).WillReturnRows(sqlmock.NewRows([]string{"id", "label", "labels", "revision", "scales"}).AddRow(2, "metric", "{}", 4, `[null]`))
+	sqlmock.ExpectCommit()
+	m := &types.Metric{Id: 2, Label: "metric"}
+	if err = c.Update(types.RequireRevision(context.Background(), 3), m); err != nil {
+		t.Errorf("Unexcpected Error: %s\n", err)
+	} else if m.Revision != 4 {
+		t.Errorf("Expected the metric at revision 4, but got %d", m.Revision)
+	}
+	if err = db.Close(); err != nil {
+		t.Errorf("Unexpected database interaction: %s \n", err)
+	}
+}
+
+func TestDeleteRevision(t *testing.T) {
+	db := newTestDB(t, "prefix")
+	c := &ScaleController{db}
+	sqlmock.ExpectBegin()
+	sqlmock.ExpectQuery(`SELECT revision FROM prefix_scales WHERE id = \$1 FOR UPDATE`).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"revision"}).AddRow(2))
+	sqlmock.ExpectRollback()
+	err := c.Delete(types.RequireRevision(context.Background(), 1), types.Id(1), false)
+	if herr, ok := err.(types.HttpError); !ok || herr.Status() != 412 {
+		t.Errorf("Expected precondition failed for deletion of a changed scale, but got %s", err)
+	}
+	if err = db.Close(); err != nil {
+		t.Errorf("Unexpected database interaction: %s \n", err)
+	}
+}
@@ -1,17 +1,21 @@
 package database
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
 	"github.com/janvogt/gotambora/coding/types"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"net/http"
+	"strings"
 )
 
 type ScaleController struct {
 	db *DB
 }
 
-func (db *DB) ScaleController() types.ResourceController {
+func (db *DB) ScaleController() types.ContextResourceController {
 	return &ScaleController{db}
 }
 
@@ -20,15 +24,48 @@ func (s *ScaleController) New() (r types.Resource) {
 	return new(types.Scale)
 }
 
-// Query satisfies the types.Controller interface
-func (s *ScaleController) Query(q map[string][]string) types.ResourceReader {
-	reader := new(ScaleReader)
-	reader.rows, reader.err = s.db.Queryx(`SELECT s.id, s.label, s.type, json_agg((v.id, v.label)::` + s.db.prefix + `_scale_value ORDER BY v.index) AS values, COALESCE(u.unit, '') AS unit, u.min, u.max FROM ` + s.db.prefix + `_scales s LEFT JOIN ` + s.db.prefix + `_values v ON s.id = v.scale LEFT JOIN ` + s.db.prefix + `_units u ON s.id = u.scale GROUP BY s.id, s.label, s.type, u.unit, u.min, u.max`)
+// Query satisfies the types.Controller interface. The scales can be filtered by "id", "label", "type" and "metric" containing them.
+func (s *ScaleController) Query(ctx context.Context, q map[string][]string) types.ResourceReader {
+	args := make(map[string]interface{})
+	conditions := make([]string, 0, 4)
+	if len(q["id"]) != 0 {
+		conditions = append(conditions, "s.id "+anyParameter("id", q["id"], args))
+	}
+	if len(q["label"]) != 0 {
+		conditions = append(conditions, "s.label "+anyParameter("label", q["label"], args))
+	}
+	if len(q["type"]) != 0 {
+		conditions = append(conditions, "s.type "+anyParameter("type", q["type"], args))
+	}
+	if len(q["metric"]) != 0 {
+		conditions = append(conditions, "s.id IN ( SELECT scale FROM "+s.db.table("metric_scale")+" WHERE metric "+anyParameter("metric", q["metric"], args)+") ")
+	}
+	where := ""
+	if len(conditions) != 0 {
+		where = "WHERE " + strings.Join(conditions, "AND ")
+	}
+	reader := &ScaleReader{count: count(ctx, s.db, s.db.table("scales")+" s", where, args)}
+	page, err := paginate(q, map[string]string{"id": "id", "label": "label", "type": "type"}, "", args)
+	if err != nil {
+		reader.err = err
+		return reader
+	}
+	var stmt *sqlx.NamedStmt
+	stmt, reader.err = s.db.prepareNamed(s.selectScales(where) + page)
+	if reader.err != nil {
+		return reader
+	}
+	reader.rows, reader.err = stmt.QueryxContext(ctx, args)
 	return reader
 }
 
+// selectScales selects the scales satisfying where with their values and units.
+func (s *ScaleController) selectScales(where string) string {
+	return `SELECT s.id, s.label, s.labels, s.type, s.revision, json_agg(CAST((v.id, v.label, v.labels) AS ` + s.db.table("scale_value") + `) ORDER BY v.index) AS values, COALESCE(u.unit, '') AS unit, u.min, u.max FROM ` + s.db.table("scales") + ` s LEFT JOIN ` + s.db.table("values") + ` v ON s.id = v.scale LEFT JOIN ` + s.db.table("units") + ` u ON s.id = u.scale ` + where + `GROUP BY s.id, s.label, s.labels, s.type, s.revision, u.unit, u.min, u.max`
+}
+
 // Create satisfies the types.Controller interface
-func (s *ScaleController) Create(r types.Resource) (err error) {
+func (s *ScaleController) Create(ctx context.Context, r types.Resource) (err error) {
 	scale, err := assertScale(r)
 	if err != nil {
 		return
@@ -38,24 +75,24 @@ func (s *ScaleController) Create(r types.Resource) (err error) {
 	switch scale.Type {
 	case types.ScaleNominal, types.ScaleOrdinal:
 		q += newValues(scale, args) + `
-SELECT s.id, s.label, s.type, json_agg((v.id, v.label)::::%[1]s_scale_value ORDER BY v."index") AS values
+SELECT s.id, s.label, s.labels, s.type, s.revision, json_agg((v.id, v.label, v.labels)::::%[1]sscale_value ORDER BY v."index") AS values
   FROM new_scale s
   LEFT JOIN new_values v ON s.id = v.scale
-GROUP BY s.id, s.label, s.type`
+GROUP BY s.id, s.label, s.labels, s.type, s.revision`
 	case types.ScaleInterval:
 		q += newUnit(scale, args) + `
-SELECT s.id, s.label, s.type, u.unit, u.min, u.max
+SELECT s.id, s.label, s.labels, s.type, s.revision, u.unit, u.min, u.max
   FROM new_scale s
   LEFT JOIN new_units u ON s.id = u.scale`
 	default:
 		err = fmt.Errorf("Invalid scale type for new scale!")
 		return
 	}
-	stmt, err := s.db.PrepareNamed(fmt.Sprintf(q, s.db.prefix))
+	stmt, err := s.db.prepareNamed(s.db.format(q))
 	if err != nil {
 		return
 	}
-	row := stmt.QueryRowx(args)
+	row := stmt.QueryRowxContext(ctx, args)
 	err = row.StructScan(scale)
 	if err != nil {
 		return
@@ -65,46 +102,56 @@ SELECT s.id, s.label, s.type, u.unit, u.min, u.max
 }
 
 func newScale(s *types.Scale, args map[string]interface{}) string {
-	args["newScaleLabel"], args["newScaleType"] = s.Label, s.Type
-	return ` new_scale AS ( INSERT INTO %[1]s_scales (label, type) VALUES (:newScaleLabel, :newScaleType) RETURNING * )`
+	args["newScaleLabel"], args["newScaleLabels"], args["newScaleType"] = s.Label, s.Labels, s.Type
+	return ` new_scale AS ( INSERT INTO %[1]sscales (label, labels, type) VALUES (:newScaleLabel, :newScaleLabels, :newScaleType) RETURNING * )`
 }
 
 func newValues(s *types.Scale, args map[string]interface{}) (q string) {
 	q = newScale(s, args) + ","
 	if s.Values == nil || len(s.Values) == 0 {
-		q += ` new_values AS ( SELECT * FROM %[1]s_values WHERE FALSE)`
+		q += ` new_values AS ( SELECT * FROM %[1]svalues WHERE FALSE)`
 		return
 	}
-	vs := ""
-	for i, v := range s.Values {
-		lab, ind := fmt.Sprintf("newValuesLabel%d", i), fmt.Sprintf("newValuesIndex%d", i)
-		args[lab], args[ind] = v.Label, i
-		vs += fmt.Sprintf(",(:%s, :%s)", lab, ind)
+	indices := make([]int, len(s.Values))
+	for i := range s.Values {
+		indices[i] = i
 	}
-	q += ` new_scale_values AS ( VALUES ` + vs[1:] + ` ), new_values AS ( INSERT INTO %[1]s_values ("index", label, scale) SELECT v.index::::bigint, v.label, s.id FROM new_scale s, new_scale_values AS v (label, "index") RETURNING * )`
+	valueArrays("newValues", s.Values, indices, args)
+	q += ` new_values AS ( INSERT INTO %[1]svalues ("index", label, labels, scale) SELECT v.index, v.label, v.labels, s.id FROM new_scale s, unnest(CAST(:newValuesLabel AS text[]), CAST(:newValuesLabels AS jsonb[]), CAST(:newValuesIndex AS bigint[])) AS v (label, labels, "index") RETURNING * )`
 	return
 }
 
+// valueArrays sets the ids, labels, translated labels and indices of the values at the given indices of vs as array parameters named prefix followed by Id, Label, Labels and Index.
+func valueArrays(prefix string, vs types.Values, indices []int, args map[string]interface{}) {
+	ids, label, labels, index := make(pq.Int64Array, len(indices)), make(pq.StringArray, len(indices)), make([]types.Labels, len(indices)), make(pq.Int64Array, len(indices))
+	for i, j := range indices {
+		ids[i], label[i], labels[i], index[i] = int64(vs[j].Id), string(vs[j].Label), vs[j].Labels, int64(j)
+	}
+	args[prefix+"Id"], args[prefix+"Label"], args[prefix+"Labels"], args[prefix+"Index"] = ids, label, pq.Array(labels), index
+}
+
 func newUnit(s *types.Scale, args map[string]interface{}) (q string) {
 	if s.UnitDesc == nil {
 		s.UnitDesc = new(types.UnitDesc)
 	}
 	q = newScale(s, args) + ","
 	args["newUnitUnit"], args["newUnitMin"], args["newUnitMax"] = s.Unit, s.Min, s.Max
-	q += ` new_units AS ( INSERT INTO %[1]s_units (scale, unit, "min", "max") SELECT s.id, v.unit, v.min::::double precision, v.max::::double precision FROM new_scale s, (VALUES (:newUnitUnit, :newUnitMin, :newUnitMax)) AS v (unit, "min", "max") RETURNING * )`
+	q += ` new_units AS ( INSERT INTO %[1]sunits (scale, unit, "min", "max") SELECT s.id, v.unit, v.min::::double precision, v.max::::double precision FROM new_scale s, (VALUES (:newUnitUnit, :newUnitMin, :newUnitMax)) AS v (unit, "min", "max") RETURNING * )`
 	return
 }
 
 // Read satisfies the types.Controller interface
-func (s *ScaleController) Read(id types.Id) (r types.Resource, err error) {
-	stmt, err := s.db.Preparex(`SELECT s.id, s.label, s.type, json_agg((v.id, v.label)::` + s.db.prefix + `_scale_value ORDER BY v.index) AS values, COALESCE(u.unit, '') AS unit, u.min, u.max FROM ` + s.db.prefix + `_scales s LEFT JOIN ` + s.db.prefix + `_values v ON s.id = v.scale LEFT JOIN ` + s.db.prefix + `_units u ON s.id = u.scale WHERE s.id = $1 GROUP BY s.id, s.label, s.type, u.unit, u.min, u.max`)
+func (s *ScaleController) Read(ctx context.Context, id types.Id) (r types.Resource, err error) {
+	stmt, err := s.db.preparex(s.selectScales("WHERE s.id = $1 "))
 	if err != nil {
 		return
 	}
-	row := stmt.QueryRowx(id)
+	row := stmt.QueryRowxContext(ctx, id)
 	scale := &types.Scale{}
 	err = row.StructScan(scale)
-	if err != nil {
+	if err == sql.ErrNoRows {
+		return nil, types.NewHttpError(http.StatusNotFound, fmt.Errorf("No scale with id %d", id))
+	} else if err != nil {
 		return
 	}
 	err = cleanupScale(scale)
@@ -116,7 +163,7 @@ func (s *ScaleController) Read(id types.Id) (r types.Resource, err error) {
 }
 
 // Update satisfies the types.Controller interface
-func (s *ScaleController) Update(r types.Resource) (err error) {
+func (s *ScaleController) Update(ctx context.Context, r types.Resource) (err error) {
 	scale, err := assertScale(r)
 	if err != nil {
 		return
@@ -126,25 +173,30 @@ func (s *ScaleController) Update(r types.Resource) (err error) {
 	switch scale.Type {
 	case types.ScaleNominal, types.ScaleOrdinal:
 		q += changedValues(scale, args) + `
-SELECT s.id, s.label, s.type, json_agg((v.id, v.label)::::%[1]s_scale_value ORDER BY v."index") AS values
+SELECT s.id, s.label, s.labels, s.type, s.revision, json_agg((v.id, v.label, v.labels)::::%[1]sscale_value ORDER BY v."index") AS values
   FROM updated_scale s
   LEFT JOIN changed_values v ON s.id = v.scale
-GROUP BY s.id, s.label, s.type`
+GROUP BY s.id, s.label, s.labels, s.type, s.revision`
 	case types.ScaleInterval:
 		q += updatedUnit(scale, args) + `
-SELECT s.id, s.label, s.type, u.unit, u.min, u.max
+SELECT s.id, s.label, s.labels, s.type, s.revision, u.unit, u.min, u.max
   FROM updated_scale s
   LEFT JOIN updated_unit u ON s.id = u.scale`
 	default:
 		err = fmt.Errorf("Invalid scale type for new scale!")
 		return
 	}
-	stmt, err := s.db.PrepareNamed(fmt.Sprintf(q, s.db.prefix))
-	if err != nil {
-		return
-	}
-	row := stmt.QueryRowx(args)
-	err = row.StructScan(scale)
+	err = s.db.performWithTransaction(ctx, func(tx *sqlx.Tx) (err error) {
+		err = s.db.revise(ctx, tx, "scale", s.db.table("scales"), scale.Id)
+		if err != nil {
+			return
+		}
+		stmt, err := tx.PrepareNamedContext(ctx, s.db.format(q))
+		if err != nil {
+			return
+		}
+		return stmt.QueryRowxContext(ctx, args).StructScan(scale)
+	})
 	if err != nil {
 		return
 	}
@@ -153,38 +205,36 @@ SELECT s.id, s.label, s.type, u.unit, u.min, u.max
 }
 
 func updatedScale(s *types.Scale, args map[string]interface{}) string {
-	args["updatedScaleLabel"], args["updatedScaleId"] = s.Label, s.Id
-	return ` updated_scale AS ( UPDATE %[1]s_scales SET label = :updatedScaleLabel WHERE id = :updatedScaleId RETURNING * )`
+	args["updatedScaleLabel"], args["updatedScaleLabels"], args["updatedScaleId"] = s.Label, s.Labels, s.Id
+	return ` updated_scale AS ( UPDATE %[1]sscales SET label = :updatedScaleLabel, labels = :updatedScaleLabels WHERE id = :updatedScaleId RETURNING * )`
 }
 
 func changedValues(s *types.Scale, args map[string]interface{}) (q string) {
 	q = updatedScale(s, args)
-	del := `, deleted AS ( DELETE FROM %[1]s_values v USING updated_scale s WHERE v.scale = s.id AND v.id NOT IN ( SELECT id FROM changed_values ) )`
+	del := `, deleted AS ( DELETE FROM %[1]svalues v USING updated_scale s WHERE v.scale = s.id AND v.id NOT IN ( SELECT id FROM changed_values ) )`
 	if s.Values == nil || len(s.Values) == 0 {
-		q += `, changed_values AS ( SELECT * FROM %[1]s_values WHERE FALSE )` + del
+		q += `, changed_values AS ( SELECT * FROM %[1]svalues WHERE FALSE )` + del
 		return
 	}
-	uV, cV := "", ""
+	updated, created := make([]int, 0), make([]int, 0)
 	for i, v := range s.Values {
 		if v.Id != 0 {
-			id, lab, ind := fmt.Sprintf("changesValuesId%d", i), fmt.Sprintf("changesValuesLabel%d", i), fmt.Sprintf("changesValuesIndex%d", i)
-			args[id], args[lab], args[ind] = v.Id, v.Label, i
-			uV += fmt.Sprintf(",(:%s, :%s, :%s)", id, lab, ind)
+			updated = append(updated, i)
 		} else {
-			lab, ind := fmt.Sprintf("changesValuesLabel%d", i), fmt.Sprintf("changesValuesIndex%d", i)
-			args[lab], args[ind] = v.Label, i
-			cV += fmt.Sprintf(",(:%s, :%s)", lab, ind)
+			created = append(created, i)
 		}
 	}
-	if len(uV) > 0 {
-		q += `, updated_values AS ( UPDATE %[1]s_values v SET label = n.label, "index" = n.index::::bigint FROM updated_scale s, ( VALUES ` + uV[1:] + ` ) AS n (id, label, "index") WHERE v.id = n.id::::bigint AND v.scale = s.id RETURNING v.* )`
+	if len(updated) > 0 {
+		valueArrays("updatedValues", s.Values, updated, args)
+		q += `, updated_values AS ( UPDATE %[1]svalues v SET label = n.label, labels = n.labels, "index" = n.index FROM updated_scale s, unnest(CAST(:updatedValuesId AS bigint[]), CAST(:updatedValuesLabel AS text[]), CAST(:updatedValuesLabels AS jsonb[]), CAST(:updatedValuesIndex AS bigint[])) AS n (id, label, labels, "index") WHERE v.id = n.id AND v.scale = s.id RETURNING v.* )`
 	} else {
-		q += `, updated_values AS ( SELECT * FROM %[1]s_values WHERE FALSE )`
+		q += `, updated_values AS ( SELECT * FROM %[1]svalues WHERE FALSE )`
 	}
-	if len(cV) > 0 {
-		q += `, new_values AS ( INSERT INTO %[1]s_values (label, scale, "index") SELECT v.label, s.id, v.index::::bigint FROM updated_scale s, ( VALUES ` + cV[1:] + ` ) AS v (label, "index") RETURNING * )`
+	if len(created) > 0 {
+		valueArrays("newValues", s.Values, created, args)
+		q += `, new_values AS ( INSERT INTO %[1]svalues (label, labels, scale, "index") SELECT v.label, v.labels, s.id, v.index FROM updated_scale s, unnest(CAST(:newValuesLabel AS text[]), CAST(:newValuesLabels AS jsonb[]), CAST(:newValuesIndex AS bigint[])) AS v (label, labels, "index") RETURNING * )`
 	} else {
-		q += `, new_values AS ( SELECT * FROM %[1]s_values WHERE FALSE )`
+		q += `, new_values AS ( SELECT * FROM %[1]svalues WHERE FALSE )`
 	}
 	q += `, changed_values AS ( SELECT * FROM new_values UNION SELECT * FROM updated_values )` + del
 	return
@@ -196,29 +246,37 @@ func updatedUnit(s *types.Scale, args map[string]interface{}) (q string) {
 	}
 	q = updatedScale(s, args)
 	args["updatedUnitUnit"], args["updatedUnitMin"], args["updatedUnitMax"] = s.Unit, s.Min, s.Max
-	q += `, updated_unit AS ( UPDATE %[1]s_units SET unit = :updatedUnitUnit, min = :updatedUnitMin, max = :updatedUnitMax FROM updated_scale s WHERE scale = s.id RETURNING %[1]s_units.* )`
+	q += `, updated_unit AS ( UPDATE %[1]sunits SET unit = :updatedUnitUnit, min = :updatedUnitMin, max = :updatedUnitMax FROM updated_scale s WHERE scale = s.id RETURNING %[1]sunits.* )`
 	return
 }
 
-// Delete satisfies the types.Controller interface
-func (s *ScaleController) Delete(id types.Id) (err error) {
-	res, err := s.db.Exec("DELETE FROM "+s.db.prefix+"_scales WHERE id = $1", id)
-	if err != nil {
-		return
-	}
-	n, err := res.RowsAffected()
-	if err != nil {
+// Delete satisfies the types.Controller interface. The revisions of the metrics containing the scale are incremented as it is removed from them.
+func (s *ScaleController) Delete(ctx context.Context, id types.Id, cascade bool) (err error) {
+	return s.db.deleteResource(ctx, "scale", s.db.table("scales"), s.impactQuery(), id, cascade, func(tx *sqlx.Tx) (err error) {
+		_, err = tx.ExecContext(ctx, `UPDATE `+s.db.table("metrics")+` SET revision = revision + 1 WHERE id IN ( SELECT metric FROM `+s.db.table("metric_scale")+` WHERE scale = $1 )`, id)
 		return
-	}
-	if n != 1 {
-		err = types.NewHttpError(http.StatusNotFound, fmt.Errorf("No scale found with id %d", id))
-	}
-	return
+	})
+}
+
+// Impact satisfies the types.Controller interface
+func (s *ScaleController) Impact(ctx context.Context, id types.Id) (*types.Impact, error) {
+	return s.db.resourceImpact(ctx, "scale", s.impactQuery(), id)
+}
+
+// impactQuery returns the query for the rows affected by deleting a scale. The scale is removed from the metrics containing it. Ratings of events with values of the scale prevent the deletion.
+func (s *ScaleController) impactQuery() string {
+	return `SELECT 'deleted' AS effect, 'scales' AS kind, row_to_json(s) AS row FROM ` + s.db.table("scales") + ` s WHERE s.id = $1` +
+		` UNION ALL SELECT 'deleted', 'values', row_to_json(v) FROM ` + s.db.table("values") + ` v WHERE v.scale = $1` +
+		` UNION ALL SELECT 'deleted', 'units', row_to_json(u) FROM ` + s.db.table("units") + ` u WHERE u.scale = $1` +
+		` UNION ALL SELECT 'cascaded', 'metric_scale', row_to_json(ms) FROM ` + s.db.table("metric_scale") + ` ms WHERE ms.scale = $1` +
+		` UNION ALL SELECT 'blocking', 'event_ratings', row_to_json(r) FROM ` + s.db.table("event_ratings") + ` r JOIN ` + s.db.table("values") + ` v ON v.id = r.value WHERE v.scale = $1` +
+		` UNION ALL SELECT 'blocking', 'event_values', row_to_json(ev) FROM ` + s.db.table("event_values") + ` ev WHERE ev.scale = $1`
 }
 
 type ScaleReader struct {
-	err  error
-	rows *sqlx.Rows
+	err   error
+	rows  *sqlx.Rows
+	count counter
 }
 
 // Read implements the types.DocumentReader interface
@@ -249,9 +307,20 @@ func (s *ScaleReader) Read(r types.Resource) (ok bool, err error) {
 
 // Close implements the types.DocumentReader interface
 func (s *ScaleReader) Close() error {
+	if s.rows == nil {
+		return s.err
+	}
 	return s.rows.Close()
 }
 
+// Total implements the types.CountingReader interface
+func (s *ScaleReader) Total() (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	return s.count.total()
+}
+
 func assertScale(r types.Resource) (s *types.Scale, err error) {
 	switch scale := r.(type) {
 	case *types.Scale:
@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"database/sql/driver"
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/janvogt/gotambora/coding/types"
@@ -11,9 +12,9 @@ import (
 func TestCreateScale(t *testing.T) {
 	cValue := []string{"id", "label", "type", "values"}
 	cInterval := []string{"id", "label", "type", "unit", "min", "max"}
-	qBeginValue := `WITH new_scale AS \( INSERT INTO prefix_scales \(label, type\) VALUES \(\$1, \$2\) RETURNING \* \), `
-	qEndValue := ` SELECT s.id, s.label, s.type, json_agg\(\(v.id, v.label\)::prefix_scale_value ORDER BY v."index"\) AS values FROM new_scale s LEFT JOIN new_values v ON s.id = v.scale GROUP BY s.id, s.label, s.type`
-	qUnit := `WITH new_scale AS \( INSERT INTO prefix_scales \(label, type\) VALUES \(\$1, \$2\) RETURNING \* \), new_units AS \( INSERT INTO prefix_units \(scale, unit, "min", "max"\) SELECT s.id, v.unit, v.min::double precision, v.max::double precision FROM new_scale s, \(VALUES \(\$3, \$4, \$5\)\) AS v \(unit, "min", "max"\) RETURNING \* \) SELECT s.id, s.label, s.type, u.unit, u.min, u.max FROM new_scale s LEFT JOIN new_units u ON s.id = u.scale`
+	qBeginValue := `WITH new_scale AS \( INSERT INTO prefix_scales \(label, labels, type\) VALUES \(\$1, \$2, \$3\) RETURNING \* \), `
+	qEndValue := ` SELECT s.id, s.label, s.labels, s.type, s.revision, json_agg\(\(v.id, v.label, v.labels\)::prefix_scale_value ORDER BY v."index"\) AS values FROM new_scale s LEFT JOIN new_values v ON s.id = v.scale GROUP BY s.id, s.label, s.labels, s.type, s.revision`
+	qUnit := `WITH new_scale AS \( INSERT INTO prefix_scales \(label, labels, type\) VALUES \(\$1, \$2, \$3\) RETURNING \* \), new_units AS \( INSERT INTO prefix_units \(scale, unit, "min", "max"\) SELECT s.id, v.unit, v.min::double precision, v.max::double precision FROM new_scale s, \(VALUES \(\$4, \$5, \$6\)\) AS v \(unit, "min", "max"\) RETURNING \* \) SELECT s.id, s.label, s.labels, s.type, s.revision, u.unit, u.min, u.max FROM new_scale s LEFT JOIN new_units u ON s.id = u.scale`
 	tests := []struct {
 		q   string
 		sn  *types.Scale
@@ -23,27 +24,27 @@ func TestCreateScale(t *testing.T) {
 		col []string
 	}{
 		{
-			qBeginValue + `new_scale_values AS \( VALUES \(\$3, \$4\),\(\$5, \$6\),\(\$7, \$8\) \), new_values AS \( INSERT INTO prefix_values \("index", label, scale\) SELECT v.index::bigint, v.label, s.id FROM new_scale s, new_scale_values AS v \(label, "index"\) RETURNING \* \)` + qEndValue,
-			&types.Scale{0, "yeah", types.ScaleOrdinal, nil, types.Values{types.Value{0, "No1"}, types.Value{0, "No2"}, types.Value{0, "No3"}}},
-			[]driver.Value{"yeah", "ordinal", "No1", 0, "No2", 1, "No3", 2},
+			qBeginValue + `new_values AS \( INSERT INTO prefix_values \("index", label, labels, scale\) SELECT v.index, v.label, v.labels, s.id FROM new_scale s, unnest\(CAST\(\$4 AS text\[\]\), CAST\(\$5 AS jsonb\[\]\), CAST\(\$6 AS bigint\[\]\)\) AS v \(label, labels, "index"\) RETURNING \* \)` + qEndValue,
+			&types.Scale{0, "yeah", types.ScaleOrdinal, nil, types.Values{types.Value{0, "No1", types.Labels{"de": "Nr1"}, ""}, types.Value{0, "No2", nil, ""}, types.Value{0, "No3", nil, ""}}, nil, 0, ""},
+			[]driver.Value{"yeah", "{}", "ordinal", `{"No1","No2","No3"}`, `{"{\"de\":\"Nr1\"}","{}","{}"}`, "{0,1,2}"},
 			[]driver.Value{2, "yeah", "ordinal", `[{"id":1,"label":"No1"},{"id":2,"label":"No2"},{"id":3,"label":"No3"}]`},
-			&types.Scale{2, "yeah", types.ScaleOrdinal, nil, types.Values{types.Value{1, "No1"}, types.Value{2, "No2"}, types.Value{3, "No3"}}},
+			&types.Scale{2, "yeah", types.ScaleOrdinal, nil, types.Values{types.Value{1, "No1", nil, ""}, types.Value{2, "No2", nil, ""}, types.Value{3, "No3", nil, ""}}, nil, 0, ""},
 			cValue,
 		},
 		{
 			qBeginValue + `new_values AS \( SELECT \* FROM prefix_values WHERE FALSE\)` + qEndValue,
-			&types.Scale{0, "yeah", types.ScaleOrdinal, nil, types.Values{}},
-			[]driver.Value{"yeah", "ordinal"},
+			&types.Scale{0, "yeah", types.ScaleOrdinal, nil, types.Values{}, nil, 0, ""},
+			[]driver.Value{"yeah", "{}", "ordinal"},
 			[]driver.Value{2, "yeahR", "ordinal", `[{"id":null,"label":null}]`},
-			&types.Scale{2, "yeahR", types.ScaleOrdinal, nil, types.Values{}},
+			&types.Scale{2, "yeahR", types.ScaleOrdinal, nil, types.Values{}, nil, 0, ""},
 			cValue,
 		},
 		{
 			qUnit,
-			&types.Scale{0, "yo", types.ScaleInterval, &types.UnitDesc{"˚C", types.JsonNullFloat64{-273.15, true}, types.JsonNullFloat64{0, false}}, nil},
-			[]driver.Value{"yo", "interval", "˚C", -273.15, nil},
+			&types.Scale{0, "yo", types.ScaleInterval, &types.UnitDesc{"˚C", types.JsonNullFloat64{-273.15, true}, types.JsonNullFloat64{0, false}}, nil, nil, 0, ""},
+			[]driver.Value{"yo", "{}", "interval", "˚C", -273.15, nil},
 			[]driver.Value{2, "yo", "interval", "˚C", -273.15, nil},
-			&types.Scale{2, "yo", types.ScaleInterval, &types.UnitDesc{"˚C", types.JsonNullFloat64{-273.15, true}, types.JsonNullFloat64{0, false}}, nil},
+			&types.Scale{2, "yo", types.ScaleInterval, &types.UnitDesc{"˚C", types.JsonNullFloat64{-273.15, true}, types.JsonNullFloat64{0, false}}, nil, nil, 0, ""},
 			cInterval,
 		},
 	}
@@ -52,7 +53,7 @@ func TestCreateScale(t *testing.T) {
 		sqlmock.ExpectPrepare()
 		sqlmock.ExpectQuery(test.q).WithArgs(test.a...).WillReturnRows(sqlmock.NewRows(test.col).AddRow(test.r...))
 		c := &ScaleController{db}
-		e := c.Create(test.sn)
+		e := c.Create(context.Background(), test.sn)
 		if e != nil {
 			t.Errorf("Testcase %d: Unexcpected Error: %s\n", i, e)
 		} else if !reflect.DeepEqual(test.sn, test.se) {
@@ -64,21 +65,21 @@ func TestCreateScale(t *testing.T) {
 }
 
 func TestReadScale(t *testing.T) {
-	q := `SELECT s.id, s.label, s.type, json_agg\(\(v.id, v.label\)::prefix_scale_value ORDER BY v.index\) AS values, COALESCE\(u.unit, ''\) AS unit, u.min, u.max FROM prefix_scales s LEFT JOIN prefix_values v ON s.id = v.scale LEFT JOIN prefix_units u ON s.id = u.scale WHERE s.id = \$1 GROUP BY s.id, s.label, s.type, u.unit, u.min, u.max`
-	col := []string{"id", "label", "type", "values", "unit", "min", "max"}
+	q := `SELECT s.id, s.label, s.labels, s.type, s.revision, json_agg\(CAST\(\(v.id, v.label, v.labels\) AS prefix_scale_value\) ORDER BY v.index\) AS values, COALESCE\(u.unit, ''\) AS unit, u.min, u.max FROM prefix_scales s LEFT JOIN prefix_values v ON s.id = v.scale LEFT JOIN prefix_units u ON s.id = u.scale WHERE s.id = \$1 GROUP BY s.id, s.label, s.labels, s.type, s.revision, u.unit, u.min, u.max`
+	col := []string{"id", "label", "labels", "type", "values", "unit", "min", "max"}
 	tests := []struct {
 		r  []driver.Value
 		s  *types.Scale
 		id types.Id
 	}{
 		{
-			[]driver.Value{2, "scale", "nominal", `[{"id":3,"label":"No1"},{"id":2,"label":"No2"},{"id":1,"label":"No3"}]`, "", nil, nil},
-			&types.Scale{2, "scale", types.ScaleNominal, nil, types.Values{types.Value{3, "No1"}, types.Value{2, "No2"}, types.Value{1, "No3"}}},
+			[]driver.Value{2, "scale", `{"de":"Skala"}`, "nominal", `[{"id":3,"label":"No1","labels":{"de":"Nr1"}},{"id":2,"label":"No2","labels":{}},{"id":1,"label":"No3","labels":{}}]`, "", nil, nil},
+			&types.Scale{2, "scale", types.ScaleNominal, nil, types.Values{types.Value{3, "No1", types.Labels{"de": "Nr1"}, ""}, types.Value{2, "No2", types.Labels{}, ""}, types.Value{1, "No3", types.Labels{}, ""}}, types.Labels{"de": "Skala"}, 0, ""},
 			types.Id(2),
 		},
 		{
-			[]driver.Value{5, "scale", "interval", `[null]`, "˚C", -273.15, nil},
-			&types.Scale{5, "scale", types.ScaleInterval, &types.UnitDesc{"˚C", types.JsonNullFloat64{-273.15, true}, types.JsonNullFloat64{0, false}}, nil},
+			[]driver.Value{5, "scale", "{}", "interval", `[null]`, "˚C", -273.15, nil},
+			&types.Scale{5, "scale", types.ScaleInterval, &types.UnitDesc{"˚C", types.JsonNullFloat64{-273.15, true}, types.JsonNullFloat64{0, false}}, nil, types.Labels{}, 0, ""},
 			types.Id(5),
 		},
 	}
@@ -87,7 +88,7 @@ func TestReadScale(t *testing.T) {
 		sqlmock.ExpectPrepare()
 		sqlmock.ExpectQuery(q).WithArgs(int(test.id)).WillReturnRows(sqlmock.NewRows(col).AddRow(test.r...))
 		c := &ScaleController{db}
-		s, e := c.Read(test.id)
+		s, e := c.Read(context.Background(), test.id)
 		if e != nil {
 			t.Errorf("Testcase %d: Unexcpected Error: %s\n", i, e)
 		} else if !reflect.DeepEqual(s, test.s) {
@@ -98,20 +99,34 @@ func TestReadScale(t *testing.T) {
 	}
 }
 
+func TestReadUnknownScale(t *testing.T) {
+	db := newTestDB(t, "prefix")
+	sqlmock.ExpectPrepare()
+	sqlmock.ExpectQuery(`SELECT s.id, .* WHERE s.id = \$1`).WithArgs(9).WillReturnRows(sqlmock.NewRows([]string{"id", "label", "labels", "type", "values", "unit", "min", "max"}))
+	c := &ScaleController{db}
+	s, err := c.Read(context.Background(), types.Id(9))
+	if herr, ok := err.(types.HttpError); !ok || herr.Status() != 404 || s != nil {
+		t.Errorf("Expected not found error for unknown scale, but got scale %+v and err %s", s, err)
+	}
+	if err = db.Close(); err != nil {
+		t.Errorf("Unexpected database interaction: %s \n", err)
+	}
+}
+
 func TestUpdateScale(t *testing.T) {
 	cValue := []string{"id", "label", "type", "values"}
 	cInterval := []string{"id", "label", "type", "unit", "min", "max"}
-	qSUpdate := `WITH updated_scale AS \( UPDATE prefix_scales SET label = \$1 WHERE id = \$2 RETURNING \* \)`
+	qSUpdate := `WITH updated_scale AS \( UPDATE prefix_scales SET label = \$1, labels = \$2 WHERE id = \$3 RETURNING \* \)`
 	// qChangeEmpty := `, changed_values AS \( SELECT \* FROM prefix_values WHERE FALSE \), deleted AS \( DELETE FROM prefix_values v USING updated_scale s WHERE v.scale = s.id AND v.id NOT IN \( SELECT id FROM changed_values \) \)`
 	// qUpdateEmpty := `, updated_values AS \( SELECT \* FROM prefix_values WHERE FALSE \)`
 	// qNewEmpty := `, new_values AS \( SELECT \* FROM prefix_values WHERE FALSE \)`
-	qUpdateBegin := `, updated_values AS \( UPDATE prefix_values v SET label = n.label, "index" = n.index::bigint FROM updated_scale s, \( VALUES `
-	qUpdateEnd := ` \) AS n \(id, label, "index"\) WHERE v.id = n.id::bigint AND v.scale = s.id RETURNING v.\* \)`
-	qNewBegin := `, new_values AS \( INSERT INTO prefix_values \(label, scale, "index"\) SELECT v.label, s.id, v.index::bigint FROM updated_scale s, \( VALUES `
-	qNewEnd := ` \) AS v \(label, "index"\) RETURNING \* \)`
+	qUpdateBegin := `, updated_values AS \( UPDATE prefix_values v SET label = n.label, labels = n.labels, "index" = n.index FROM updated_scale s, unnest\(`
+	qUpdateEnd := `\) AS n \(id, label, labels, "index"\) WHERE v.id = n.id AND v.scale = s.id RETURNING v.\* \)`
+	qNewBegin := `, new_values AS \( INSERT INTO prefix_values \(label, labels, scale, "index"\) SELECT v.label, v.labels, s.id, v.index FROM updated_scale s, unnest\(`
+	qNewEnd := `\) AS v \(label, labels, "index"\) RETURNING \* \)`
 	qChanges := `, changed_values AS \( SELECT \* FROM new_values UNION SELECT \* FROM updated_values \), deleted AS \( DELETE FROM prefix_values v USING updated_scale s WHERE v.scale = s.id AND v.id NOT IN \( SELECT id FROM changed_values \) \)`
-	qValue := ` SELECT s.id, s.label, s.type, json_agg\(\(v.id, v.label\)::prefix_scale_value ORDER BY v."index"\) AS values FROM updated_scale s LEFT JOIN changed_values v ON s.id = v.scale GROUP BY s.id, s.label, s.type`
-	qUnit := `, updated_unit AS \( UPDATE prefix_units SET unit = \$3, min = \$4, max = \$5 FROM updated_scale s WHERE scale = s.id RETURNING prefix_units.\* \) SELECT s.id, s.label, s.type, u.unit, u.min, u.max FROM updated_scale s LEFT JOIN updated_unit u ON s.id = u.scale`
+	qValue := ` SELECT s.id, s.label, s.labels, s.type, s.revision, json_agg\(\(v.id, v.label, v.labels\)::prefix_scale_value ORDER BY v."index"\) AS values FROM updated_scale s LEFT JOIN changed_values v ON s.id = v.scale GROUP BY s.id, s.label, s.labels, s.type, s.revision`
+	qUnit := `, updated_unit AS \( UPDATE prefix_units SET unit = \$4, min = \$5, max = \$6 FROM updated_scale s WHERE scale = s.id RETURNING prefix_units.\* \) SELECT s.id, s.label, s.labels, s.type, s.revision, u.unit, u.min, u.max FROM updated_scale s LEFT JOIN updated_unit u ON s.id = u.scale`
 	tests := []struct {
 		q   string
 		sn  *types.Scale
@@ -121,27 +136,30 @@ func TestUpdateScale(t *testing.T) {
 		col []string
 	}{
 		{
-			qSUpdate + qUpdateBegin + `\(\$3, \$4, \$5\),\(\$6, \$7, \$8\),\(\$9, \$10, \$11\)` + qUpdateEnd + qNewBegin + `\(\$12, \$13\),\(\$14, \$15\)` + qNewEnd + qChanges + qValue,
-			&types.Scale{2, "yeah", types.ScaleOrdinal, nil, types.Values{types.Value{1, "NewNo1"}, types.Value{2, "No2"}, types.Value{0, "NewNo3"}, types.Value{3, "NewNo4"}, types.Value{0, "New5"}}},
-			[]driver.Value{"yeah", 2, 1, "NewNo1", 0, 2, "No2", 1, 3, "NewNo4", 3, "NewNo3", 2, "New5", 4},
+			qSUpdate + qUpdateBegin + `CAST\(\$4 AS bigint\[\]\), CAST\(\$5 AS text\[\]\), CAST\(\$6 AS jsonb\[\]\), CAST\(\$7 AS bigint\[\]\)` + qUpdateEnd + qNewBegin + `CAST\(\$8 AS text\[\]\), CAST\(\$9 AS jsonb\[\]\), CAST\(\$10 AS bigint\[\]\)` + qNewEnd + qChanges + qValue,
+			&types.Scale{2, "yeah", types.ScaleOrdinal, nil, types.Values{types.Value{1, "NewNo1", nil, ""}, types.Value{2, "No2", nil, ""}, types.Value{0, "NewNo3", nil, ""}, types.Value{3, "NewNo4", nil, ""}, types.Value{0, "New5", nil, ""}}, nil, 0, ""},
+			[]driver.Value{"yeah", "{}", 2, "{1,2,3}", `{"NewNo1","No2","NewNo4"}`, `{"{}","{}","{}"}`, "{0,1,3}", `{"NewNo3","New5"}`, `{"{}","{}"}`, "{2,4}"},
 			[]driver.Value{3, "yeahR", "ordinal", `[{"id":2,"label":"NewNo1R"},{"id":3,"label":"No2R"},{"id":5,"label":"NewNo3R"},{"id":4,"label":"NewNo4R"},{"id":6,"label":"New5R"}]`},
-			&types.Scale{3, "yeahR", types.ScaleOrdinal, nil, types.Values{types.Value{2, "NewNo1R"}, types.Value{3, "No2R"}, types.Value{5, "NewNo3R"}, types.Value{4, "NewNo4R"}, types.Value{6, "New5R"}}},
+			&types.Scale{3, "yeahR", types.ScaleOrdinal, nil, types.Values{types.Value{2, "NewNo1R", nil, ""}, types.Value{3, "No2R", nil, ""}, types.Value{5, "NewNo3R", nil, ""}, types.Value{4, "NewNo4R", nil, ""}, types.Value{6, "New5R", nil, ""}}, nil, 0, ""},
 			cValue,
 		}, {
 			qSUpdate + qUnit,
-			&types.Scale{2, "yo", types.ScaleInterval, &types.UnitDesc{"˚K", types.JsonNullFloat64{0, true}, types.JsonNullFloat64{0, false}}, nil},
-			[]driver.Value{"yo", 2, "˚K", 0., nil},
+			&types.Scale{2, "yo", types.ScaleInterval, &types.UnitDesc{"˚K", types.JsonNullFloat64{0, true}, types.JsonNullFloat64{0, false}}, nil, nil, 0, ""},
+			[]driver.Value{"yo", "{}", 2, "˚K", 0., nil},
 			[]driver.Value{3, "yoR", "interval", "˚KR", nil, 0.},
-			&types.Scale{3, "yoR", types.ScaleInterval, &types.UnitDesc{"˚KR", types.JsonNullFloat64{0, false}, types.JsonNullFloat64{0, true}}, nil},
+			&types.Scale{3, "yoR", types.ScaleInterval, &types.UnitDesc{"˚KR", types.JsonNullFloat64{0, false}, types.JsonNullFloat64{0, true}}, nil, nil, 0, ""},
 			cInterval,
 		},
 	}
 	for i, test := range tests {
 		db := newTestDB(t, "prefix")
+		sqlmock.ExpectBegin()
+		sqlmockExpectRevise("prefix_scales", test.sn.Id, 1)
 		sqlmock.ExpectPrepare()
 		sqlmock.ExpectQuery(test.q).WithArgs(test.a...).WillReturnRows(sqlmock.NewRows(test.col).AddRow(test.r...))
+		sqlmock.ExpectCommit()
 		c := &ScaleController{db}
-		e := c.Update(test.sn)
+		e := c.Update(context.Background(), test.sn)
 		if e != nil {
 			t.Errorf("Testcase %d: Unexcpected Error: %s\n", i, e)
 		} else if !reflect.DeepEqual(test.sn, test.se) {
@@ -151,3 +169,37 @@ func TestUpdateScale(t *testing.T) {
 		}
 	}
 }
+
+func TestQueryScales(t *testing.T) {
+	col := []string{"id", "label", "labels", "type", "values", "unit", "min", "max"}
+	tests := []struct {
+		q    map[string][]string
+		sql  string
+		args []driver.Value
+	}{
+		{map[string][]string{}, `FROM prefix_scales s LEFT JOIN prefix_values v ON s.id = v.scale LEFT JOIN prefix_units u ON s.id = u.scale GROUP BY`, []driver.Value{}},
+		{map[string][]string{"type": []string{"nominal", "ordinal"}}, `u.scale WHERE s.type = ANY\(\$1\) +GROUP BY`, []driver.Value{`{"nominal","ordinal"}`}},
+		{map[string][]string{"label": []string{"scale"}, "metric": []string{"4"}}, `u.scale WHERE s.label = ANY\(\$1\) +AND s.id IN \( SELECT scale FROM prefix_metric_scale WHERE metric = ANY\(\$2\) +\) +GROUP BY`, []driver.Value{`{"scale"}`, `{"4"}`}},
+	}
+	for i, test := range tests {
+		db := newTestDB(t, "prefix")
+		sqlmock.ExpectPrepare()
+		sqlmock.ExpectQuery(test.sql).WithArgs(test.args...).WillReturnRows(sqlmock.NewRows(col).AddRow(2, "scale", "{}", "nominal", `[{"id":3,"label":"No1","labels":{}}]`, "", nil, nil))
+		c := &ScaleController{db}
+		reader := c.Query(context.Background(), test.q)
+		s := new(types.Scale)
+		ok, err := reader.Read(s)
+		expected := &types.Scale{2, "scale", types.ScaleNominal, nil, types.Values{types.Value{3, "No1", types.Labels{}, ""}}, types.Labels{}, 0, ""}
+		if !ok || err != nil {
+			t.Errorf("Testcase %d: Expected to read scale, but got ok = %t and err = %v", i, ok, err)
+		} else if !reflect.DeepEqual(s, expected) {
+			t.Errorf("Testcase %d: Unexpected result:\n%+v\nexpected:\n%+v\n", i, s, expected)
+		}
+		if ok, err = reader.Read(s); ok || err != nil {
+			t.Errorf("Testcase %d: Expected end of scales, but got ok = %t and err = %v", i, ok, err)
+		}
+		if err = db.Close(); err != nil {
+			t.Errorf("Testcase %d: Unexpected database interaction: %s", i, err)
+		}
+	}
+}
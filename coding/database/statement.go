@@ -0,0 +1,97 @@
+package database
+
+import (
+	"context"
+	"github.com/janvogt/gotambora/coding/types"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"sync"
+)
+
+// statements caches the statements prepared on a DB by their SQL, so every statement is only prepared once. The SQL generated by the controllers depends on which parameters are given but not on their values, lists of values are passed as arrays. Statements prepared in transactions are not cached, they are closed with the transaction.
+type statements struct {
+	mu    sync.Mutex
+	plain map[string]*sqlx.Stmt
+	named map[string]*sqlx.NamedStmt
+}
+
+// preparex returns the cached statement for q preparing it if it has not been prepared yet. The statement must not be closed by the caller. It is prepared without holding the lock and independent of the context of any single request, as it is shared by all requests. If another request stored the same statement in the meantime, that one is used and the duplicate is closed.
+func (db *DB) preparex(q string) (*sqlx.Stmt, error) {
+	db.stmts.mu.Lock()
+	stmt := db.stmts.plain[q]
+	db.stmts.mu.Unlock()
+	if stmt != nil {
+		return stmt, nil
+	}
+	prepared, err := db.PreparexContext(context.Background(), q)
+	if err != nil {
+		return nil, err
+	}
+	db.stmts.mu.Lock()
+	defer db.stmts.mu.Unlock()
+	if stmt = db.stmts.plain[q]; stmt != nil {
+		prepared.Close()
+		return stmt, nil
+	}
+	if db.stmts.plain == nil {
+		db.stmts.plain = make(map[string]*sqlx.Stmt)
+	}
+	db.stmts.plain[q] = prepared
+	return prepared, nil
+}
+
+// prepareNamed returns the cached named statement for q like preparex.
+func (db *DB) prepareNamed(q string) (*sqlx.NamedStmt, error) {
+	db.stmts.mu.Lock()
+	stmt := db.stmts.named[q]
+	db.stmts.mu.Unlock()
+	if stmt != nil {
+		return stmt, nil
+	}
+	prepared, err := db.PrepareNamedContext(context.Background(), q)
+	if err != nil {
+		return nil, err
+	}
+	db.stmts.mu.Lock()
+	defer db.stmts.mu.Unlock()
+	if stmt = db.stmts.named[q]; stmt != nil {
+		prepared.Close()
+		return stmt, nil
+	}
+	if db.stmts.named == nil {
+		db.stmts.named = make(map[string]*sqlx.NamedStmt)
+	}
+	db.stmts.named[q] = prepared
+	return prepared, nil
+}
+
+// Close closes all cached statements and the database. Statements still in use are closed once they are not needed anymore.
+func (db *DB) Close() (err error) {
+	db.stmts.mu.Lock()
+	for q, stmt := range db.stmts.plain {
+		if e := stmt.Close(); e != nil && err == nil {
+			err = e
+		}
+		delete(db.stmts.plain, q)
+	}
+	for q, stmt := range db.stmts.named {
+		if e := stmt.Close(); e != nil && err == nil {
+			err = e
+		}
+		delete(db.stmts.named, q)
+	}
+	db.stmts.mu.Unlock()
+	if e := db.DB.Close(); e != nil && err == nil {
+		err = e
+	}
+	return
+}
+
+// idArray returns the ids as array parameter.
+func idArray(ids []types.Id) pq.Int64Array {
+	a := make(pq.Int64Array, len(ids))
+	for i, id := range ids {
+		a[i] = int64(id)
+	}
+	return a
+}
@@ -0,0 +1,57 @@
+package database
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/janvogt/gotambora/coding/types"
+	"testing"
+)
+
+func TestStatementCache(t *testing.T) {
+	q := `SELECT m.id, .* WHERE m.id = \$1`
+	col := []string{"id", "label", "labels", "scales"}
+	db := newTestDB(t, "prefix")
+	sqlmock.ExpectPrepare()
+	sqlmock.ExpectQuery(q).WithArgs(2).WillReturnRows(sqlmock.NewRows(col).AddRow(2, "metric", "{}", `[4]`))
+	sqlmock.ExpectQuery(q).WithArgs(3).WillReturnRows(sqlmock.NewRows(col).AddRow(3, "other", "{}", `[null]`))
+	c := &MetricController{db}
+	for _, id := range []types.Id{2, 3} {
+		if m, err := c.Read(context.Background(), id); err != nil {
+			t.Errorf("Unexcpected Error: %s\n", err)
+		} else if m.(*types.Metric).Id != id {
+			t.Errorf("Expected metric %d, but got %+v", id, m)
+		}
+	}
+	if len(db.stmts.plain) != 1 {
+		t.Errorf("Expected one cached statement, but got %d", len(db.stmts.plain))
+	}
+	if err := db.Close(); err != nil {
+		t.Errorf("Unexpected database interaction: %s \n", err)
+	}
+	if len(db.stmts.plain) != 0 {
+		t.Errorf("Expected no cached statements after closing, but got %d", len(db.stmts.plain))
+	}
+}
+
+func TestStatementCacheArrays(t *testing.T) {
+	q := `ms.metric WHERE m.id = ANY\(\$1\) +GROUP BY`
+	col := []string{"id", "label", "labels", "scales"}
+	db := newTestDB(t, "prefix")
+	sqlmock.ExpectPrepare()
+	sqlmock.ExpectQuery(q).WithArgs(`{"1","2"}`).WillReturnRows(sqlmock.NewRows(col).AddRow(1, "metric", "{}", `[3]`))
+	sqlmock.ExpectQuery(q).WithArgs(`{"5"}`).WillReturnRows(sqlmock.NewRows(col).AddRow(5, "other", "{}", `[3]`))
+	c := &MetricController{db}
+	for _, ids := range [][]string{{"1", "2"}, {"5"}} {
+		reader := c.Query(context.Background(), map[string][]string{"id": ids})
+		if ok, err := reader.Read(new(types.Metric)); !ok || err != nil {
+			t.Errorf("Expected to read metric for ids %v, but got ok = %t and err = %s", ids, ok, err)
+		}
+		reader.Close()
+	}
+	if len(db.stmts.named) != 1 {
+		t.Errorf("Expected the query to be prepared once for any number of ids, but got %d statements", len(db.stmts.named))
+	}
+	if err := db.Close(); err != nil {
+		t.Errorf("Unexpected database interaction: %s \n", err)
+	}
+}
@@ -0,0 +1,261 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/janvogt/gotambora/coding/types"
+	"github.com/jmoiron/sqlx"
+	"net/http"
+)
+
+// Tree satisfies the types.ContextNodeController interface
+func (nc *NodeController) Tree(ctx context.Context, id types.Id, depth int, include []string) types.ResourceReader {
+	res := new(TreeReader)
+	args := map[string]interface{}{"treeRoot": id}
+	limit := ""
+	if depth >= 0 {
+		args["treeDepth"] = depth
+		limit = "AND t.depth < :treeDepth "
+	}
+	cols, joins, included := "", "", make(map[string]bool)
+	for _, inc := range include {
+		if included[inc] {
+			continue
+		}
+		switch inc {
+		case "metrics":
+			cols += ", json_agg(DISTINCT m.metric) AS metrics"
+			joins += " LEFT JOIN " + nc.db.table("node_metric") + " m ON n.id = m.node"
+		case "references":
+			cols += ", json_agg(DISTINCT l.to) AS references"
+			joins += " LEFT JOIN " + nc.db.table("links") + " l ON n.id = l.from"
+		default:
+			res.err = types.NewHttpError(http.StatusBadRequest, fmt.Errorf("Can't include %s in node tree.", inc))
+			return res
+		}
+		included[inc] = true
+	}
+	q := `WITH RECURSIVE ` + subtree(nc.db, ":treeRoot", limit) + ` SELECT n.id, n.label, n.labels, n.parent, n.inherit, t.depth, ` + selectChildren(nc.db.table("nodes")) + cols + ` FROM tree t JOIN ` + nc.db.table("nodes") + ` n ON n.id = t.id` + joins + ` GROUP BY n.id, n.label, n.labels, n.parent, n.inherit, t.depth, t.position ORDER BY t.position`
+	var stmt *sqlx.NamedStmt
+	stmt, res.err = nc.db.prepareNamed(q)
+	if res.err != nil {
+		return res
+	}
+	res.rows, res.err = stmt.QueryxContext(ctx, args)
+	return res
+}
+
+// subtree returns the recursive common table expression "tree" containing the id, the depth, the path of ids from the root and the position for the node with the given id and its descendants. Ordering by position yields parents before their children and siblings by their index. Descendants are only added while condition holds for their parent t.
+func subtree(db *DB, id, condition string) string {
+	return `tree ( id, depth, path, position ) AS ( SELECT id, 0, ARRAY[id], ARRAY[0, id] FROM ` + db.table("nodes") + ` WHERE id = ` + id + ` UNION ALL SELECT c.id, t.depth + 1, t.path || c.id, t.position || ARRAY[c."index", c.id] FROM tree t JOIN ` + db.table("nodes") + ` c ON c.parent = t.id WHERE NOT c.id = ANY(t.path) ` + condition + `)`
+}
+
+// Ancestors satisfies the types.ContextNodeController interface
+func (nc *NodeController) Ancestors(ctx context.Context, id types.Id) (path types.Path, err error) {
+	stmt, err := nc.db.preparex(`WITH RECURSIVE ` + ancestry(nc.db, "$1") + ` SELECT id, label, labels FROM ancestry ORDER BY depth DESC`)
+	if err != nil {
+		return
+	}
+	path = make(types.Path, 0)
+	err = stmt.SelectContext(ctx, &path, id)
+	if err != nil {
+		path = nil
+		return
+	}
+	if len(path) == 0 {
+		path, err = nil, types.NewHttpError(http.StatusNotFound, fmt.Errorf("No node with id %d", id))
+		return
+	}
+	path = path[:len(path)-1]
+	return
+}
+
+// ancestry returns the recursive common table expression "ancestry" containing the id, labels and depth of the node with the given id and all its ancestors. The depth is the distance to that node.
+func ancestry(db *DB, id string) string {
+	return `ancestry ( id, label, labels, parent, depth, path ) AS ( SELECT id, label, labels, parent, 0, ARRAY[id] FROM ` + db.table("nodes") + ` WHERE id = ` + id + ` UNION ALL SELECT p.id, p.label, p.labels, p.parent, a.depth + 1, a.path || p.id FROM ` + db.table("nodes") + ` p JOIN ancestry a ON p.id = a.parent WHERE NOT p.id = ANY(a.path) )`
+}
+
+// selectPath returns a subquery for the json encoded path of ancestors of a node with the given parent.
+func selectPath(db *DB, parent string) string {
+	return `COALESCE(( WITH RECURSIVE ` + ancestry(db, parent) + ` SELECT json_agg(a ORDER BY a.depth DESC) FROM ( SELECT id, label, labels, depth FROM ancestry ) a ), '[]')`
+}
+
+// Move satisfies the types.ContextNodeController interface
+func (nc *NodeController) Move(ctx context.Context, id types.Id, parent types.OptionalId, position int) (n *types.Node, err error) {
+	err = nc.db.performWithTransaction(ctx, func(tx *sqlx.Tx) error {
+		return nc.move(ctx, tx, id, parent, position)
+	})
+	if err != nil {
+		return
+	}
+	r, err := nc.Read(ctx, id)
+	if err == nil {
+		n = r.(*types.Node)
+	}
+	return
+}
+
+// move moves the node with the given id below parent at the given position among its siblings or last if position is negative. Moving it to a later position among the same siblings closes its old slot first, so it ends up at position. The revisions of the node and of its old and new parent are incremented as well as those of its heirs if the node inherits metrics from its new parent.
+func (nc *NodeController) move(ctx context.Context, tx *sqlx.Tx, id types.Id, parent types.OptionalId, position int) (err error) {
+	err = nc.checkCycle(ctx, tx, id, parent)
+	if err != nil {
+		return
+	}
+	var moved struct {
+		Parent  types.OptionalId
+		Inherit bool
+		Index   int
+	}
+	err = tx.GetContext(ctx, &moved, `SELECT parent, inherit, "index" FROM `+nc.db.table("nodes")+` WHERE id = $1 FOR UPDATE`, id)
+	old := moved.Parent
+	if err == sql.ErrNoRows {
+		return types.NewHttpError(http.StatusNotFound, fmt.Errorf("No node with id %d", id))
+	} else if err != nil {
+		return
+	}
+	index, args := nextIndex(nc.db, "$1"), []interface{}{parent, id}
+	if position >= 0 && old == parent && moved.Index < position {
+		_, err = tx.ExecContext(ctx, `UPDATE `+nc.db.table("nodes")+` SET "index" = "index" - 1 WHERE parent IS NOT DISTINCT FROM $1 AND "index" > $2 AND id <> $3`, parent, moved.Index, id)
+		if err != nil {
+			return
+		}
+	}
+	if position >= 0 {
+		_, err = tx.ExecContext(ctx, `UPDATE `+nc.db.table("nodes")+` SET "index" = "index" + 1 WHERE parent IS NOT DISTINCT FROM $1 AND "index" >= $2 AND id <> $3`, parent, position, id)
+		if err != nil {
+			return
+		}
+		index, args = "$3", append(args, position)
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE `+nc.db.table("nodes")+` SET parent = $1, "index" = `+index+`, revision = revision + 1 WHERE id = $2`, args...)
+	if err != nil {
+		return
+	}
+	err = nc.db.touch(ctx, tx, nc.db.table("nodes"), validIds(old, parent))
+	if err == nil && old != parent && moved.Inherit {
+		err = nc.touchHeirs(ctx, tx, id)
+	}
+	if err != nil {
+		return
+	}
+	err = nc.renumber(ctx, tx, old)
+	if err == nil && old != parent {
+		err = nc.renumber(ctx, tx, parent)
+	}
+	return
+}
+
+// Reorder satisfies the types.ContextNodeController interface
+func (nc *NodeController) Reorder(ctx context.Context, id types.Id, children types.RelationToMany) (n *types.Node, err error) {
+	err = nc.db.performWithTransaction(ctx, func(tx *sqlx.Tx) (err error) {
+		err = nc.db.revise(ctx, tx, "node", nc.db.table("nodes"), id)
+		if err != nil {
+			return
+		}
+		return nc.reorder(ctx, tx, id, children)
+	})
+	if err != nil {
+		return
+	}
+	r, err := nc.Read(ctx, id)
+	if err == nil {
+		n = r.(*types.Node)
+	}
+	return
+}
+
+// reorder orders the children of the node with the given id like children. Children missing in children follow in their previous order, ids of other nodes are ignored.
+func (nc *NodeController) reorder(ctx context.Context, tx *sqlx.Tx, id types.Id, children types.RelationToMany) (err error) {
+	if len(children) == 0 {
+		return
+	}
+	args := map[string]interface{}{"reorderParent": id, "reorderChildren": idArray(children)}
+	stmt, err := tx.PrepareNamedContext(ctx, `UPDATE `+nc.db.table("nodes")+` n SET "index" = o.position FROM ( SELECT c.id, row_number() OVER ( ORDER BY min(v.position), c."index", c.id ) - 1 AS position FROM `+nc.db.table("nodes")+` c LEFT JOIN unnest(CAST(:reorderChildren AS bigint[])) WITH ORDINALITY AS v ( id, position ) ON c.id = v.id WHERE c.parent = :reorderParent GROUP BY c.id, c."index" ) o WHERE n.id = o.id AND n."index" <> o.position`)
+	if err != nil {
+		return
+	}
+	_, err = stmt.ExecContext(ctx, args)
+	return
+}
+
+// checkCycle returns a conflict error if making parent the parent of the node with the given id would create a cycle, i.e. if the node is the parent itself or one of its ancestors.
+func (nc *NodeController) checkCycle(ctx context.Context, tx *sqlx.Tx, id types.Id, parent types.OptionalId) (err error) {
+	if !parent.Valid {
+		return
+	}
+	var cycle bool
+	err = tx.GetContext(ctx, &cycle, `WITH RECURSIVE `+ancestry(nc.db, "$1")+` SELECT EXISTS ( SELECT 1 FROM ancestry WHERE id = $2 )`, parent.Id, id)
+	if err == nil && cycle {
+		err = types.NewHttpError(http.StatusConflict, fmt.Errorf("Node %d can't be moved below node %d as it would become its own ancestor.", id, parent.Id))
+	}
+	return
+}
+
+// renumber renumbers the children of parent consecutively starting at 0 preserving their order.
+func (nc *NodeController) renumber(ctx context.Context, tx *sqlx.Tx, parent types.OptionalId) (err error) {
+	_, err = tx.ExecContext(ctx, `UPDATE `+nc.db.table("nodes")+` n SET "index" = o.position FROM ( SELECT id, row_number() OVER ( ORDER BY "index", id ) - 1 AS position FROM `+nc.db.table("nodes")+` WHERE parent IS NOT DISTINCT FROM $1 ) o WHERE n.id = o.id AND n."index" <> o.position`, parent)
+	return
+}
+
+// touchParent increments the revision of the parent of the node with the given id, if it has one.
+func (nc *NodeController) touchParent(ctx context.Context, tx *sqlx.Tx, id types.Id) (err error) {
+	_, err = tx.ExecContext(ctx, `UPDATE `+nc.db.table("nodes")+` SET revision = revision + 1 WHERE id = ( SELECT parent FROM `+nc.db.table("nodes")+` WHERE id = $1 )`, id)
+	return
+}
+
+// touchHeirs increments the revisions of the heirs of the nodes with the given ids, i.e. of their descendants inheriting metrics from them. Their effective metrics change with those of the nodes.
+func (nc *NodeController) touchHeirs(ctx context.Context, tx *sqlx.Tx, ids ...types.Id) (err error) {
+	_, err = tx.ExecContext(ctx, `WITH RECURSIVE heirs ( id, path ) AS ( SELECT id, ARRAY[id] FROM `+nc.db.table("nodes")+` WHERE parent = ANY($1) AND inherit UNION ALL SELECT c.id, h.path || c.id FROM heirs h JOIN `+nc.db.table("nodes")+` c ON c.parent = h.id WHERE c.inherit AND NOT c.id = ANY(h.path) ) UPDATE `+nc.db.table("nodes")+` SET revision = revision + 1 WHERE id IN ( SELECT id FROM heirs )`, idArray(ids))
+	return
+}
+
+// nextIndex returns a subquery for the index following the last child of parent.
+func nextIndex(db *DB, parent string) string {
+	return `( SELECT COALESCE(max(s."index") + 1, 0) FROM ` + db.table("nodes") + ` s WHERE s.parent IS NOT DISTINCT FROM ` + parent + ` )`
+}
+
+type TreeReader struct {
+	err  error
+	rows *sqlx.Rows
+}
+
+// Read implements the types.DocumentReader interface
+func (tr *TreeReader) Read(r types.Resource) (ok bool, err error) {
+	if tr.err != nil {
+		err = tr.err
+		return
+	}
+	n, err := assertTreeNode(r)
+	if err != nil {
+		return
+	}
+	if ok = tr.rows.Next(); ok {
+		err = tr.rows.StructScan(n)
+	} else {
+		tr.rows.Close()
+	}
+	if err != nil {
+		ok, tr.err = false, err
+	}
+	return
+}
+
+// Close implements the types.DocumentReader interface
+func (tr *TreeReader) Close() error {
+	if tr.rows == nil {
+		return tr.err
+	}
+	return tr.rows.Close()
+}
+
+func assertTreeNode(r types.Resource) (n *types.TreeNode, err error) {
+	switch node := r.(type) {
+	case *types.TreeNode:
+		n = node
+	default:
+		err = errors.New("Unsuported Resource type, expected *TreeNode.")
+	}
+	return
+}
@@ -0,0 +1,147 @@
+package database
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/janvogt/gotambora/coding/types"
+	"reflect"
+	"testing"
+)
+
+func TestTree(t *testing.T) {
+	q := `WITH RECURSIVE tree \( id, depth, path, position \) AS \( SELECT id, 0, ARRAY\[id\], ARRAY\[0, id\] FROM prefix_nodes WHERE id = \$1 UNION ALL SELECT c.id, t.depth \+ 1, t.path \|\| c.id, t.position \|\| ARRAY\[c."index", c.id\] FROM tree t JOIN prefix_nodes c ON c.parent = t.id WHERE NOT c.id = ANY\(t.path\) AND t.depth < \$2 \) SELECT n.id, n.label, n.labels, n.parent, n.inherit, t.depth, COALESCE\(\( SELECT json_agg\(c.id ORDER BY c."index", c.id\) FROM prefix_nodes c WHERE c.parent = n.id \), '\[\]'\) AS children, json_agg\(DISTINCT m.metric\) AS metrics FROM tree t JOIN prefix_nodes n ON n.id = t.id LEFT JOIN prefix_node_metric m ON n.id = m.node GROUP BY n.id, n.label, n.labels, n.parent, n.inherit, t.depth, t.position ORDER BY t.position`
+	col := []string{"id", "label", "parent", "inherit", "depth", "children", "metrics"}
+	db := newTestDB(t, "prefix")
+	sqlmock.ExpectPrepare()
+	sqlmock.ExpectQuery(q).WithArgs(1, 2).WillReturnRows(sqlmock.NewRows(col).AddRow(1, "root", nil, true, 0, `[3,2]`, `[null]`).AddRow(3, "first", 1, true, 1, `[]`, `[null]`).AddRow(2, "second", 1, true, 1, `[]`, `[5]`))
+	c := &NodeController{db}
+	reader := c.Tree(context.Background(), types.Id(1), 2, []string{"metrics", "metrics"})
+	expected := []*types.TreeNode{
+		&types.TreeNode{Node: types.Node{Id: 1, Label: "root", Children: types.RelationToMany{3, 2}, Metrics: types.RelationToMany{}, InheritMetrics: true}},
+		&types.TreeNode{Node: types.Node{Id: 3, Label: "first", Parent: types.OptionalId{1, true}, Children: types.RelationToMany{}, Metrics: types.RelationToMany{}, InheritMetrics: true}, Depth: 1},
+		&types.TreeNode{Node: types.Node{Id: 2, Label: "second", Parent: types.OptionalId{1, true}, Children: types.RelationToMany{}, Metrics: types.RelationToMany{5}, InheritMetrics: true}, Depth: 1},
+	}
+	for i, e := range expected {
+		n := new(types.TreeNode)
+		ok, err := reader.Read(n)
+		if !ok || err != nil {
+			t.Errorf("Node %d: Expected to read node, but got ok = %t and err = %s", i, ok, err)
+		} else if !reflect.DeepEqual(n, e) {
+			t.Errorf("Node %d: Unexpected result:\n%+v\nexpected:\n%+v\n", i, n, e)
+		}
+	}
+	if ok, err := reader.Read(new(types.TreeNode)); ok || err != nil {
+		t.Errorf("Expected end of tree, but got ok = %t and err = %s", ok, err)
+	}
+	if err := db.Close(); err != nil {
+		t.Errorf("Unexpected database interaction: %s \n", err)
+	}
+	reader = c.Tree(context.Background(), types.Id(1), -1, []string{"parents"})
+	if ok, err := reader.Read(new(types.TreeNode)); ok || err == nil {
+		t.Errorf("Expected error for unknown include, but got ok = %t and err = %s", ok, err)
+	}
+}
+
+func TestAncestors(t *testing.T) {
+	q := `WITH RECURSIVE ancestry \( id, label, labels, parent, depth, path \) AS \( SELECT id, label, labels, parent, 0, ARRAY\[id\] FROM prefix_nodes WHERE id = \$1 UNION ALL SELECT p.id, p.label, p.labels, p.parent, a.depth \+ 1, a.path \|\| p.id FROM prefix_nodes p JOIN ancestry a ON p.id = a.parent WHERE NOT p.id = ANY\(a.path\) \) SELECT id, label, labels FROM ancestry ORDER BY depth DESC`
+	db := newTestDB(t, "prefix")
+	sqlmock.ExpectPrepare()
+	sqlmock.ExpectQuery(q).WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"id", "label", "labels"}).AddRow(1, "Temperature", `{"de":"Temperatur"}`).AddRow(2, "Cold", `{}`).AddRow(3, "Frost", `{}`))
+	sqlmock.ExpectQuery(q).WithArgs(4).WillReturnRows(sqlmock.NewRows([]string{"id", "label"}))
+	c := &NodeController{db}
+	path, err := c.Ancestors(context.Background(), types.Id(3))
+	expected := types.Path{types.PathElement{1, "Temperature", types.Labels{"de": "Temperatur"}, ""}, types.PathElement{2, "Cold", types.Labels{}, ""}}
+	if err != nil {
+		t.Errorf("Unexcpected Error: %s\n", err)
+	} else if !reflect.DeepEqual(path, expected) {
+		t.Errorf("Unexpected result:\n%+v\nexpected:\n%+v\n", path, expected)
+	}
+	path, err = c.Ancestors(context.Background(), types.Id(4))
+	if herr, ok := err.(types.HttpError); !ok || herr.Status() != 404 || path != nil {
+		t.Errorf("Expected not found error for unknown node, but got path %+v and err %s", path, err)
+	}
+	if err = db.Close(); err != nil {
+		t.Errorf("Unexpected database interaction: %s \n", err)
+	}
+}
+
+func TestMove(t *testing.T) {
+	qCycle := `WITH RECURSIVE ancestry \(.*\) SELECT EXISTS \( SELECT 1 FROM ancestry WHERE id = \$2 \)`
+	qRenumber := `UPDATE prefix_nodes n SET "index" = o.position FROM \( SELECT id, row_number\(\) OVER \( ORDER BY "index", id \) - 1 AS position FROM prefix_nodes WHERE parent IS NOT DISTINCT FROM \$1 \) o WHERE n.id = o.id AND n."index" <> o.position`
+	db := newTestDB(t, "prefix")
+	sqlmock.ExpectBegin()
+	sqlmock.ExpectQuery(qCycle).WithArgs(5, 2).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	sqlmock.ExpectQuery(`SELECT parent, inherit, "index" FROM prefix_nodes WHERE id = \$1 FOR UPDATE`).WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"parent", "inherit", "index"}).AddRow(1, true, 1))
+	sqlmock.ExpectExec(`UPDATE prefix_nodes SET "index" = "index" \+ 1 WHERE parent IS NOT DISTINCT FROM \$1 AND "index" >= \$2 AND id <> \$3`).WithArgs(5, 0, 2).WillReturnResult(sqlmock.NewResult(0, 3))
+	sqlmock.ExpectExec(`UPDATE prefix_nodes SET parent = \$1, "index" = \$3, revision = revision \+ 1 WHERE id = \$2`).WithArgs(5, 2, 0).WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlmock.ExpectExec(`UPDATE prefix_nodes SET revision = revision \+ 1 WHERE id = ANY\(\$1\)`).WithArgs("{1,5}").WillReturnResult(sqlmock.NewResult(0, 2))
+	sqlmockExpectTouchHeirs("{2}")
+	sqlmock.ExpectExec(qRenumber).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 2))
+	sqlmock.ExpectExec(qRenumber).WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlmock.ExpectCommit()
+	sqlmock.ExpectPrepare()
+	sqlmock.ExpectQuery(`SELECT n.id, .* WHERE n.id = \$1`).WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"id", "label", "parent", "inherit", "children", "references", "metrics", "effective_metrics"}).AddRow(2, "node", 5, true, `[null]`, `[null]`, `[null]`, `[]`))
+	c := &NodeController{db}
+	n, err := c.Move(context.Background(), types.Id(2), types.OptionalId{5, true}, 0)
+	expected := &types.Node{2, "node", types.OptionalId{5, true}, types.RelationToMany{}, types.RelationToMany{}, types.RelationToMany{}, true, types.RelationToMany{}, nil, nil, nil, 0, ""}
+	if err != nil {
+		t.Errorf("Unexcpected Error: %s\n", err)
+	} else if !reflect.DeepEqual(n, expected) {
+		t.Errorf("Unexpected result:\n%+v\nexpected:\n%+v\n", n, expected)
+	}
+	sqlmock.ExpectBegin()
+	sqlmock.ExpectQuery(qCycle).WithArgs(4, 2).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	sqlmock.ExpectRollback()
+	n, err = c.Move(context.Background(), types.Id(2), types.OptionalId{4, true}, -1)
+	if herr, ok := err.(types.HttpError); !ok || herr.Status() != 409 || n != nil {
+		t.Errorf("Expected conflict when moving node below its descendant, but got node %+v and err %s", n, err)
+	}
+	if err = db.Close(); err != nil {
+		t.Errorf("Unexpected database interaction: %s \n", err)
+	}
+}
+
+func TestMoveWithinParent(t *testing.T) {
+	db := newTestDB(t, "prefix")
+	sqlmock.ExpectBegin()
+	sqlmock.ExpectQuery(`WITH RECURSIVE ancestry`).WithArgs(1, 2).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	sqlmock.ExpectQuery(`SELECT parent, inherit, "index" FROM prefix_nodes WHERE id = \$1 FOR UPDATE`).WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"parent", "inherit", "index"}).AddRow(1, true, 1))
+	sqlmock.ExpectExec(`UPDATE prefix_nodes SET "index" = "index" - 1 WHERE parent IS NOT DISTINCT FROM \$1 AND "index" > \$2 AND id <> \$3`).WithArgs(1, 1, 2).WillReturnResult(sqlmock.NewResult(0, 2))
+	sqlmock.ExpectExec(`UPDATE prefix_nodes SET "index" = "index" \+ 1 WHERE parent IS NOT DISTINCT FROM \$1 AND "index" >= \$2 AND id <> \$3`).WithArgs(1, 2, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlmock.ExpectExec(`UPDATE prefix_nodes SET parent = \$1, "index" = \$3, revision = revision \+ 1 WHERE id = \$2`).WithArgs(1, 2, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlmock.ExpectExec(`UPDATE prefix_nodes SET revision = revision \+ 1 WHERE id = ANY\(\$1\)`).WithArgs("{1,1}").WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlmock.ExpectExec(`UPDATE prefix_nodes n SET "index" = o.position`).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlmock.ExpectCommit()
+	sqlmock.ExpectPrepare()
+	sqlmock.ExpectQuery(`SELECT n.id, .* WHERE n.id = \$1`).WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"id", "label", "parent", "inherit", "children", "references", "metrics", "effective_metrics"}).AddRow(2, "node", 1, true, `[null]`, `[null]`, `[null]`, `[]`))
+	c := &NodeController{db}
+	if _, err := c.Move(context.Background(), types.Id(2), types.OptionalId{1, true}, 2); err != nil {
+		t.Errorf("Unexcpected Error: %s\n", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Errorf("Unexpected database interaction: %s \n", err)
+	}
+}
+
+func TestReorder(t *testing.T) {
+	q := `UPDATE prefix_nodes n SET "index" = o.position FROM \( SELECT c.id, row_number\(\) OVER \( ORDER BY min\(v.position\), c."index", c.id \) - 1 AS position FROM prefix_nodes c LEFT JOIN unnest\(CAST\(\$1 AS bigint\[\]\)\) WITH ORDINALITY AS v \( id, position \) ON c.id = v.id WHERE c.parent = \$2 GROUP BY c.id, c."index" \) o WHERE n.id = o.id AND n."index" <> o.position`
+	db := newTestDB(t, "prefix")
+	sqlmock.ExpectBegin()
+	sqlmockExpectRevise("prefix_nodes", 1, 3)
+	sqlmock.ExpectPrepare()
+	sqlmock.ExpectExec(q).WithArgs("{4,3}", 1).WillReturnResult(sqlmock.NewResult(0, 2))
+	sqlmock.ExpectCommit()
+	sqlmock.ExpectPrepare()
+	sqlmock.ExpectQuery(`SELECT n.id, .* WHERE n.id = \$1`).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "label", "parent", "inherit", "children", "references", "metrics", "effective_metrics"}).AddRow(1, "root", nil, true, `[4,3,2]`, `[null]`, `[null]`, `[]`))
+	c := &NodeController{db}
+	n, err := c.Reorder(context.Background(), types.Id(1), types.RelationToMany{4, 3})
+	expected := &types.Node{1, "root", types.OptionalId{}, types.RelationToMany{4, 3, 2}, types.RelationToMany{}, types.RelationToMany{}, true, types.RelationToMany{}, nil, nil, nil, 0, ""}
+	if err != nil {
+		t.Errorf("Unexcpected Error: %s\n", err)
+	} else if !reflect.DeepEqual(n, expected) {
+		t.Errorf("Unexpected result:\n%+v\nexpected:\n%+v\n", n, expected)
+	}
+	if err = db.Close(); err != nil {
+		t.Errorf("Unexpected database interaction: %s \n", err)
+	}
+}
@@ -0,0 +1,101 @@
+package database
+
+import (
+	"context"
+	"github.com/janvogt/gotambora/coding/types"
+	"github.com/jmoiron/sqlx"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// baselineRows fills a schema of version 1 with a node hierarchy, a scale used by a metric and a rated event.
+const baselineRows = `
+INSERT INTO %[1]snodes (label, parent) VALUES ('Weather', NULL);
+INSERT INTO %[1]snodes (label, parent) VALUES ('Frost', 1), ('Hail', 1);
+INSERT INTO %[1]slinks ("from", "to") VALUES (2, 3);
+INSERT INTO %[1]sscales (label, type) VALUES ('Severity', 'ordinal');
+INSERT INTO %[1]svalues (scale, "index", label) VALUES (1, 0, 'low'), (1, 1, 'high');
+INSERT INTO %[1]smetrics (label) VALUES ('Damage');
+INSERT INTO %[1]smetric_scale (metric, scale) VALUES (1, 1);
+INSERT INTO %[1]snode_metric (node, metric) VALUES (1, 1);
+INSERT INTO %[1]sevents (type) VALUES (2);
+INSERT INTO %[1]sevent_ratings (event, value) VALUES (1, 2);
+`
+
+// TestUpgradeBaseline upgrades a schema of version 1 holding data to SchemaVersion on a real Postgres server, uses it and reverts all migrations again. It is skipped unless CODING_TEST_DBURL is set to the URL of a database the test may create objects in.
+func TestUpgradeBaseline(t *testing.T) {
+	url := os.Getenv("CODING_TEST_DBURL")
+	if url == "" {
+		t.Skip("CODING_TEST_DBURL is not set.")
+	}
+	dbx, err := sqlx.Connect("postgres", url)
+	if err != nil {
+		t.Fatalf("Connecting to %s should succeed, but got %s", url, err)
+	}
+	defer dbx.Close()
+	db, err := Open(dbx, "upgrade_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Clean()
+	ctx := context.Background()
+	if err = db.migrate(ctx, 0, 1); err != nil {
+		t.Fatalf("Creating the baseline schema should succeed, but got %s", err)
+	}
+	if _, err = dbx.Exec(db.format(baselineRows)); err != nil {
+		t.Fatalf("Filling the baseline schema should succeed, but got %s", err)
+	}
+	if _, err = NewDB(dbx, "upgrade_test"); err == nil {
+		t.Errorf("NewDB() should require migrating a schema of version 1")
+	}
+	if err = db.Migrate(ctx, SchemaVersion); err != nil {
+		t.Fatalf("Migrate() should upgrade version 1 to %d, but got %s", SchemaVersion, err)
+	}
+	upgraded, err := NewDB(dbx, "upgrade_test")
+	if err != nil {
+		t.Fatalf("NewDB() should accept the upgraded schema, but got %s", err)
+	}
+	defer upgraded.Close()
+	nodes := upgraded.NodeController()
+	r, err := nodes.Read(ctx, 1)
+	if err != nil {
+		t.Fatalf("Reading a node of the baseline should succeed, but got %s", err)
+	}
+	if n := r.(*types.Node); !reflect.DeepEqual(n.Children, types.RelationToMany{2, 3}) || n.Revision != 1 {
+		t.Errorf("Expected the children of the baseline in order at revision 1, but got %+v", n)
+	}
+	r, err = nodes.Read(ctx, 2)
+	if err != nil {
+		t.Fatalf("Reading a node of the baseline should succeed, but got %s", err)
+	}
+	n := r.(*types.Node)
+	if !n.InheritMetrics || !reflect.DeepEqual(n.EffectiveMetrics, types.RelationToMany{1}) {
+		t.Errorf("Expected the node to inherit the metrics of its parent, but got %+v", n)
+	}
+	if !reflect.DeepEqual(n.References, types.RelationToMany{3}) || len(n.TypedReferences) != 0 || len(n.Labels) != 0 {
+		t.Errorf("Expected the links of the baseline to get the default type and no labels, but got %+v", n)
+	}
+	n.Labels = types.Labels{"de": "Frost"}
+	if err = nodes.Update(ctx, n); err != nil {
+		t.Errorf("Updating a node of the baseline should succeed, but got %s", err)
+	}
+	found := nodes.Query(ctx, map[string][]string{"q": {"frost"}})
+	if ok, err := found.Read(new(types.Node)); !ok || err != nil {
+		t.Errorf("Expected to find the node of the baseline, but got ok = %t and err = %v", ok, err)
+	}
+	found.Close()
+	if _, err = nodes.Merge(ctx, 3, 2); err != nil {
+		t.Errorf("Merging nodes of the baseline should succeed, but got %s", err)
+	}
+	e, err := upgraded.EventController().Read(ctx, 1)
+	if err != nil || !reflect.DeepEqual(e.(*types.Event).Ratings, types.RelationToMany{2}) {
+		t.Errorf("Expected the ratings of the baseline event, but got %+v and err %v", e, err)
+	}
+	if err = db.Migrate(ctx, 0); err != nil {
+		t.Errorf("Migrate() should revert all migrations, but got %s", err)
+	}
+	if v, err := db.Version(); v != 0 || err != nil {
+		t.Errorf("Expected no schema after reverting all migrations, but got version %d and err %v", v, err)
+	}
+}
@@ -1,66 +1,102 @@
 package coding
 
 import (
+	"context"
 	"fmt"
 	"github.com/janvogt/gotambora/coding/database"
 	"github.com/janvogt/gotambora/coding/types"
 )
 
-type Parameter struct {
-	Id    int64
-	Label types.Label `db:"name_en"`
-}
+// defaultLanguage is the language of the label of imported nodes if it is available.
+const defaultLanguage = "en"
 
-type Attribute struct {
-	Id    int64
-	Label types.Label `db:"name_en"`
+// Legacy is a row of the legacy parameter, attribute or value tables with the labels of all languages found.
+type Legacy struct {
+	Id     int64
+	Label  types.Label
+	Labels types.Labels
 }
 
-type Value struct {
-	Id    int64       `db:id`
-	Label types.Label `db:"name_en"`
+// legacyLanguages are the languages the legacy tables have columns for, e.g. name_de. Other columns are not taken for labels.
+var legacyLanguages = []string{defaultLanguage, "de"}
+
+// selectLegacy selects rows of the legacy tables. The labels are taken from the columns named like the prefix followed by one of the legacyLanguages, e.g. name_de. The label is the one in the default language or the first language available.
+func selectLegacy(ctx context.Context, db *database.DB, prefix string, q string, args ...interface{}) (legacies []Legacy, err error) {
+	rows, err := db.QueryxContext(ctx, q, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		row := make(map[string]interface{})
+		err = rows.MapScan(row)
+		if err != nil {
+			return
+		}
+		l := Legacy{Labels: make(types.Labels)}
+		switch id := row["id"].(type) {
+		case int64:
+			l.Id = id
+		default:
+			return nil, fmt.Errorf("Unsuported id %v in legacy row.", id)
+		}
+		for _, lang := range legacyLanguages {
+			v := row[prefix+lang]
+			if v == nil {
+				continue
+			}
+			var label types.Label
+			if err = label.Scan(v); err != nil {
+				return
+			}
+			if label != "" {
+				l.Labels[lang] = label
+			}
+		}
+		l.Label = l.Labels.Localize("", legacyLanguages)
+		legacies = append(legacies, l)
+	}
+	err = rows.Err()
+	return
 }
 
-func ImportNodes(db *database.DB) error {
+func ImportNodes(ctx context.Context, db *database.DB) error {
 	nc := db.NodeController()
-	pars := make([]Parameter, 0, 100)
-	err := db.Select(&pars, "SELECT id, name_en FROM parameter;")
+	pars, err := selectLegacy(ctx, db, "name_", "SELECT * FROM parameter;")
 	if err != nil {
 		return err
 	}
 	for _, par := range pars {
-		attrs := make([]Attribute, 0, 100)
-		p := &types.Node{}
-		p.Label = par.Label
+		p := &types.Node{InheritMetrics: true}
+		p.Label, p.Labels = par.Label, par.Labels
 		fmt.Printf("Creating node %v for Par %d\n", p, par.Id)
-		err = nc.Create(p)
+		err = nc.Create(ctx, p)
 		if err != nil {
 			return err
 		}
-		err = db.Select(&attrs, "SELECT attribute.id, COALESCE(attribute.name_en, attribute.description_en) AS name_en FROM parameter_attribute JOIN attribute ON parameter_attribute.attribute_id = attribute.id WHERE parameter_attribute.parameter_id = $1;", par.Id)
+		attrs, err := selectLegacy(ctx, db, "name_", "SELECT attribute.* FROM parameter_attribute JOIN attribute ON parameter_attribute.attribute_id = attribute.id WHERE parameter_attribute.parameter_id = $1;", par.Id)
 		if err != nil {
 			return err
 		}
 		for _, attr := range attrs {
-			vals := make([]Value, 0, 100)
-			a := &types.Node{}
-			a.Label = attr.Label
+			a := &types.Node{InheritMetrics: true}
+			a.Label, a.Labels = attr.Label, attr.Labels
 			a.Parent = types.OptionalId{p.Id, true}
 			fmt.Printf("Creating node %v for tuple %d %d\n", a, par.Id, attr.Id)
-			err = nc.Create(a)
+			err = nc.Create(ctx, a)
 			if err != nil {
 				return err
 			}
-			err = db.Select(&vals, "SELECT id, name_en FROM value WHERE attribute_id = $1", attr.Id)
+			vals, err := selectLegacy(ctx, db, "name_", "SELECT * FROM value WHERE attribute_id = $1", attr.Id)
 			if err != nil {
 				return err
 			}
 			for _, val := range vals {
-				v := &types.Node{}
-				v.Label = val.Label
+				v := &types.Node{InheritMetrics: true}
+				v.Label, v.Labels = val.Label, val.Labels
 				v.Parent = types.OptionalId{a.Id, true}
 				fmt.Printf("Creating node %v for tripel %d %d %d\n", v, par.Id, attr.Id, val.Id)
-				err = nc.Create(v)
+				err = nc.Create(ctx, v)
 				if err != nil {
 					return err
 				}
@@ -0,0 +1,153 @@
+package types
+
+import (
+	"context"
+)
+
+// ContextResourceController is a ResourceController whose operations take the context of the request they serve. An operation is cancelled once its context is done, Readers returned by Query stop reading then.
+type ContextResourceController interface {
+	New() (r Resource)
+	Query(ctx context.Context, q map[string][]string) (res ResourceReader)
+	Create(ctx context.Context, r Resource) (err error)
+	Read(ctx context.Context, id Id) (r Resource, err error)
+	Update(ctx context.Context, r Resource) (err error)
+	Delete(ctx context.Context, id Id, cascade bool) (err error)
+	Impact(ctx context.Context, id Id) (impact *Impact, err error)
+}
+
+// ContextNodeController is a NodeController whose operations take the context of the request they serve like a ContextResourceController.
+type ContextNodeController interface {
+	ContextResourceController
+	Tree(ctx context.Context, id Id, depth int, include []string) (res ResourceReader)
+	Ancestors(ctx context.Context, id Id) (path Path, err error)
+	Move(ctx context.Context, id Id, parent OptionalId, position int) (n *Node, err error)
+	Reorder(ctx context.Context, id Id, children RelationToMany) (n *Node, err error)
+	Merge(ctx context.Context, id, target Id) (report *MergeReport, err error)
+	LinkTypes(ctx context.Context) (linkTypes []LinkType, err error)
+}
+
+// RelationController changes single ids of the to-many links of a resource without changing anything else. The link is named like in the links of the resource, e.g. "references:synonym" for typed references of nodes. Unknown links are not found.
+type RelationController interface {
+	AddRelated(ctx context.Context, id Id, link string, related RelationToMany) (r Resource, err error)    // AddRelated adds the related ids to the link of the resource with the given id. Ids already linked are ignored.
+	RemoveRelated(ctx context.Context, id Id, link string, related RelationToMany) (r Resource, err error) // RemoveRelated removes the related ids from the link of the resource with the given id. Ids not linked are ignored.
+}
+
+// WithContext adapts a ResourceController to the ContextResourceController interface. Operations are not started if their context is done already, but can't be cancelled once started.
+func WithContext(ctrl ResourceController) ContextResourceController {
+	return contextController{ctrl}
+}
+
+// NodeWithContext adapts a NodeController to the ContextNodeController interface like WithContext.
+func NodeWithContext(ctrl NodeController) ContextNodeController {
+	return contextNodeController{contextController{ctrl}, ctrl}
+}
+
+type contextController struct {
+	ctrl ResourceController
+}
+
+func (c contextController) New() Resource {
+	return c.ctrl.New()
+}
+
+func (c contextController) Query(ctx context.Context, q map[string][]string) ResourceReader {
+	if ctx.Err() != nil {
+		return failedReader{ctx.Err()}
+	}
+	return c.ctrl.Query(q)
+}
+
+func (c contextController) Create(ctx context.Context, r Resource) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return c.ctrl.Create(r)
+}
+
+func (c contextController) Read(ctx context.Context, id Id) (Resource, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return c.ctrl.Read(id)
+}
+
+func (c contextController) Update(ctx context.Context, r Resource) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return c.ctrl.Update(r)
+}
+
+func (c contextController) Delete(ctx context.Context, id Id, cascade bool) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return c.ctrl.Delete(id, cascade)
+}
+
+func (c contextController) Impact(ctx context.Context, id Id) (*Impact, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return c.ctrl.Impact(id)
+}
+
+type contextNodeController struct {
+	contextController
+	nodes NodeController
+}
+
+func (c contextNodeController) Tree(ctx context.Context, id Id, depth int, include []string) ResourceReader {
+	if ctx.Err() != nil {
+		return failedReader{ctx.Err()}
+	}
+	return c.nodes.Tree(id, depth, include)
+}
+
+func (c contextNodeController) Ancestors(ctx context.Context, id Id) (Path, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return c.nodes.Ancestors(id)
+}
+
+func (c contextNodeController) Move(ctx context.Context, id Id, parent OptionalId, position int) (*Node, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return c.nodes.Move(id, parent, position)
+}
+
+func (c contextNodeController) Reorder(ctx context.Context, id Id, children RelationToMany) (*Node, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return c.nodes.Reorder(id, children)
+}
+
+func (c contextNodeController) Merge(ctx context.Context, id, target Id) (*MergeReport, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return c.nodes.Merge(id, target)
+}
+
+func (c contextNodeController) LinkTypes(ctx context.Context) ([]LinkType, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return c.nodes.LinkTypes()
+}
+
+// failedReader is a ResourceReader failing with err.
+type failedReader struct {
+	err error
+}
+
+func (f failedReader) Read(r Resource) (bool, error) {
+	return false, f.err
+}
+
+func (f failedReader) Close() error {
+	return nil
+}
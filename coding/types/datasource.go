@@ -6,10 +6,10 @@ import (
 )
 
 type DataSource interface {
-	NodeController() ResourceController
-	ScaleController() ResourceController
-	MetricController() ResourceController
-	// EventController() ResourceController
+	NodeController() ContextNodeController
+	ScaleController() ContextResourceController
+	MetricController() ContextResourceController
+	EventController() ContextResourceController
 }
 
 type RelationToMany []Id
@@ -33,3 +33,26 @@ func (ids *RelationToMany) Scan(src interface{}) (err error) {
 	}
 	return
 }
+
+// TypedRelations groups related ids by the type of their relation.
+type TypedRelations map[string]RelationToMany
+
+// Scan implements sql.Scanner. The scanned groups replace all groups of t.
+func (t *TypedRelations) Scan(src interface{}) (err error) {
+	*t = nil
+	switch src := src.(type) {
+	case string:
+		err = json.Unmarshal([]byte(src), t)
+	case []byte:
+		err = json.Unmarshal(src, t)
+	default:
+		err = fmt.Errorf("Unsuported Typte %T for coding.TypedRelations", src)
+	}
+	return
+}
+
+// LinkType is a type of link between nodes from the managed vocabulary.
+type LinkType struct {
+	Name  string `json:"name"`
+	Label Label  `json:"label"`
+}
@@ -1,21 +1,39 @@
 package types
 
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
 // HttpError represents an error with an associated HTTP Status Code.
 type HttpError interface {
 	error
 	Status() int // Status returns the associated HTTP Status Code
 }
 
-// NewHttpError creates a new Error with associated HTTP Status Code
+// CodedError is a HttpError with a stable code identifying the kind of error. Clients can rely on the code while the message may change.
+type CodedError interface {
+	HttpError
+	Code() string // Code returns the code of the error, like "not_found".
+}
+
+// NewHttpError creates a new Error with associated HTTP Status Code. Its code is derived from the status like "not_found" for 404 Not Found.
 func NewHttpError(status int, err error) HttpError {
+	return NewCodedError(status, "", err)
+}
+
+// NewCodedError creates a new Error with associated HTTP Status Code and the given code. If code is empty it is derived from the status.
+func NewCodedError(status int, code string, err error) HttpError {
 	if err != nil {
-		return &httpError{status, err}
+		return &httpError{status, code, err}
 	}
 	return nil
 }
 
 type httpError struct {
 	status int
+	code   string
 	err    error
 }
 
@@ -28,3 +46,102 @@ func (h *httpError) Error() string {
 func (h *httpError) Status() int {
 	return h.status
 }
+
+// Code satisfies the CodedError interface
+func (h *httpError) Code() string {
+	if h.code == "" {
+		return statusCode(h.status)
+	}
+	return h.code
+}
+
+// InvalidRating describes a rating or measured value of an event which is not permitted for the event's type.
+type InvalidRating struct {
+	Value    OptionalId      `json:"value"`    // Value is the id of the invalid rating value, if any.
+	Scale    OptionalId      `json:"scale"`    // Scale is the id of the scale of an invalid measured value, if any.
+	Measured JsonNullFloat64 `json:"measured"` // Measured is the invalid measured value, if any.
+	Reason   string          `json:"reason"`   // Reason explains why the rating is invalid.
+}
+
+// InvalidRatingsError lists all invalid ratings of an event. It is reported with status 422 Unprocessable Entity.
+type InvalidRatingsError []InvalidRating
+
+// Error satisfies the HttpError interface
+func (e InvalidRatingsError) Error() string {
+	return fmt.Sprintf("Event has %d rating(s) not permitted by the metrics of its type.", len(e))
+}
+
+// Status satisfies the HttpError interface
+func (e InvalidRatingsError) Status() int {
+	return http.StatusUnprocessableEntity
+}
+
+// Code satisfies the CodedError interface
+func (e InvalidRatingsError) Code() string {
+	return "invalid_ratings"
+}
+
+// ProblemMembers satisfies the ProblemExtender interface. All invalid ratings are listed as "ratings".
+func (e InvalidRatingsError) ProblemMembers() map[string]interface{} {
+	return map[string]interface{}{"ratings": []InvalidRating(e)}
+}
+
+// RedirectError reports that the requested resource has been merged into the resource with id Target. It is reported with status 301 Moved Permanently.
+type RedirectError struct {
+	Id     Id // Id is the id of the requested resource.
+	Target Id // Target is the id of the resource replacing it.
+}
+
+// Error satisfies the HttpError interface
+func (e RedirectError) Error() string {
+	return fmt.Sprintf("Resource %d has been merged into %d.", e.Id, e.Target)
+}
+
+// Status satisfies the HttpError interface
+func (e RedirectError) Status() int {
+	return http.StatusMovedPermanently
+}
+
+// Code satisfies the CodedError interface
+func (e RedirectError) Code() string {
+	return "merged"
+}
+
+// ProblemMembers satisfies the ProblemExtender interface. The id of the resource replacing the requested one is given as "target".
+func (e RedirectError) ProblemMembers() map[string]interface{} {
+	return map[string]interface{}{"target": e.Target}
+}
+
+// ImpactError reports that a resource can't be deleted because of rows blocking the deletion or because cascading the deletion to other resources has not been requested. It is reported with status 409 Conflict.
+type ImpactError struct {
+	Impact  *Impact
+	Message string
+}
+
+// Error satisfies the HttpError interface
+func (e ImpactError) Error() string {
+	return e.Message
+}
+
+// Status satisfies the HttpError interface
+func (e ImpactError) Status() int {
+	return http.StatusConflict
+}
+
+// Code satisfies the CodedError interface. It is "deletion_blocked" if there are blocking rows and "cascade_required" otherwise.
+func (e ImpactError) Code() string {
+	if e.Impact != nil && len(e.Impact.Blocking) != 0 {
+		return "deletion_blocked"
+	}
+	return "cascade_required"
+}
+
+// ProblemMembers satisfies the ProblemExtender interface. The impact of the deletion is given as "impact".
+func (e ImpactError) ProblemMembers() map[string]interface{} {
+	return map[string]interface{}{"impact": e.Impact}
+}
+
+// MarshalJSON reports the error as problem details.
+func (e ImpactError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(NewProblem(e))
+}
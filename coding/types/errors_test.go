@@ -1 +1,47 @@
 package types
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestImpactErrorMarshalJSON(t *testing.T) {
+	impact := NewImpact()
+	impact.Add("deleted", AffectedRow{"metrics", Row(`{"id":1,"label":"m"}`)})
+	impact.Add("blocking", AffectedRow{"node_metric", Row(`{"node":2,"metric":1}`)})
+	expected := `{"code":"deletion_blocked","detail":"blocked","impact":{"deleted":[{"kind":"metrics","row":{"id":1,"label":"m"}}],"cascaded":[],"blocking":[{"kind":"node_metric","row":{"node":2,"metric":1}}]},"status":409,"title":"Conflict"}`
+	j, err := json.Marshal(ImpactError{impact, "blocked"})
+	if err != nil {
+		t.Errorf("Unexpected Error: %s", err)
+	} else if string(j) != expected {
+		t.Errorf("Unexpected result:\n%s\n expected:\n%s\n", j, expected)
+	}
+	if err = impact.Add("ignored", AffectedRow{}); err == nil {
+		t.Errorf("Expected error for unknown effect")
+	}
+}
+
+func TestNewProblem(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected string
+	}{
+		{NewHttpError(http.StatusNotFound, errors.New("No node with id 1")), `{"code":"not_found","detail":"No node with id 1","status":404,"title":"Not Found"}`},
+		{NewHttpError(http.StatusPreconditionFailed, errors.New("changed")), `{"code":"precondition_failed","detail":"changed","status":412,"title":"Precondition Failed"}`},
+		{NewCodedError(http.StatusBadRequest, "invalid_id", errors.New("bad id")), `{"code":"invalid_id","detail":"bad id","status":400,"title":"Bad Request"}`},
+		{RedirectError{3, 7}, `{"code":"merged","detail":"Resource 3 has been merged into 7.","status":301,"target":7,"title":"Moved Permanently"}`},
+		{ImpactError{NewImpact(), "cascade"}, `{"code":"cascade_required","detail":"cascade","impact":{"deleted":[],"cascaded":[],"blocking":[]},"status":409,"title":"Conflict"}`},
+		{ValidationError{{"/label", "The label must not be empty."}}, `{"code":"invalid_fields","detail":"Invalid fields /label: The label must not be empty.","fields":[{"field":"/label","message":"The label must not be empty."}],"status":422,"title":"Unprocessable Entity"}`},
+		{errors.New("pq: secret internals"), `{"code":"internal_server_error","detail":"An unexpected error occurred.","status":500,"title":"Internal Server Error"}`},
+	}
+	for i, test := range tests {
+		j, err := json.Marshal(NewProblem(test.err))
+		if err != nil {
+			t.Errorf("Testcase %d: Unexpected Error: %s", i, err)
+		} else if string(j) != test.expected {
+			t.Errorf("Testcase %d: Unexpected result:\n%s\n expected:\n%s\n", i, j, test.expected)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	eventTypeLink    = "type"
+	eventRatingsLink = "ratings"
+)
+
+// Event is a coded occurence of a node, rated by values of ordinal and nominal scales and measured on interval scales.
+type Event struct {
+	Id       Id
+	Type     OptionalId
+	Ratings  RelationToMany
+	Values   Measurements
+	Revision int64 // Revision counts the changes of the event. It is read-only.
+}
+
+// Measurement is a value measured on an interval scale.
+type Measurement struct {
+	Scale Id      `json:"scale"`
+	Value float64 `json:"value"`
+}
+
+type Measurements []Measurement
+
+// SetId implements the Resource interface
+func (e *Event) SetId(id Id) {
+	e.Id = id
+}
+
+type eventMessage struct {
+	Id     *Id           `json:"id"`
+	Values *Measurements `json:"values"`
+	Links
+}
+
+func (e Event) MarshalJSON() ([]byte, error) {
+	if e.Values == nil {
+		e.Values = Measurements{}
+	}
+	mes := &eventMessage{&e.Id, &e.Values, Links{}}
+	mes.Links.AddOptional(eventTypeLink, e.Type)
+	mes.Links.AddToMany(eventRatingsLink, []Id(e.Ratings))
+	return json.Marshal(mes)
+}
+
+func (e *Event) UnmarshalJSON(data []byte) (err error) {
+	mes := &eventMessage{&e.Id, &e.Values, Links{}}
+	err = json.Unmarshal(data, mes)
+	if err == nil {
+		e.Type = mes.Links.GetToOneOptional(eventTypeLink)
+		e.Ratings = mes.Links.GetToMany(eventRatingsLink)
+		if e.Values == nil {
+			e.Values = Measurements{}
+		}
+	}
+	return
+}
+
+func (m *Measurements) Scan(src interface{}) error {
+	var j []byte
+	switch src := src.(type) {
+	case []byte:
+		j = src
+	case string:
+		j = []byte(src)
+	default:
+		return fmt.Errorf("Unsuported Typte %T for coding.Measurements", src)
+	}
+	*m = make([]Measurement, 0)
+	return json.Unmarshal(j, m)
+}
+
+// GetRevision implements the Revisioned interface
+func (e *Event) GetRevision() int64 {
+	return e.Revision
+}
+
+// GetId implements the Linker interface
+func (e *Event) GetId() Id {
+	return e.Id
+}
+
+// Linked implements the Linker interface. The ratings are values of scales and can't be followed.
+func (e *Event) Linked(link string) (collection string, ids []Id, ok bool) {
+	if link == eventTypeLink {
+		if e.Type.Valid {
+			ids = []Id{e.Type.Id}
+		}
+		return NodeCollection, ids, true
+	}
+	return
+}
+
+// Validate implements the Validator interface. Each scale is measured at most once.
+func (e *Event) Validate() error {
+	var v ValidationError
+	seen := make(map[Id]int)
+	for i, m := range e.Values {
+		if first, ok := seen[m.Scale]; ok {
+			v.Add(fmt.Sprintf("/values/%d/scale", i), "Scale %d is measured by value %d already.", m.Scale, first)
+		} else {
+			seen[m.Scale] = i
+		}
+	}
+	return v.Err()
+}
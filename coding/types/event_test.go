@@ -0,0 +1,45 @@
+package types
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestEventMarshalJSON(t *testing.T) {
+	tests := []struct {
+		e *Event
+		j string
+	}{
+		{&Event{3, OptionalId{7, true}, RelationToMany{Id(1), Id(4)}, Measurements{Measurement{2, -3.5}}, 0}, `{"id":3,"values":[{"scale":2,"value":-3.5}],"links":{"ratings":[1,4],"type":7}}`},
+		{&Event{3, OptionalId{}, RelationToMany{}, nil, 0}, `{"id":3,"values":[],"links":{"ratings":[],"type":null}}`},
+	}
+	for i, test := range tests {
+		j, err := json.Marshal(test.e)
+		if err != nil {
+			t.Errorf("Testcase %d: Unexpected Error: %s", i, err)
+		} else if string(j) != test.j {
+			t.Errorf("Testcase %d: Unexpected result:\n%s\n expected:\n%s\n", i, j, test.j)
+		}
+	}
+}
+
+func TestEventUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		e *Event
+		j string
+	}{
+		{&Event{3, OptionalId{7, true}, RelationToMany{Id(1), Id(4)}, Measurements{Measurement{2, -3.5}}, 0}, `{"id":3,"values":[{"scale":2,"value":-3.5}],"links":{"ratings":[1,4],"type":7}}`},
+		{&Event{3, OptionalId{}, RelationToMany{}, Measurements{}, 0}, `{"id":3,"values":null,"links":{"type":null}}`},
+		{&Event{0, OptionalId{}, RelationToMany{}, Measurements{}, 0}, `{}`},
+	}
+	for i, test := range tests {
+		e := new(Event)
+		err := json.Unmarshal([]byte(test.j), e)
+		if err != nil {
+			t.Errorf("Testcase %d: Unexpected Error: %s", i, err)
+		} else if !reflect.DeepEqual(e, test.e) {
+			t.Errorf("Testcase %d: Unexpected result:\n%+v\n expected:\n%+v\n", i, e, test.e)
+		}
+	}
+}
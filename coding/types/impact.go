@@ -0,0 +1,61 @@
+package types
+
+import (
+	"fmt"
+)
+
+// Row is a json encoded database row.
+type Row []byte
+
+func (r *Row) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case []byte:
+		*r = append(Row(nil), src...)
+	case string:
+		*r = Row(src)
+	default:
+		return fmt.Errorf("Unsuported Typte %T for coding.Row", src)
+	}
+	return nil
+}
+
+// MarshalJSON returns the json encoded row.
+func (r Row) MarshalJSON() ([]byte, error) {
+	if r == nil {
+		return []byte("null"), nil
+	}
+	return r, nil
+}
+
+// AffectedRow is a row affected by deleting a resource.
+type AffectedRow struct {
+	Kind string `json:"kind"` // Kind is the kind of the row, e.g. "nodes" or "links".
+	Row  Row    `json:"row"`  // Row contains the columns of the row.
+}
+
+// Impact lists all rows affected by deleting a resource.
+type Impact struct {
+	Deleted  []AffectedRow `json:"deleted"`  // Deleted are the rows of the resource itself.
+	Cascaded []AffectedRow `json:"cascaded"` // Cascaded are the rows of other resources deleted with the resource. Deleting them has to be requested explicitly.
+	Blocking []AffectedRow `json:"blocking"` // Blocking are the rows referencing the resource which prevent its deletion.
+}
+
+// NewImpact creates an empty Impact.
+func NewImpact() *Impact {
+	return &Impact{make([]AffectedRow, 0), make([]AffectedRow, 0), make([]AffectedRow, 0)}
+}
+
+// Add adds the row to the rows with the given effect. The effect is one of "deleted", "cascaded" or "blocking".
+func (i *Impact) Add(effect string, row AffectedRow) (err error) {
+	switch effect {
+	case "deleted":
+		i.Deleted = append(i.Deleted, row)
+	case "cascaded":
+		i.Cascaded = append(i.Cascaded, row)
+	case "blocking":
+		i.Blocking = append(i.Blocking, row)
+	default:
+		err = fmt.Errorf("Unknown effect %s of deletion.", effect)
+	}
+	return
+}
@@ -2,6 +2,7 @@ package types
 
 import (
 	"database/sql/driver"
+	"encoding/json"
 	"fmt"
 )
 
@@ -27,3 +28,41 @@ func (l *Label) Scan(src interface{}) error {
 func (l Label) Value() (driver.Value, error) {
 	return driver.Value(string(l)), nil
 }
+
+// Labels maps language tags like "en" or "de" to the label in that language.
+type Labels map[string]Label
+
+func (l *Labels) Scan(src interface{}) (err error) {
+	switch src := src.(type) {
+	case string:
+		err = json.Unmarshal([]byte(src), l)
+	case []byte:
+		err = json.Unmarshal(src, l)
+	default:
+		err = fmt.Errorf("Unsuported Typte %T for coding.Labels", src)
+	}
+	return
+}
+
+func (l Labels) Value() (driver.Value, error) {
+	if l == nil {
+		return driver.Value("{}"), nil
+	}
+	j, err := json.Marshal(map[string]Label(l))
+	return driver.Value(string(j)), err
+}
+
+// Localize returns the label in the first of the given languages available. If none is available def is returned.
+func (l Labels) Localize(def Label, langs []string) Label {
+	for _, lang := range langs {
+		if label, ok := l[lang]; ok {
+			return label
+		}
+	}
+	return def
+}
+
+// Localizer is implemented by resources with labels in several languages.
+type Localizer interface {
+	Localize(langs []string) // Localize sets the localized labels to the translation of the labels into the first of the given languages available. The labels themselves are kept. Without languages nothing is localized.
+}
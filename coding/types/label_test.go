@@ -0,0 +1,33 @@
+package types
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLabelsLocalize(t *testing.T) {
+	labels := Labels{"de": "Temperatur", "fr": "température"}
+	tests := []struct {
+		langs []string
+		l     Label
+	}{
+		{nil, "temperature"},
+		{[]string{"de"}, "Temperatur"},
+		{[]string{"la", "fr", "de"}, "température"},
+		{[]string{"la"}, "temperature"},
+	}
+	for i, test := range tests {
+		if l := labels.Localize("temperature", test.langs); l != test.l {
+			t.Errorf("Testcase %d: Unexpected result %s, expected %s", i, l, test.l)
+		}
+	}
+}
+
+func TestScaleLocalize(t *testing.T) {
+	s := &Scale{Label: "frequency", Labels: Labels{"de": "Häufigkeit"}, Values: Values{Value{1, "rare", Labels{"de": "selten"}, ""}, Value{2, "often", nil, ""}}}
+	s.Localize([]string{"de"})
+	expected := &Scale{Label: "frequency", Labels: Labels{"de": "Häufigkeit"}, Values: Values{Value{1, "rare", Labels{"de": "selten"}, "selten"}, Value{2, "often", nil, "often"}}, LocalizedLabel: "Häufigkeit"}
+	if !reflect.DeepEqual(s, expected) {
+		t.Errorf("Unexpected result:\n%+v\nexpected:\n%+v\n", s, expected)
+	}
+}
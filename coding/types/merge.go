@@ -0,0 +1,17 @@
+package types
+
+// NodeMerge describes the node another node is merged into.
+type NodeMerge struct {
+	Target Id `json:"target"` // Target is the node receiving the children, references, metrics and events.
+}
+
+// MergeReport lists everything moved from the source node to the target node of a merge.
+type MergeReport struct {
+	Source       Id             `json:"source"`       // Source is the id of the merged node. It redirects to the target from now on.
+	Target       Id             `json:"target"`       // Target is the id of the node the source has been merged into.
+	Children     RelationToMany `json:"children"`     // Children are the moved children of the source.
+	References   RelationToMany `json:"references"`   // References are the nodes referenced by the source which are now referenced by the target.
+	ReferencedBy RelationToMany `json:"referencedBy"` // ReferencedBy are the nodes referencing the source which now reference the target.
+	Metrics      RelationToMany `json:"metrics"`      // Metrics are the metrics of the source added to the target.
+	Events       RelationToMany `json:"events"`       // Events are the events of the type of the source which now have the target as type.
+}
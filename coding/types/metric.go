@@ -10,9 +10,13 @@ const (
 
 // Metric is a collection of scales suitable to measure the same thing
 type Metric struct {
-	Id     Id
-	Label  Label
-	Scales RelationToMany
+	Id       Id
+	Label    Label
+	Scales   RelationToMany
+	Labels   Labels // Labels contains the label in several languages.
+	Revision int64  // Revision counts the changes of the metric. It is read-only.
+	// LocalizedLabel is the label in the language negotiated for the response. It is read-only.
+	LocalizedLabel Label `db:"-"`
 }
 
 // SetId implements the Resource interface
@@ -21,22 +25,58 @@ func (m *Metric) SetId(id Id) {
 }
 
 type metricMessage struct {
-	Id    *Id    `json:"id"`
-	Label *Label `json:"label"`
+	Id             *Id    `json:"id"`
+	Label          *Label `json:"label"`
+	LocalizedLabel Label  `json:"localizedLabel,omitempty"`
+	Labels         Labels `json:"labels,omitempty"`
 	Links
 }
 
 func (m Metric) MarshalJSON() (j []byte, err error) {
-	mes := &metricMessage{Id: &m.Id, Label: &m.Label}
+	mes := &metricMessage{Id: &m.Id, Label: &m.Label, LocalizedLabel: m.LocalizedLabel, Labels: m.Labels}
 	mes.Links.AddToMany(metricScaleLink, []Id(m.Scales))
 	return json.Marshal(mes)
 }
 
 func (m *Metric) UnmarshalJSON(j []byte) (err error) {
-	mes := &metricMessage{&m.Id, &m.Label, Links{}}
+	mes := &metricMessage{Id: &m.Id, Label: &m.Label}
 	err = json.Unmarshal(j, mes)
 	if err == nil {
+		m.Labels = mes.Labels
 		m.Scales = mes.Links.GetToMany(metricScaleLink)
 	}
 	return
 }
+
+// Localize implements the Localizer interface
+func (m *Metric) Localize(langs []string) {
+	if len(langs) == 0 {
+		return
+	}
+	m.LocalizedLabel = m.Labels.Localize(m.Label, langs)
+}
+
+// GetRevision implements the Revisioned interface
+func (m *Metric) GetRevision() int64 {
+	return m.Revision
+}
+
+// GetId implements the Linker interface
+func (m *Metric) GetId() Id {
+	return m.Id
+}
+
+// Linked implements the Linker interface
+func (m *Metric) Linked(link string) (collection string, ids []Id, ok bool) {
+	if link == metricScaleLink {
+		return ScaleCollection, m.Scales, true
+	}
+	return
+}
+
+// Validate implements the Validator interface. The metric needs a label.
+func (m *Metric) Validate() error {
+	var v ValidationError
+	v.validateLabel("", m.Label, m.Labels)
+	return v.Err()
+}
@@ -11,8 +11,9 @@ func TestMetricMarshalJSON(t *testing.T) {
 		m *Metric
 		j string
 	}{
-		{&Metric{4, "metric", RelationToMany{Id(1), Id(4), Id(5)}}, `{"id":4,"label":"metric","links":{"scales":[1,4,5]}}`},
-		{&Metric{4, "metric", RelationToMany{}}, `{"id":4,"label":"metric","links":{"scales":[]}}`},
+		{&Metric{4, "metric", RelationToMany{Id(1), Id(4), Id(5)}, nil, 0, ""}, `{"id":4,"label":"metric","links":{"scales":[1,4,5]}}`},
+		{&Metric{4, "metric", RelationToMany{}, nil, 0, ""}, `{"id":4,"label":"metric","links":{"scales":[]}}`},
+		{&Metric{4, "metric", RelationToMany{}, Labels{"de": "Metrik", "en": "metric"}, 0, ""}, `{"id":4,"label":"metric","labels":{"de":"Metrik","en":"metric"},"links":{"scales":[]}}`},
 	}
 	for i, test := range tests {
 		j, err := json.Marshal(test.m)
@@ -29,9 +30,10 @@ func TestMetricUnmarshalJSON(t *testing.T) {
 		m *Metric
 		j string
 	}{
-		{&Metric{4, "metric", RelationToMany{Id(1), Id(4), Id(5)}}, `{"id":4,"label":"metric","links":{"scales":[1,4,5]}}`},
-		{&Metric{4, "metric", RelationToMany{}}, `{"id":4,"label":"metric","links":{"scales":[]}}`},
-		{&Metric{0, "", RelationToMany{}}, `{}`},
+		{&Metric{4, "metric", RelationToMany{Id(1), Id(4), Id(5)}, nil, 0, ""}, `{"id":4,"label":"metric","links":{"scales":[1,4,5]}}`},
+		{&Metric{4, "metric", RelationToMany{}, nil, 0, ""}, `{"id":4,"label":"metric","links":{"scales":[]}}`},
+		{&Metric{0, "", RelationToMany{}, nil, 0, ""}, `{}`},
+		{&Metric{4, "metric", RelationToMany{}, Labels{"de": "Metrik"}, 0, ""}, `{"id":4,"label":"metric","labels":{"de":"Metrik"}}`},
 	}
 	for i, test := range tests {
 		m := new(Metric)
@@ -2,6 +2,7 @@ package types
 
 import (
 	"encoding/json"
+	"strings"
 )
 
 const (
@@ -9,41 +10,68 @@ const (
 	nodeChildrenLink   = "children"
 	nodeReferencesLink = "references"
 	nodeMetricsLink    = "metrics"
+	nodeEffectiveLink  = "effectiveMetrics"
+	// nodeTypedReferencesPrefix prefixes the name of the link type for references grouped by type.
+	nodeTypedReferencesPrefix = nodeReferencesLink + ":"
 )
 
 // Represents a node in the nominal value hierarchy
 type Node struct {
-	Id         Id
-	Label      Label
-	Parent     OptionalId
-	Children   RelationToMany
-	References RelationToMany
-	Metrics    RelationToMany
+	Id               Id
+	Label            Label
+	Parent           OptionalId
+	Children         RelationToMany
+	References       RelationToMany
+	Metrics          RelationToMany
+	InheritMetrics   bool           `db:"inherit"`           // InheritMetrics is true if the node inherits the metrics of its ancestors.
+	EffectiveMetrics RelationToMany `db:"effective_metrics"` // EffectiveMetrics are the node's own and inherited metrics. They are read-only.
+	Path             Path           // Path lists the ancestors of the node if they have been requested. It is read-only.
+	TypedReferences  TypedRelations `db:"typed_references"` // TypedReferences groups the references by the type of their link unless it is the default type. References not contained in any group keep their type.
+	Labels           Labels         // Labels contains the label in several languages.
+	Revision         int64          // Revision counts the changes of the node. It is read-only.
+	LocalizedLabel   Label          `db:"-"` // LocalizedLabel is the label in the language negotiated for the response. It is read-only.
 }
 
 type nodeMessage struct {
-	Id    *Id    `json:"id"`
-	Label *Label `json:"label"`
+	Id             *Id    `json:"id"`
+	Label          *Label `json:"label"`
+	LocalizedLabel Label  `json:"localizedLabel,omitempty"`
+	Labels         Labels `json:"labels,omitempty"`
+	InheritMetrics *bool  `json:"inheritMetrics"`
+	Path           Path   `json:"path,omitempty"`
 	Links
 }
 
 func (n Node) MarshalJSON() ([]byte, error) {
-	mes := &nodeMessage{&n.Id, &n.Label, Links{}}
+	mes := &nodeMessage{&n.Id, &n.Label, n.LocalizedLabel, n.Labels, &n.InheritMetrics, n.Path, Links{}}
 	mes.Links.AddOptional(nodeParentLink, n.Parent)
 	mes.Links.AddToMany(nodeChildrenLink, []Id(n.Children))
 	mes.Links.AddToMany(nodeReferencesLink, []Id(n.References))
 	mes.Links.AddToMany(nodeMetricsLink, []Id(n.Metrics))
+	mes.Links.AddToMany(nodeEffectiveLink, []Id(n.EffectiveMetrics))
+	for t, ids := range n.TypedReferences {
+		mes.Links.AddToMany(nodeTypedReferencesPrefix+t, []Id(ids))
+	}
 	return json.Marshal(mes)
 }
 
+// UnmarshalJSON implements json.Unmarshaler. Nodes inherit metrics unless "inheritMetrics" is false. References grouped by type are read from the links prefixed with "references:". The effective metrics, the path and the localized label are ignored.
 func (n *Node) UnmarshalJSON(data []byte) (err error) {
-	mes := &nodeMessage{&n.Id, &n.Label, Links{}}
+	n.InheritMetrics = true
+	mes := &nodeMessage{&n.Id, &n.Label, "", nil, &n.InheritMetrics, nil, Links{}}
 	err = json.Unmarshal(data, mes)
 	if err == nil {
 		n.Parent = mes.Links.GetToOneOptional(nodeParentLink)
+		n.Labels = mes.Labels
 		n.Children = mes.Links.GetToMany(nodeChildrenLink)
 		n.References = mes.Links.GetToMany(nodeReferencesLink)
 		n.Metrics = mes.Links.GetToMany(nodeMetricsLink)
+		n.TypedReferences = make(TypedRelations)
+		for name := range mes.Links.Links {
+			if strings.HasPrefix(name, nodeTypedReferencesPrefix) {
+				n.TypedReferences[name[len(nodeTypedReferencesPrefix):]] = mes.Links.GetToMany(name)
+			}
+		}
 	}
 	return
 }
@@ -51,3 +79,55 @@ func (n *Node) UnmarshalJSON(data []byte) (err error) {
 func (n *Node) SetId(id Id) {
 	n.Id = id
 }
+
+// Localize implements the Localizer interface for the label of the node and its path.
+func (n *Node) Localize(langs []string) {
+	if len(langs) == 0 {
+		return
+	}
+	n.LocalizedLabel = n.Labels.Localize(n.Label, langs)
+	n.Path.Localize(langs)
+}
+
+// GetRevision implements the Revisioned interface
+func (n *Node) GetRevision() int64 {
+	return n.Revision
+}
+
+// GetId implements the Linker interface
+func (n *Node) GetId() Id {
+	return n.Id
+}
+
+// Linked implements the Linker interface. The effective metrics can be followed as well as the references of a single type.
+func (n *Node) Linked(link string) (collection string, ids []Id, ok bool) {
+	switch link {
+	case nodeParentLink:
+		if n.Parent.Valid {
+			ids = []Id{n.Parent.Id}
+		}
+		return NodeCollection, ids, true
+	case nodeChildrenLink:
+		return NodeCollection, n.Children, true
+	case nodeReferencesLink:
+		return NodeCollection, n.References, true
+	case nodeMetricsLink:
+		return MetricCollection, n.Metrics, true
+	case nodeEffectiveLink:
+		return MetricCollection, n.EffectiveMetrics, true
+	}
+	if strings.HasPrefix(link, nodeTypedReferencesPrefix) {
+		return NodeCollection, n.TypedReferences[link[len(nodeTypedReferencesPrefix):]], true
+	}
+	return
+}
+
+// Validate implements the Validator interface. The node needs a label and can't be its own parent.
+func (n *Node) Validate() error {
+	var v ValidationError
+	v.validateLabel("", n.Label, n.Labels)
+	if n.Id != 0 && n.Parent.Valid && n.Parent.Id == n.Id {
+		v.Add("/links/parent", "The node can't be its own parent.")
+	}
+	return v.Err()
+}
@@ -1 +1,57 @@
 package types
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestNodeMarshalJSON(t *testing.T) {
+	tests := []struct {
+		n *Node
+		j string
+	}{
+		{&Node{1, "node", OptionalId{2, true}, RelationToMany{3}, RelationToMany{}, RelationToMany{4}, true, RelationToMany{4, 5}, nil, nil, nil, 0, ""}, `{"id":1,"label":"node","inheritMetrics":true,"links":{"children":[3],"effectiveMetrics":[4,5],"metrics":[4],"parent":2,"references":[]}}`},
+		{&Node{1, "root", OptionalId{}, RelationToMany{}, RelationToMany{}, RelationToMany{}, false, RelationToMany{}, nil, nil, nil, 0, ""}, `{"id":1,"label":"root","inheritMetrics":false,"links":{"children":[],"effectiveMetrics":[],"metrics":[],"parent":null,"references":[]}}`},
+		{&Node{3, "leaf", OptionalId{2, true}, RelationToMany{}, RelationToMany{}, RelationToMany{}, true, RelationToMany{}, Path{PathElement{1, "root", nil, ""}, PathElement{2, "node", nil, ""}}, nil, nil, 0, ""}, `{"id":3,"label":"leaf","inheritMetrics":true,"path":[{"id":1,"label":"root"},{"id":2,"label":"node"}],"links":{"children":[],"effectiveMetrics":[],"metrics":[],"parent":2,"references":[]}}`},
+		{&Node{4, "typed", OptionalId{}, RelationToMany{}, RelationToMany{5, 6}, RelationToMany{}, true, RelationToMany{}, nil, TypedRelations{"related": RelationToMany{6}, "synonym": RelationToMany{5}}, nil, 0, ""}, `{"id":4,"label":"typed","inheritMetrics":true,"links":{"children":[],"effectiveMetrics":[],"metrics":[],"parent":null,"references":[5,6],"references:related":[6],"references:synonym":[5]}}`},
+	}
+	for i, test := range tests {
+		j, err := json.Marshal(test.n)
+		if err != nil {
+			t.Errorf("Testcase %d: Unexpected Error: %s", i, err)
+		} else if string(j) != test.j {
+			t.Errorf("Testcase %d: Unexpected result:\n%s\n expected:\n%s\n", i, j, test.j)
+		}
+	}
+}
+
+func TestNodeUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		n *Node
+		j string
+	}{
+		{&Node{1, "node", OptionalId{2, true}, RelationToMany{3}, RelationToMany{}, RelationToMany{4}, false, nil, nil, TypedRelations{}, nil, 0, ""}, `{"id":1,"label":"node","inheritMetrics":false,"links":{"children":[3],"effectiveMetrics":[4,5],"metrics":[4],"parent":2}}`},
+		{&Node{0, "", OptionalId{}, RelationToMany{}, RelationToMany{}, RelationToMany{}, true, nil, nil, TypedRelations{}, nil, 0, ""}, `{}`},
+		{&Node{4, "typed", OptionalId{}, RelationToMany{}, RelationToMany{5}, RelationToMany{}, true, nil, nil, TypedRelations{"seeAlso": RelationToMany{6}}, nil, 0, ""}, `{"id":4,"label":"typed","links":{"references":[5],"references:seeAlso":[6]}}`},
+	}
+	for i, test := range tests {
+		n := new(Node)
+		err := json.Unmarshal([]byte(test.j), n)
+		if err != nil {
+			t.Errorf("Testcase %d: Unexpected Error: %s", i, err)
+		} else if !reflect.DeepEqual(n, test.n) {
+			t.Errorf("Testcase %d: Unexpected result:\n%+v\n expected:\n%+v\n", i, n, test.n)
+		}
+	}
+}
+
+func TestValidateNode(t *testing.T) {
+	if err := (&Node{Id: 1, Label: "node", Parent: OptionalId{2, true}}).Validate(); err != nil {
+		t.Errorf("Unexpected Error: %s", err)
+	}
+	expected := ValidationError{{"/label", "The label must not be empty."}, {"/links/parent", "The node can't be its own parent."}}
+	if err := (&Node{Id: 1, Parent: OptionalId{1, true}}).Validate(); !reflect.DeepEqual(err, expected) {
+		t.Errorf("Unexpected Result:\n%#v\nexpected:\n%#v\n", err, expected)
+	}
+}
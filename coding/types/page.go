@@ -0,0 +1,51 @@
+package types
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Page selects a part of the resources satisfying a query.
+type Page struct {
+	Limit  int      // Limit is the maximum number of resources in the page. It is negative if the page is not limited.
+	Offset int      // Offset is the number of resources skipped before the page.
+	Sort   []string // Sort are the fields the resources are ordered by. Fields prefixed with "-" are ordered descending.
+}
+
+// NewPage decodes the page requested by the query parameters "limit", "offset" and "sort". Sort is a comma separated list of fields.
+func NewPage(q map[string][]string) (p Page, err error) {
+	p.Limit, err = pageParameter(q, "limit", -1)
+	if err != nil {
+		return
+	}
+	p.Offset, err = pageParameter(q, "offset", 0)
+	if err != nil {
+		return
+	}
+	for _, v := range q["sort"] {
+		for _, field := range strings.Split(v, ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				p.Sort = append(p.Sort, field)
+			}
+		}
+	}
+	return
+}
+
+// Limited returns whether the page is only a part of the resources satisfying the query.
+func (p Page) Limited() bool {
+	return p.Limit >= 0 || p.Offset > 0
+}
+
+func pageParameter(q map[string][]string, name string, def int) (i int, err error) {
+	if len(q[name]) == 0 || q[name][0] == "" {
+		return def, nil
+	}
+	i, e := strconv.Atoi(q[name][0])
+	if e != nil || i < 0 {
+		err = NewHttpError(http.StatusBadRequest, errors.New("Parameter "+name+" has to be a non-negative integer."))
+	}
+	return
+}
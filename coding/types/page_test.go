@@ -0,0 +1,32 @@
+package types
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewPage(t *testing.T) {
+	tests := []struct {
+		q   map[string][]string
+		p   Page
+		err bool
+	}{
+		{map[string][]string{}, Page{-1, 0, nil}, false},
+		{map[string][]string{"limit": []string{"10"}, "offset": []string{"20"}}, Page{10, 20, nil}, false},
+		{map[string][]string{"sort": []string{"label, -id", "type"}}, Page{-1, 0, []string{"label", "-id", "type"}}, false},
+		{map[string][]string{"limit": []string{"ten"}}, Page{}, true},
+		{map[string][]string{"offset": []string{"-1"}}, Page{}, true},
+	}
+	for i, test := range tests {
+		p, err := NewPage(test.q)
+		if test.err {
+			if err == nil {
+				t.Errorf("Testcase %d: Expected error, but got %+v", i, p)
+			}
+		} else if err != nil {
+			t.Errorf("Testcase %d: Unexpected Error: %s", i, err)
+		} else if !reflect.DeepEqual(p, test.p) {
+			t.Errorf("Testcase %d: Unexpected result:\n%+v\nexpected:\n%+v\n", i, p, test.p)
+		}
+	}
+}
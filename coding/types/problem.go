@@ -0,0 +1,60 @@
+package types
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// MediaProblem is the media type of problem details.
+const MediaProblem = "application/problem+json"
+
+// Problem describes an error in the body of a response as problem details (RFC 7807). The type of problem is identified by the stable Code instead of a type URI.
+type Problem struct {
+	Title   string                 // Title is the text of the status.
+	Status  int                    // Status is the HTTP status code of the response.
+	Code    string                 // Code identifies the kind of problem, like "not_found".
+	Detail  string                 // Detail explains this occurrence of the problem.
+	Members map[string]interface{} // Members are additional members describing the problem, like the impact of a blocked deletion.
+}
+
+// ProblemExtender is implemented by errors adding members to their problem details.
+type ProblemExtender interface {
+	ProblemMembers() map[string]interface{} // ProblemMembers returns the additional members of the problem details.
+}
+
+// NewProblem describes err as problem details. HttpErrors keep their status and their message is the detail. Other errors are internal server errors whose message is not disclosed.
+func NewProblem(err error) Problem {
+	h, ok := err.(HttpError)
+	if !ok {
+		return Problem{Title: http.StatusText(http.StatusInternalServerError), Status: http.StatusInternalServerError, Code: statusCode(http.StatusInternalServerError), Detail: "An unexpected error occurred."}
+	}
+	p := Problem{Title: http.StatusText(h.Status()), Status: h.Status(), Code: statusCode(h.Status()), Detail: h.Error()}
+	if c, ok := h.(CodedError); ok {
+		p.Code = c.Code()
+	}
+	if e, ok := h.(ProblemExtender); ok {
+		p.Members = e.ProblemMembers()
+	}
+	return p
+}
+
+// MarshalJSON marshals the problem as JSON object with the members "title", "status", "code" and "detail" followed by the additional members.
+func (p Problem) MarshalJSON() ([]byte, error) {
+	m := map[string]interface{}{"title": p.Title, "status": p.Status, "code": p.Code, "detail": p.Detail}
+	for name, value := range p.Members {
+		if _, reserved := m[name]; !reserved {
+			m[name] = value
+		}
+	}
+	return json.Marshal(m)
+}
+
+// statusCode derives a code from the text of the status, like "not_found" for 404 Not Found.
+func statusCode(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return "error"
+	}
+	return strings.Replace(strings.ToLower(strings.Replace(text, "-", " ", -1)), " ", "_", -1)
+}
@@ -107,3 +107,17 @@ func (r *Relation) UnmarshalJSON(data []byte) (err error) {
 	}
 	return
 }
+
+// Names of the collections of resources. Links point to resources of one collection, the api serves every collection at the endpoint of the same name.
+const (
+	NodeCollection   = "nodes"
+	ScaleCollection  = "scales"
+	MetricCollection = "metrics"
+	EventCollection  = "events"
+)
+
+// Linker is implemented by resources whose links can be followed to include the linked resources in a response.
+type Linker interface {
+	GetId() Id                                                 // GetId gets the id of the resource.
+	Linked(link string) (collection string, ids []Id, ok bool) // Linked gets the collection and the ids of the resources the link with the given name points to. It is not ok if there is no such link.
+}
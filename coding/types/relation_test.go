@@ -63,3 +63,28 @@ func TestUnmarshalJSON(t *testing.T) {
 func ptrId(i Id) *Id {
 	return &i
 }
+
+func TestLinked(t *testing.T) {
+	n := &Node{Id: 1, Parent: OptionalId{2, true}, Metrics: RelationToMany{3}, TypedReferences: TypedRelations{"seeAlso": RelationToMany{4}}}
+	tests := []struct {
+		res        Linker
+		link       string
+		collection string
+		ids        []Id
+		ok         bool
+	}{
+		{n, "parent", NodeCollection, []Id{2}, true},
+		{n, "metrics", MetricCollection, []Id{3}, true},
+		{n, "references:seeAlso", NodeCollection, []Id{4}, true},
+		{n, "scales", "", nil, false},
+		{&Metric{Scales: RelationToMany{5}}, "scales", ScaleCollection, []Id{5}, true},
+		{&Event{}, "type", NodeCollection, nil, true},
+		{&Event{}, "ratings", "", nil, false},
+	}
+	for i, test := range tests {
+		collection, ids, ok := test.res.Linked(test.link)
+		if collection != test.collection || !reflect.DeepEqual(ids, test.ids) || ok != test.ok {
+			t.Errorf("Testcase %d: Got unexpected link to %s %v (%t), was expecting %s %v (%t)\n", i, collection, ids, ok, test.collection, test.ids, test.ok)
+		}
+	}
+}
@@ -11,12 +11,30 @@ type ResourceReader interface {
 	Close() (err error)                   // Close closes the Reader if further Resources are not needed.
 }
 
+// CountingReader is a ResourceReader which can count all resources satisfying the query regardless of the page being read.
+type CountingReader interface {
+	ResourceReader
+	Total() (total int, err error) // Total counts the resources satisfying the query. It fails with the error of the query, if any.
+}
+
 // ResourceController provides a persistent backend to perform CRUD operations for one Resource. It's intended to be used only with the Resource returned by that Controller (e.g. using the New method). Providing other Resources will usually result in an error.
 type ResourceController interface {
 	New() (r Resource)                                // New gets an new unsafed Resource.
-	Query(q map[string][]string) (res ResourceReader) // Query gets a Reader to retrieve all Resources satisfying the query.
+	Query(q map[string][]string) (res ResourceReader) // Query gets a Reader to retrieve all Resources satisfying the query or the Page of them requested by it.
 	Create(r Resource) (err error)                    // Create strores a the given Resource persistently.
 	Read(id Id) (r Resource, err error)               // Read reads the Resource with the given ID
 	Update(r Resource) (err error)                    // Update updates the given resource.
-	Delete(id Id) (err error)                         // Deletes the resource with the given ID.
+	Delete(id Id, cascade bool) (err error)           // Deletes the resource with the given ID. Rows of other resources are only deleted with it if cascade is true.
+	Impact(id Id) (impact *Impact, err error)         // Impact lists the rows affected by deleting the resource with the given ID without deleting anything.
+}
+
+// NodeController provides access to the node hierarchy in addition to the CRUD operations of a ResourceController for Nodes.
+type NodeController interface {
+	ResourceController
+	Tree(id Id, depth int, include []string) (res ResourceReader)     // Tree gets a Reader to retrieve the node with the given id and its descendants as TreeNodes, parents before their children. Descendants deeper than depth are omitted unless depth is negative. Links to "metrics" and "references" are only retrieved if included.
+	Ancestors(id Id) (path Path, err error)                           // Ancestors gets the ancestors of the node with the given id starting at the root.
+	Move(id Id, parent OptionalId, position int) (n *Node, err error) // Move moves the node with the given id below parent at the given position among its siblings. A negative position appends the node. Moving a node below itself or its descendants is a conflict.
+	Reorder(id Id, children RelationToMany) (n *Node, err error)      // Reorder orders the children of the node with the given id like children. Children not listed keep their relative order after the listed ones.
+	Merge(id, target Id) (report *MergeReport, err error)             // Merge moves the children, references, metrics and events of the node with the given id to target and deletes it. The id redirects to target afterwards.
+	LinkTypes() (linkTypes []LinkType, err error)                     // LinkTypes gets the vocabulary of types for references between nodes.
 }
@@ -0,0 +1,23 @@
+package types
+
+import (
+	"context"
+)
+
+// Revisioned is implemented by resources which count their changes. The revision of a resource increases with every change made to it.
+type Revisioned interface {
+	GetRevision() int64 // GetRevision gets the revision the resource has been read at.
+}
+
+type requiredRevisionKey struct{}
+
+// RequireRevision returns a context requiring changes made with it to be based on the given revision of the changed resource. Changes of a resource at another revision fail with status 412 Precondition Failed.
+func RequireRevision(ctx context.Context, revision int64) context.Context {
+	return context.WithValue(ctx, requiredRevisionKey{}, revision)
+}
+
+// RequiredRevision returns the revision required by ctx. It is not ok if any revision may be changed.
+func RequiredRevision(ctx context.Context) (revision int64, ok bool) {
+	revision, ok = ctx.Value(requiredRevisionKey{}).(int64)
+	return
+}
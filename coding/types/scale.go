@@ -20,7 +20,11 @@ type Scale struct {
 	Label Label     `json:"label"`
 	Type  ScaleType `json:"type"`
 	*UnitDesc
-	Values Values `json:"values"`
+	Values   Values `json:"values"`
+	Labels   Labels `json:"labels,omitempty"`
+	Revision int64  `json:"-"` // Revision counts the changes of the scale. It is read-only.
+	// LocalizedLabel is the label in the language negotiated for the response. It is read-only.
+	LocalizedLabel Label `json:"localizedLabel,omitempty" db:"-"`
 }
 
 type UnitDesc struct {
@@ -30,8 +34,10 @@ type UnitDesc struct {
 }
 
 type Value struct {
-	Id    Id    `json:"id"`
-	Label Label `json:"label"`
+	Id             Id     `json:"id"`
+	Label          Label  `json:"label"`
+	Labels         Labels `json:"labels,omitempty"`
+	LocalizedLabel Label  `json:"localizedLabel,omitempty"` // LocalizedLabel is the label in the language negotiated for the response. It is read-only.
 }
 
 type Values []Value
@@ -41,6 +47,17 @@ func (s *Scale) SetId(id Id) {
 	s.Id = id
 }
 
+// Localize implements the Localizer interface for the label of the scale and the labels of its values.
+func (s *Scale) Localize(langs []string) {
+	if len(langs) == 0 {
+		return
+	}
+	s.LocalizedLabel = s.Labels.Localize(s.Label, langs)
+	for i := range s.Values {
+		s.Values[i].LocalizedLabel = s.Values[i].Labels.Localize(s.Values[i].Label, langs)
+	}
+}
+
 func (v *Values) Scan(src interface{}) error {
 	var j []byte
 	switch src := src.(type) {
@@ -55,6 +72,7 @@ func (v *Values) Scan(src interface{}) error {
 		*v = make([]Value, 0)
 		return nil
 	}
+	*v = nil
 	return json.Unmarshal(j, v)
 }
 
@@ -73,3 +91,43 @@ func (v *ScaleType) Scan(src interface{}) error {
 func (t ScaleType) Value() (driver.Value, error) {
 	return driver.Value(string(t)), nil
 }
+
+// GetRevision implements the Revisioned interface
+func (s *Scale) GetRevision() int64 {
+	return s.Revision
+}
+
+// GetId implements the Linker interface
+func (s *Scale) GetId() Id {
+	return s.Id
+}
+
+// Linked implements the Linker interface. Scales don't link to other resources, their values are contained in them.
+func (s *Scale) Linked(link string) (collection string, ids []Id, ok bool) {
+	return
+}
+
+// Validate implements the Validator interface. The scale needs a label and a known type. The minimum of the unit must not exceed its maximum and values need distinct labels.
+func (s *Scale) Validate() error {
+	var v ValidationError
+	v.validateLabel("", s.Label, s.Labels)
+	switch s.Type {
+	case ScaleInterval, ScaleOrdinal, ScaleNominal:
+	default:
+		v.Add("/type", "Unknown scale type %q, expected %q, %q or %q.", s.Type, ScaleInterval, ScaleOrdinal, ScaleNominal)
+	}
+	if s.UnitDesc != nil && s.Min.Valid && s.Max.Valid && s.Min.Float64 > s.Max.Float64 {
+		v.Add("/max", "The maximum %g is less than the minimum %g.", s.Max.Float64, s.Min.Float64)
+	}
+	seen := make(map[Label]int)
+	for i, value := range s.Values {
+		prefix := fmt.Sprintf("/values/%d", i)
+		v.validateLabel(prefix, value.Label, value.Labels)
+		if first, ok := seen[value.Label]; ok {
+			v.Add(prefix+"/label", "The label %q is used by value %d already.", value.Label, first)
+		} else {
+			seen[value.Label] = i
+		}
+	}
+	return v.Err()
+}
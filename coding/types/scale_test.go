@@ -11,8 +11,8 @@ func TestUnmarshalJSONScale(t *testing.T) {
 		j []byte
 		s Scale
 	}{
-		{[]byte(`{"id":12,"label":"scale","type":"interval","unit":"˚C","min":-273.15,"max":null}`), Scale{12, "scale", "interval", &UnitDesc{"˚C", JsonNullFloat64{-273.15, true}, JsonNullFloat64{0, false}}, nil}},
-		{[]byte(`{"id":12,"label":"scale","type":"ordinal","values":[{"id":2,"label":"No1"},{"id":5,"label":"No2"},{"label":"New"}]}`), Scale{12, "scale", "ordinal", nil, Values{Value{2, "No1"}, Value{5, "No2"}, Value{Label: "New"}}}},
+		{[]byte(`{"id":12,"label":"scale","type":"interval","unit":"˚C","min":-273.15,"max":null}`), Scale{12, "scale", "interval", &UnitDesc{"˚C", JsonNullFloat64{-273.15, true}, JsonNullFloat64{0, false}}, nil, nil, 0, ""}},
+		{[]byte(`{"id":12,"label":"scale","type":"ordinal","values":[{"id":2,"label":"No1"},{"id":5,"label":"No2"},{"label":"New"}]}`), Scale{12, "scale", "ordinal", nil, Values{Value{2, "No1", nil, ""}, Value{5, "No2", nil, ""}, Value{Label: "New"}}, nil, 0, ""}},
 	}
 	for i, test := range tests {
 		s := Scale{}
@@ -30,8 +30,8 @@ func TestMarshalJSONScale(t *testing.T) {
 		j []byte
 		s Scale
 	}{
-		{[]byte(`{"id":12,"label":"scale","type":"interval","unit":"˚C","min":-273.15,"max":null,"values":null}`), Scale{12, "scale", "interval", &UnitDesc{"˚C", JsonNullFloat64{-273.15, true}, JsonNullFloat64{0, false}}, nil}},
-		{[]byte(`{"id":12,"label":"scale","type":"ordinal","values":[{"id":2,"label":"No1"},{"id":5,"label":"No2"},{"id":0,"label":"New"}]}`), Scale{12, "scale", "ordinal", nil, Values{Value{2, "No1"}, Value{5, "No2"}, Value{Label: "New"}}}},
+		{[]byte(`{"id":12,"label":"scale","type":"interval","unit":"˚C","min":-273.15,"max":null,"values":null}`), Scale{12, "scale", "interval", &UnitDesc{"˚C", JsonNullFloat64{-273.15, true}, JsonNullFloat64{0, false}}, nil, nil, 0, ""}},
+		{[]byte(`{"id":12,"label":"scale","type":"ordinal","values":[{"id":2,"label":"No1"},{"id":5,"label":"No2"},{"id":0,"label":"New"}]}`), Scale{12, "scale", "ordinal", nil, Values{Value{2, "No1", nil, ""}, Value{5, "No2", nil, ""}, Value{Label: "New"}}, nil, 0, ""}},
 	}
 	for i, test := range tests {
 		j, e := json.Marshal(test.s)
@@ -46,3 +46,24 @@ func TestMarshalJSONScale(t *testing.T) {
 func ptrToFloat(f float64) *float64 {
 	return &f
 }
+
+func TestValidateScale(t *testing.T) {
+	tests := []struct {
+		s        Scale
+		expected ValidationError
+	}{
+		{Scale{Label: "scale", Type: ScaleOrdinal, Values: Values{{Label: "low"}, {Label: "high"}}}, nil},
+		{Scale{Label: "scale", Type: ScaleInterval, UnitDesc: &UnitDesc{"˚C", JsonNullFloat64{-273.15, true}, JsonNullFloat64{0, false}}}, nil},
+		{Scale{Label: " ", Type: "ratio", Labels: Labels{"de": ""}}, ValidationError{{"/label", "The label must not be empty."}, {"/labels/de", "The label in de must not be empty."}, {"/type", `Unknown scale type "ratio", expected "interval", "ordinal" or "nominal".`}}},
+		{Scale{Label: "scale", Type: ScaleInterval, UnitDesc: &UnitDesc{"m", JsonNullFloat64{5, true}, JsonNullFloat64{1, true}}}, ValidationError{{"/max", "The maximum 1 is less than the minimum 5."}}},
+		{Scale{Label: "scale", Type: ScaleNominal, Values: Values{{Label: "a"}, {Label: ""}, {Label: "a"}}}, ValidationError{{"/values/1/label", "The label must not be empty."}, {"/values/2/label", `The label "a" is used by value 0 already.`}}},
+	}
+	for i, test := range tests {
+		err := test.s.Validate()
+		if test.expected == nil && err != nil {
+			t.Errorf("Testcase %d: Unexpected Error: %s", i, err)
+		} else if test.expected != nil && !reflect.DeepEqual(err, test.expected) {
+			t.Errorf("Testcase %d: Unexpected Result:\n%#v\nexpected:\n%#v\n", i, err, test.expected)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// TreeNode is a node within a subtree of the node hierarchy. Links to references and metrics are only present if they have been requested.
+type TreeNode struct {
+	Node
+	Depth   int         `db:"depth"` // Depth is the distance to the root of the subtree.
+	Subtree []*TreeNode `db:"-"`     // Subtree contains the descendants if the tree is nested.
+}
+
+type treeNodeMessage struct {
+	nodeMessage
+	Depth   int         `json:"depth"`
+	Subtree []*TreeNode `json:"subtree,omitempty"`
+}
+
+// MarshalJSON marshals the node omitting all links which have not been retrieved.
+func (t TreeNode) MarshalJSON() ([]byte, error) {
+	mes := &treeNodeMessage{nodeMessage{&t.Id, &t.Label, t.LocalizedLabel, t.Labels, &t.InheritMetrics, nil, Links{}}, t.Depth, t.Subtree}
+	mes.Links.AddOptional(nodeParentLink, t.Parent)
+	mes.Links.AddToMany(nodeChildrenLink, []Id(t.Children))
+	if t.References != nil {
+		mes.Links.AddToMany(nodeReferencesLink, []Id(t.References))
+	}
+	if t.Metrics != nil {
+		mes.Links.AddToMany(nodeMetricsLink, []Id(t.Metrics))
+	}
+	return json.Marshal(mes)
+}
+
+// NestTree nests the given nodes in the Subtree of their parent. The nodes have to be ordered parents first, the first node being the root. It returns the root.
+func NestTree(nodes []*TreeNode) (root *TreeNode) {
+	if len(nodes) == 0 {
+		return
+	}
+	root = nodes[0]
+	byId := map[Id]*TreeNode{root.Id: root}
+	for _, n := range nodes[1:] {
+		byId[n.Id] = n
+		if p, ok := byId[n.Parent.Id]; ok && n.Parent.Valid {
+			p.Subtree = append(p.Subtree, n)
+		}
+	}
+	return
+}
+
+// NodeMove describes the new location of a node within the hierarchy.
+type NodeMove struct {
+	Parent   OptionalId `json:"parent"`   // Parent is the new parent of the node. If not valid the node becomes a root.
+	Position *int       `json:"position"` // Position is the new position among the children of parent. If nil the node is appended.
+}
+
+// PathElement identifies an ancestor of a node.
+type PathElement struct {
+	Id             Id     `json:"id"`
+	Label          Label  `json:"label"`
+	Labels         Labels `json:"labels,omitempty"`
+	LocalizedLabel Label  `json:"localizedLabel,omitempty"` // LocalizedLabel is the label in the language negotiated for the response.
+}
+
+// Path is the list of ancestors of a node starting at the root.
+type Path []PathElement
+
+func (p *Path) Scan(src interface{}) error {
+	var j []byte
+	switch src := src.(type) {
+	case []byte:
+		j = src
+	case string:
+		j = []byte(src)
+	default:
+		return fmt.Errorf("Unsuported Typte %T for coding.Path", src)
+	}
+	*p = make([]PathElement, 0)
+	return json.Unmarshal(j, p)
+}
+
+// Localize implements the Localizer interface for the labels of all ancestors.
+func (p Path) Localize(langs []string) {
+	if len(langs) == 0 {
+		return
+	}
+	for i := range p {
+		p[i].LocalizedLabel = p[i].Labels.Localize(p[i].Label, langs)
+	}
+}
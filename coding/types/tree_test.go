@@ -0,0 +1,28 @@
+package types
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestNestTree(t *testing.T) {
+	nodes := []*TreeNode{
+		&TreeNode{Node: Node{Id: 1, Label: "root", Children: RelationToMany{2, 3}}},
+		&TreeNode{Node: Node{Id: 2, Label: "a", Parent: OptionalId{1, true}, Children: RelationToMany{4}}, Depth: 1},
+		&TreeNode{Node: Node{Id: 4, Label: "aa", Parent: OptionalId{2, true}, Children: RelationToMany{}}, Depth: 2},
+		&TreeNode{Node: Node{Id: 3, Label: "b", Parent: OptionalId{1, true}, Children: RelationToMany{}, Metrics: RelationToMany{7}}, Depth: 1},
+	}
+	expected := `{"id":1,"label":"root","inheritMetrics":false,"links":{"children":[2,3],"parent":null},"depth":0,"subtree":[` +
+		`{"id":2,"label":"a","inheritMetrics":false,"links":{"children":[4],"parent":1},"depth":1,"subtree":[` +
+		`{"id":4,"label":"aa","inheritMetrics":false,"links":{"children":[],"parent":2},"depth":2}]},` +
+		`{"id":3,"label":"b","inheritMetrics":false,"links":{"children":[],"metrics":[7],"parent":1},"depth":1}]}`
+	j, err := json.Marshal(NestTree(nodes))
+	if err != nil {
+		t.Errorf("Unexpected Error: %s", err)
+	} else if string(j) != expected {
+		t.Errorf("Unexpected result:\n%s\n expected:\n%s\n", j, expected)
+	}
+	if root := NestTree([]*TreeNode{}); root != nil {
+		t.Errorf("Expected nil root for empty tree, but got %+v", root)
+	}
+}
@@ -0,0 +1,84 @@
+package types
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Validator is implemented by resources which can check their content before they are stored. Validate reports all invalid fields at once as ValidationError.
+type Validator interface {
+	Validate() error // Validate returns a ValidationError listing all invalid fields or nil if the resource is valid.
+}
+
+// Validate validates r if it implements the Validator interface. Other resources are always valid.
+func Validate(r Resource) error {
+	if v, ok := r.(Validator); ok {
+		return v.Validate()
+	}
+	return nil
+}
+
+// FieldError describes why the value of a field is invalid. The field is given as JSON Pointer into the JSON representation of the resource, like "/values/1/label".
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError reports all invalid fields of a resource. It is reported with status 422 Unprocessable Entity.
+type ValidationError []FieldError
+
+// Add adds an error for field with a message formatted like fmt.Sprintf.
+func (v *ValidationError) Add(field, format string, args ...interface{}) {
+	*v = append(*v, FieldError{field, fmt.Sprintf(format, args...)})
+}
+
+// Err returns v as error if it contains any field errors and nil otherwise.
+func (v ValidationError) Err() error {
+	if len(v) == 0 {
+		return nil
+	}
+	return v
+}
+
+// Error satisfies the HttpError interface
+func (v ValidationError) Error() string {
+	msgs := make([]string, len(v))
+	for i, e := range v {
+		msgs[i] = e.Field + ": " + e.Message
+	}
+	return "Invalid fields " + strings.Join(msgs, "; ")
+}
+
+// Status satisfies the HttpError interface
+func (v ValidationError) Status() int {
+	return http.StatusUnprocessableEntity
+}
+
+// Code satisfies the CodedError interface
+func (v ValidationError) Code() string {
+	return "invalid_fields"
+}
+
+// ProblemMembers satisfies the ProblemExtender interface. The invalid fields are listed as "fields".
+func (v ValidationError) ProblemMembers() map[string]interface{} {
+	return map[string]interface{}{"fields": []FieldError(v)}
+}
+
+// validateLabel adds an error to v if label is blank and for all blank translations in labels. The fields are "label" and "labels" below the JSON Pointer prefix.
+func (v *ValidationError) validateLabel(prefix string, label Label, labels Labels) {
+	if strings.TrimSpace(string(label)) == "" {
+		v.Add(prefix+"/label", "The label must not be empty.")
+	}
+	langs := make([]string, 0, len(labels))
+	for lang := range labels {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	for _, lang := range langs {
+		if strings.TrimSpace(string(labels[lang])) == "" {
+			v.Add(prefix+"/labels/"+lang, "The label in %s must not be empty.", lang)
+		}
+	}
+}